@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+
+	"github.com/spf13/pflag"
+)
+
+// explainCommand writes how the references are resolved by the rules without building them,
+// it exits with 1 if a reference matches no rule.
+//
+//	jitdi explain -c ./test/file.yaml llama-cpp/llama-2:full-7b-chat-Q2_K-gguf
+func explainCommand(ctx context.Context, logger *slog.Logger, args []string) {
+	_ = pflag.CommandLine.Parse(args)
+
+	if pflag.NArg() == 0 {
+		logger.Error("usage: jitdi explain [flags] <repo>:<tag>...")
+		os.Exit(1)
+	}
+
+	h, err := newHandler(logger)
+	if err != nil {
+		logger.Error("failed to NewHandler", "err", err)
+		os.Exit(1)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	unmatched := false
+	for _, ref := range pflag.Args() {
+		e := h.Explain(ref)
+		if e.Rule == "" {
+			logger.Error("no rule matches", "ref", ref)
+			unmatched = true
+		}
+		err = enc.Encode(e)
+		if err != nil {
+			logger.Error("failed to Encode", "err", err)
+			os.Exit(1)
+		}
+	}
+	if unmatched {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/spf13/pflag"
+
+	"github.com/wzshiming/jitdi/pkg/atomic"
+	"github.com/wzshiming/jitdi/pkg/handler"
+)
+
+// exportCommand builds the image and writes it as a tarball.
+//
+//	jitdi export -c ./test/file.yaml k8s/alpine/kubectl:v1.29.3 --platform linux/arm64 -o kubectl.tar
+func exportCommand(ctx context.Context, logger *slog.Logger, args []string) {
+	var (
+		output   string
+		format   string
+		platform string
+		name     string
+	)
+	pflag.StringVarP(&output, "output", "o", "", "output file, defaults to stdout")
+	pflag.StringVar(&format, "format", handler.ExportFormatDocker, "format of the tarball, docker or oci")
+	pflag.StringVar(&platform, "platform", "", "platform of the image, e.g. linux/amd64")
+	pflag.StringVar(&name, "name", "", "image name in the tarball, defaults to the reference")
+	_ = pflag.CommandLine.Parse(args)
+
+	if pflag.NArg() != 1 {
+		logger.Error("usage: jitdi export [flags] <repo>:<tag>")
+		os.Exit(1)
+	}
+	ref := pflag.Arg(0)
+
+	opts := handler.ExportOptions{
+		Format: format,
+		Name:   name,
+	}
+	if platform != "" {
+		p, err := v1.ParsePlatform(platform)
+		if err != nil {
+			logger.Error("failed to ParsePlatform", "err", err)
+			os.Exit(1)
+		}
+		opts.Platform = p
+	}
+
+	h, err := newHandler(logger)
+	if err != nil {
+		logger.Error("failed to NewHandler", "err", err)
+		os.Exit(1)
+	}
+
+	if output != "" {
+		f, err := atomic.OpenFileWithWriter(output, 0644)
+		if err != nil {
+			logger.Error("failed to open output", "err", err)
+			os.Exit(1)
+		}
+		err = h.Export(ctx, f, ref, opts)
+		if err != nil {
+			_ = f.Abort()
+			logger.Error("failed to Export", "err", err)
+			os.Exit(1)
+		}
+		err = f.Close()
+		if err != nil {
+			logger.Error("failed to write output", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	err = h.Export(ctx, os.Stdout, ref, opts)
+	if err != nil {
+		logger.Error("failed to Export", "err", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/spf13/pflag"
+)
+
+// importCommand seeds the offline directory with the base images in docker or OCI tarballs.
+//
+//	docker save alpine:3.19 -o alpine.tar
+//	jitdi import alpine.tar
+func importCommand(ctx context.Context, logger *slog.Logger, args []string) {
+	_ = pflag.CommandLine.Parse(args)
+
+	if pflag.NArg() == 0 {
+		logger.Error("usage: jitdi import [flags] <tarball>...")
+		os.Exit(1)
+	}
+
+	h, err := newHandler(logger)
+	if err != nil {
+		logger.Error("failed to NewHandler", "err", err)
+		os.Exit(1)
+	}
+
+	for _, file := range pflag.Args() {
+		names, err := h.Import(ctx, file)
+		if err != nil {
+			logger.Error("failed to Import", "err", err, "file", file)
+			os.Exit(1)
+		}
+		for _, name := range names {
+			logger.Info("imported", "image", name, "file", file)
+		}
+	}
+}
@@ -10,25 +10,65 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"path"
+	"strings"
+	"time"
 
 	"github.com/gorilla/handlers"
 	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 
 	"github.com/wzshiming/jitdi/pkg/apis/v1alpha1"
+	"github.com/wzshiming/jitdi/pkg/auth"
+	"github.com/wzshiming/jitdi/pkg/certs"
 	"github.com/wzshiming/jitdi/pkg/client/clientset/versioned"
 	"github.com/wzshiming/jitdi/pkg/handler"
+	"github.com/wzshiming/jitdi/pkg/metrics"
+	"github.com/wzshiming/jitdi/pkg/signer"
+	"github.com/wzshiming/jitdi/pkg/tracing"
 )
 
 var (
 	address         string
 	cache           string
+	cacheFormat     string
 	storageRegistry string
+	storageURL      string
+	offline         bool
+	offlineDir      string
+	provenanceDir   string
+	artifactPush    bool
+	signingKey      string
+	verifyOnServe   bool
+	verifyInterval  time.Duration
+	otlpEndpoint    string
+	eventLog        string
+	kubeEvents      bool
+
+	tlsCert         string
+	tlsKey          string
+	tlsClientCA     string
+	tlsBootstrapDir string
+	tlsHosts        []string
+
+	webhookAddress  string
+	webhookTLSCert  string
+	webhookTLSKey   string
+	webhookClientCA string
+
+	maxConcurrentBuilds int
+	maxBuildDuration    time.Duration
+	maxOutputSize       string
+	clientRateLimit     float64
+	clientRateBurst     int
 
 	config     []string
+	authConfig string
 	kubeconfig string
 	master     string
 )
@@ -36,13 +76,57 @@ var (
 func init() {
 	pflag.StringVar(&address, "address", ":8888", "listen on the address")
 
+	pflag.StringVar(&tlsCert, "tls-cert", "", "serve TLS with the certificate file, reloaded on change")
+	pflag.StringVar(&tlsKey, "tls-key", "", "key file of the TLS certificate")
+	pflag.StringVar(&tlsClientCA, "tls-client-ca", "", "verify the client certificates with the CA bundle file")
+	pflag.StringVar(&tlsBootstrapDir, "tls-bootstrap-dir", "", "serve TLS with a self-signed certificate written into the directory, with the ca.crt for the nodes to trust")
+	pflag.StringSliceVar(&tlsHosts, "tls-hosts", []string{"localhost", "127.0.0.1"}, "hosts of the self-signed certificate")
+
+	pflag.StringVar(&webhookAddress, "webhook-address", "", "serve the validating admission webhook over TLS on the address, e.g. :8443")
+	pflag.StringVar(&webhookTLSCert, "webhook-tls-cert", "", "serve the webhook with the certificate file, reloaded on change, defaults to the certificate of --tls-cert or --tls-bootstrap-dir")
+	pflag.StringVar(&webhookTLSKey, "webhook-tls-key", "", "key file of the webhook certificate")
+	pflag.StringVar(&webhookClientCA, "webhook-client-ca", "", "verify the client certificates of the webhook, of the kube-apiserver, with the CA bundle file")
+
 	pflag.StringVar(&cache, "cache", "./cache", "cache directory")
+	pflag.StringVar(&cacheFormat, "cache-format", "jitdi", "layout of the cache directory, jitdi or oci")
 	pflag.StringVar(&storageRegistry, "storage-registry", "", "storage registry")
+	pflag.StringVar(&storageURL, "storage", "", "storage url, e.g. file:///var/cache/jitdi, oci:///var/lib/jitdi, s3://bucket/prefix?endpoint=minio:9000, registry://registry.local:5000/prefix")
+
+	pflag.BoolVar(&offline, "offline", false, "never touch the network when building, resolve dependencies from the offline directory")
+	pflag.StringVar(&offlineDir, "offline-dir", "", "offline directory, defaults to the offline in the cache directory, required without it")
+	pflag.StringVar(&provenanceDir, "provenance-dir", "", "provenance directory to rebuild the digests missing from the storage, defaults to the provenance in the cache directory, required without it")
+
+	pflag.BoolVar(&artifactPush, "artifact-push", false, "allow pushing the artifacts referring to the images, such as signatures, SBOMs and attestations")
+
+	pflag.StringVar(&signingKey, "signing-key", "", "sign the built images with the cosign private key file or k8s://<namespace>/<secret>, the password is read from COSIGN_PASSWORD")
+
+	pflag.BoolVar(&verifyOnServe, "verify-on-serve", false, "re-hash each cached blob the first time it is served after restart")
+	pflag.DurationVar(&verifyInterval, "verify-interval", 0, "re-hash all cached blobs on the interval, 0 disables it")
+
+	pflag.StringVar(&eventLog, "event-log", "", "append the build events as JSON lines to the file, defaults to the events.jsonl in the cache directory")
+	pflag.BoolVar(&kubeEvents, "kube-events", false, "emit the build events as the Kubernetes Events of the Image resources")
+
+	pflag.StringVar(&otlpEndpoint, "otlp-endpoint", "", "export the traces by OTLP over HTTP to the endpoint, e.g. http://localhost:4318, defaults to OTEL_EXPORTER_OTLP_ENDPOINT")
+
+	pflag.IntVar(&maxConcurrentBuilds, "max-concurrent-builds", 0, "maximum number of the concurrent builds, 0 is unlimited")
+	pflag.DurationVar(&maxBuildDuration, "max-build-duration", 0, "maximum duration of a build, 0 is unlimited")
+	pflag.StringVar(&maxOutputSize, "max-output-size", "", "maximum size of the blobs written by a build, e.g. 20Gi")
+	pflag.Float64Var(&clientRateLimit, "client-rate-limit", 0, "builds per minute triggered by each client, 0 is unlimited")
+	pflag.IntVar(&clientRateBurst, "client-rate-burst", 1, "burst of the builds triggered by each client")
 
 	pflag.StringSliceVarP(&config, "config", "c", nil, "config file")
+	pflag.StringVar(&authConfig, "auth-config", "", "authentication and access control config file")
 	pflag.StringVar(&kubeconfig, "kubeconfig", "", "kubeconfig file")
 	pflag.StringVar(&master, "master", "", "master url")
-	pflag.Parse()
+}
+
+// commands are the subcommands, they share the flags of the handler.
+var commands = map[string]func(ctx context.Context, logger *slog.Logger, args []string){
+	"explain":  explainCommand,
+	"export":   exportCommand,
+	"import":   importCommand,
+	"validate": validateCommand,
+	"verify":   verifyCommand,
 }
 
 func main() {
@@ -50,69 +134,281 @@ func main() {
 
 	logger := slog.Default()
 
-	staticImageConfig, staticRegistryConfig, err := loadConfigFile(config...)
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			command(ctx, logger, os.Args[2:])
+			return
+		}
+	}
+
+	pflag.Parse()
+
+	shutdown, err := tracing.Setup(ctx, otlpEndpoint)
 	if err != nil {
-		logger.Error("failed to load config", "err", err)
+		logger.Error("failed to setup tracing", "err", err)
 		os.Exit(1)
 	}
+	defer shutdown(context.Background())
 
-	var clientset *versioned.Clientset
-	if kubeconfig != "" {
-		clientConfig, err := clientcmd.BuildConfigFromFlags(master, kubeconfig)
+	h, err := newHandler(logger)
+	if err != nil {
+		logger.Error("failed to NewHandler", "err", err)
+		os.Exit(1)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/v2/", h)
+	mux.Handle("/jitdi/", h)
+	mux.Handle("/healthz", h)
+	mux.Handle("/readyz", h)
+	mux.Handle("/debug/builds", h)
+	mux.Handle("/metrics", metrics.Handler())
+
+	server := http.Server{
+		BaseContext: func(listener net.Listener) context.Context {
+			return ctx
+		},
+		Handler: handlers.LoggingHandler(os.Stderr, metrics.InstrumentHandler(handler.Route, mux)),
+		Addr:    address,
+	}
+
+	if tlsCert == "" && tlsBootstrapDir != "" {
+		tlsCert, tlsKey, err = certs.Bootstrap(tlsBootstrapDir, tlsHosts)
 		if err != nil {
-			logger.Error("failed to BuildConfigFromFlags", "err", err)
+			logger.Error("failed to bootstrap certificate", "err", err)
 			os.Exit(1)
 		}
-		clientset, err = versioned.NewForConfig(clientConfig)
+		logger.Info("Bootstrapped self-signed certificate", "ca", path.Join(tlsBootstrapDir, certs.CAFile))
+	}
+
+	if webhookAddress != "" {
+		err = serveWebhook(ctx, logger, h)
 		if err != nil {
-			logger.Error("failed to NewForConfig", "err", err)
+			logger.Error("failed to serve the webhook", "err", err)
 			os.Exit(1)
 		}
+	}
+
+	if tlsCert == "" {
+		err = server.ListenAndServe()
+		if err != nil {
+			logger.Error("failed to ListenAndServe", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	reloader, err := certs.NewReloader(tlsCert, tlsKey,
+		certs.WithClientCA(tlsClientCA),
+		certs.WithLogger(logger),
+	)
+	if err != nil {
+		logger.Error("failed to NewReloader", "err", err)
+		os.Exit(1)
+	}
+	reloader.Start(ctx)
+	server.TLSConfig = reloader.TLSConfig()
+
+	err = server.ListenAndServeTLS("", "")
+	if err != nil {
+		logger.Error("failed to ListenAndServeTLS", "err", err)
+		os.Exit(1)
+	}
+}
+
+// serveWebhook serves the validating admission webhook on its own listener in the background,
+// only the clients with a certificate of the --webhook-client-ca, the kube-apiserver, are served.
+func serveWebhook(ctx context.Context, logger *slog.Logger, h *handler.Handler) error {
+	cert, key := webhookTLSCert, webhookTLSKey
+	if cert == "" {
+		cert, key = tlsCert, tlsKey
+	}
+	if cert == "" {
+		return fmt.Errorf("--webhook-address requires --webhook-tls-cert, --tls-cert or --tls-bootstrap-dir")
+	}
+	if webhookClientCA == "" {
+		return fmt.Errorf("--webhook-address requires --webhook-client-ca")
+	}
+
+	reloader, err := certs.NewReloader(cert, key,
+		certs.WithClientCA(webhookClientCA),
+		certs.WithLogger(logger),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to NewReloader: %w", err)
+	}
+	reloader.Start(ctx)
+
+	server := http.Server{
+		BaseContext: func(listener net.Listener) context.Context {
+			return ctx
+		},
+		Handler:   handlers.LoggingHandler(os.Stderr, h.WebhookHandler()),
+		Addr:      webhookAddress,
+		TLSConfig: reloader.TLSConfig(),
+	}
+	go func() {
+		err := server.ListenAndServeTLS("", "")
+		logger.Error("failed to ListenAndServeTLS of the webhook", "err", err)
+		os.Exit(1)
+	}()
+	return nil
+}
 
+func newHandler(logger *slog.Logger) (*handler.Handler, error) {
+	staticImageConfig, staticRegistryConfig, err := loadConfigFile(config...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	var clientConfig *rest.Config
+	if kubeconfig != "" {
+		clientConfig, err = clientcmd.BuildConfigFromFlags(master, kubeconfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to BuildConfigFromFlags: %w", err)
+		}
 	} else {
 		if master == "" {
 			logger.Info("Neither --kubeconfig nor --master was specified")
 			logger.Info("Using the inClusterConfig")
 		}
-		clientConfig, err := rest.InClusterConfig()
+		clientConfig, err = rest.InClusterConfig()
 		if err != nil {
 			logger.Warn("failed to InClusterConfig", "err", err)
-		} else {
-			clientset, err = versioned.NewForConfig(clientConfig)
-			if err != nil {
-				logger.Error("failed to NewForConfig", "err", err)
+		}
+	}
+
+	var clientset *versioned.Clientset
+	if clientConfig != nil {
+		clientset, err = versioned.NewForConfig(clientConfig)
+		if err != nil {
+			if kubeconfig != "" {
+				return nil, fmt.Errorf("failed to NewForConfig: %w", err)
 			}
+			logger.Error("failed to NewForConfig", "err", err)
 		}
 	}
 
-	mux := http.NewServeMux()
-	h, err := handler.NewHandler(
+	var eventsClientset kubernetes.Interface
+	if kubeEvents {
+		if clientConfig == nil {
+			return nil, fmt.Errorf("--kube-events requires --kubeconfig or the inClusterConfig")
+		}
+		eventsClientset, err = kubernetes.NewForConfig(clientConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to NewForConfig: %w", err)
+		}
+	}
+
+	var outputSize int64
+	if maxOutputSize != "" {
+		q, err := resource.ParseQuantity(maxOutputSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse --max-output-size: %w", err)
+		}
+		outputSize = q.Value()
+	}
+
+	var a *auth.Auth
+	if authConfig != "" {
+		a, err = newAuth(clientConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var sig *signer.Signer
+	if signingKey != "" {
+		sig, err = newSigner(clientConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return handler.NewHandler(
 		handler.WithCache(cache),
+		handler.WithCacheFormat(cacheFormat),
 		handler.WithStorageRegistry(storageRegistry),
+		handler.WithStorage(storageURL),
+		handler.WithOffline(offline),
+		handler.WithOfflinePath(offlineDir),
+		handler.WithProvenancePath(provenanceDir),
+		handler.WithArtifactPush(artifactPush),
+		handler.WithSigner(sig),
+		handler.WithVerifyOnServe(verifyOnServe),
+		handler.WithVerifyInterval(verifyInterval),
 		handler.WithClientset(clientset),
+		handler.WithEventLogPath(eventLog),
+		handler.WithKubeEvents(eventsClientset),
+		handler.WithAuth(a),
+		handler.WithMaxConcurrentBuilds(maxConcurrentBuilds),
+		handler.WithMaxBuildDuration(maxBuildDuration),
+		handler.WithMaxOutputSize(outputSize),
+		handler.WithClientRateLimit(clientRateLimit/60, clientRateBurst),
 		handler.WithImageConfig(staticImageConfig),
 		handler.WithRegistryConfig(staticRegistryConfig),
 	)
+}
+
+func newAuth(clientConfig *rest.Config) (*auth.Auth, error) {
+	conf, err := auth.LoadConfig(authConfig)
 	if err != nil {
-		logger.Error("failed to NewHandler", "err", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("failed to load auth config: %w", err)
 	}
 
-	mux.Handle("/v2/", h)
+	var reviewer auth.TokenReviewer
+	if conf.Kubernetes != nil {
+		if clientConfig == nil {
+			return nil, fmt.Errorf("kubernetes of the auth config requires --kubeconfig or the inClusterConfig")
+		}
+		clientset, err := kubernetes.NewForConfig(clientConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to NewForConfig: %w", err)
+		}
+		reviewer = auth.NewTokenReviewer(clientset, conf.Kubernetes.Audiences)
+	}
+	return auth.NewAuth(conf, auth.WithTokenReviewer(reviewer))
+}
 
-	server := http.Server{
-		BaseContext: func(listener net.Listener) context.Context {
-			return ctx
-		},
-		Handler: handlers.LoggingHandler(os.Stderr, mux),
-		Addr:    address,
+// newSigner loads the private key of --signing-key, from the file or the secret created by
+// cosign generate-key-pair k8s://<namespace>/<secret>.
+func newSigner(clientConfig *rest.Config) (*signer.Signer, error) {
+	password := []byte(os.Getenv("COSIGN_PASSWORD"))
+
+	var data []byte
+	if secret, ok := strings.CutPrefix(signingKey, "k8s://"); ok {
+		namespace, secretName, ok := strings.Cut(secret, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid --signing-key %q, expected k8s://<namespace>/<secret>", signingKey)
+		}
+		if clientConfig == nil {
+			return nil, fmt.Errorf("--signing-key of the secret requires --kubeconfig or the inClusterConfig")
+		}
+		clientset, err := kubernetes.NewForConfig(clientConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to NewForConfig: %w", err)
+		}
+		s, err := clientset.CoreV1().Secrets(namespace).Get(context.Background(), secretName, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the secret of --signing-key: %w", err)
+		}
+		data = s.Data["cosign.key"]
+		if p, ok := s.Data["cosign.password"]; ok {
+			password = p
+		}
+	} else {
+		d, err := os.ReadFile(signingKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read --signing-key: %w", err)
+		}
+		data = d
 	}
 
-	err = server.ListenAndServe()
+	s, err := signer.LoadKey(data, password)
 	if err != nil {
-		logger.Error("failed to ListenAndServe", "err", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("failed to load --signing-key: %w", err)
 	}
+	return s, nil
 }
 
 func loadConfigFile(path ...string) ([]*v1alpha1.Image, []*v1alpha1.Registry, error) {
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/spf13/pflag"
+
+	"github.com/wzshiming/jitdi/pkg/apis/v1alpha1"
+	"github.com/wzshiming/jitdi/pkg/validation"
+)
+
+// validateCommand checks the config files, it exits with 1 if any of them is invalid,
+// the ambiguous matches are only warned.
+//
+//	jitdi validate -c ./test/file.yaml -c ./test/ollama.yaml
+func validateCommand(ctx context.Context, logger *slog.Logger, args []string) {
+	_ = pflag.CommandLine.Parse(args)
+
+	files := append(config, pflag.Args()...)
+	if len(files) == 0 {
+		logger.Error("usage: jitdi validate -c <file>...")
+		os.Exit(1)
+	}
+
+	invalid := false
+	var images []*v1alpha1.Image
+	imageFiles := map[*v1alpha1.Image]string{}
+	for _, file := range files {
+		imgs, regs, err := loadConfigFile(file)
+		if err != nil {
+			logger.Error("failed to load config", "file", file, "err", err)
+			invalid = true
+			continue
+		}
+		for _, img := range imgs {
+			warnings, err := validation.Image(&img.Spec)
+			for _, warning := range warnings {
+				logger.Warn(warning, "file", file, "image", img.Name)
+			}
+			if err != nil {
+				logger.Error("invalid Image", "file", file, "image", img.Name, "err", err)
+				invalid = true
+			}
+			images = append(images, img)
+			imageFiles[img] = file
+		}
+		for _, reg := range regs {
+			err := validation.Registry(reg.Name, &reg.Spec)
+			if err != nil {
+				logger.Error("invalid Registry", "file", file, "registry", reg.Name, "err", err)
+				invalid = true
+			}
+		}
+	}
+
+	for i, img := range images {
+		for _, warning := range validation.Ambiguities(img, images[i+1:]) {
+			logger.Warn(warning, "file", imageFiles[img], "image", img.Name)
+		}
+	}
+
+	if invalid {
+		os.Exit(1)
+	}
+	logger.Info("valid", "files", len(files), "images", len(images))
+}
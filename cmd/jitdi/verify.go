@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/spf13/pflag"
+)
+
+// verifyCommand rebuilds the image from its provenance and asserts the digest is identical.
+//
+//	jitdi verify -c ./test/file.yaml k8s/alpine/kubectl:v1.29.3
+//	jitdi verify -c ./test/file.yaml k8s/alpine/kubectl@sha256:...
+func verifyCommand(ctx context.Context, logger *slog.Logger, args []string) {
+	_ = pflag.CommandLine.Parse(args)
+
+	if pflag.NArg() == 0 {
+		logger.Error("usage: jitdi verify [flags] <repo>:<tag>|<repo>@<digest>...")
+		os.Exit(1)
+	}
+
+	h, err := newHandler(logger)
+	if err != nil {
+		logger.Error("failed to NewHandler", "err", err)
+		os.Exit(1)
+	}
+
+	failed := false
+	for _, ref := range pflag.Args() {
+		p, err := h.Reproduce(ctx, ref)
+		if err != nil {
+			logger.Error("failed to Reproduce", "err", err, "ref", ref)
+			failed = true
+			continue
+		}
+		logger.Info("reproduced", "ref", ref, "digest", p.Digest, "base", p.Base, "inputs", len(p.Inputs))
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -1,5 +1,7 @@
 //+kubebuilder:object:generate=true
 //+groupName=jitdi.zsm.io
+//+kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+//+kubebuilder:rbac:groups="",resources=events,verbs=create
 
 package v1alpha1
 
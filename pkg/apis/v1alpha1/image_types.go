@@ -1,6 +1,7 @@
 package v1alpha1
 
 import (
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -15,6 +16,7 @@ const (
 // +kubebuilder:subresource:status
 // +kubebuilder:resource:scope=Cluster
 // +kubebuilder:rbac:groups=jitdi.zsm.io,resources=images,verbs=create;delete;get;list;patch;update;watch
+// +kubebuilder:rbac:groups=jitdi.zsm.io,resources=images/status,verbs=get;patch;update
 
 // Image is the Schema for the images API
 type Image struct {
@@ -45,6 +47,42 @@ type ImageSpec struct {
 	BaseImage string     `json:"baseImage,omitempty"`
 	Mutates   []Mutate   `json:"mutates,omitempty"`
 	Platforms []Platform `json:"platforms,omitempty"`
+	Limits    *Limits    `json:"limits,omitempty"`
+	Sources   *Sources   `json:"sources,omitempty"`
+	// Verification is the verification of the base images of the rule.
+	Verification *Verification `json:"verification,omitempty"`
+}
+
+// Verification holds the verification of the base images,
+// the base image is verified if its digest is allowed or it has a cosign signature of a public key
+type Verification struct {
+	// PublicKeys is the PEM public keys of the cosign signatures.
+	PublicKeys []string `json:"publicKeys,omitempty"`
+	// Digests is the allowed digests of the base images, they are not required to be signed.
+	Digests []string `json:"digests,omitempty"`
+}
+
+// Sources holds the constraints of the parameters and the sources
+type Sources struct {
+	// Params is the regular expressions the parameters must fully match, or the rule does not match.
+	Params map[string]string `json:"params,omitempty"`
+	// Schemes is the allowed schemes of the file sources, "file" for the local paths.
+	Schemes []string `json:"schemes,omitempty"`
+	// Hosts is the allowed hosts of the file sources, the registries of the base images and the ollama models,
+	// they are patterns like "{name}.example.com".
+	Hosts []string `json:"hosts,omitempty"`
+	// LocalRoots is the allowed root directories of the local file sources.
+	LocalRoots []string `json:"localRoots,omitempty"`
+}
+
+// Limits holds the admission limits of the builds
+type Limits struct {
+	// MaxConcurrentBuilds is the maximum number of the concurrent builds, 0 is unlimited.
+	MaxConcurrentBuilds int `json:"maxConcurrentBuilds,omitempty"`
+	// MaxBuildDuration is the maximum duration of a build.
+	MaxBuildDuration *metav1.Duration `json:"maxBuildDuration,omitempty"`
+	// MaxOutputSize is the maximum size of the blobs written by a build.
+	MaxOutputSize *resource.Quantity `json:"maxOutputSize,omitempty"`
 }
 
 type Platform struct {
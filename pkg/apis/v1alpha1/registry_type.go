@@ -44,6 +44,8 @@ type RegistrySpec struct {
 	Endpoint       string          `json:"endpoint,omitempty"`
 	Insecure       bool            `json:"insecure,omitempty"`
 	Authentication *Authentication `json:"authentication,omitempty"`
+	// Verification is the verification of the base images from the registry.
+	Verification *Verification `json:"verification,omitempty"`
 }
 
 type Authentication struct {
@@ -0,0 +1,383 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	"github.com/wzshiming/jitdi/pkg/pattern"
+)
+
+const (
+	// ActionPull is the action to pull the images.
+	ActionPull = "pull"
+	// ActionPush is the action to push the artifacts referring to the images.
+	ActionPush = "push"
+
+	// GroupAuthenticated is the group of all authenticated identities.
+	GroupAuthenticated = "system:authenticated"
+)
+
+var (
+	// ErrUnauthorized is returned when the request is not authenticated,
+	// the client should retry with the credentials of the challenge.
+	ErrUnauthorized = errors.New("authentication required")
+	// ErrDenied is returned when the identity is not allowed by the policies.
+	ErrDenied = errors.New("access denied")
+)
+
+// Anonymous is the identity of the requests without credentials.
+var Anonymous = &Identity{
+	Name:   "system:anonymous",
+	Groups: []string{"system:unauthenticated"},
+}
+
+// Identity is the authenticated user.
+type Identity struct {
+	Name   string
+	Groups []string
+}
+
+// Config is the configuration of the authentication.
+type Config struct {
+	// Token enables the registry token authentication with the built-in token issuer,
+	// otherwise the basic authentication is used.
+	Token *TokenConfig `json:"token,omitempty"`
+	// Htpasswd is the path of the htpasswd file, only bcrypt passwords are supported.
+	Htpasswd string `json:"htpasswd,omitempty"`
+	// Kubernetes enables the Kubernetes ServiceAccount tokens with TokenReview,
+	// the token is used as the password or the bearer token.
+	Kubernetes *KubernetesConfig `json:"kubernetes,omitempty"`
+	// Policies are the identities allowed to access the repositories.
+	Policies []Policy `json:"policies,omitempty"`
+}
+
+// TokenConfig is the configuration of the built-in token issuer.
+type TokenConfig struct {
+	// Realm is the URL of the token endpoint, defaults to /jitdi/token of the requested host.
+	Realm string `json:"realm,omitempty"`
+	// Service is the name of the registry service, defaults to jitdi.
+	Service string `json:"service,omitempty"`
+	// Issuer is the issuer of the tokens, defaults to jitdi.
+	Issuer string `json:"issuer,omitempty"`
+	// KeyFile is the path of the key to sign the tokens,
+	// a random key is generated if empty, the tokens are then invalid after restart.
+	KeyFile string `json:"keyFile,omitempty"`
+	// Expiration is the lifetime of the tokens, defaults to 5m.
+	Expiration metav1.Duration `json:"expiration,omitempty"`
+}
+
+// KubernetesConfig is the configuration of the TokenReview.
+type KubernetesConfig struct {
+	// Audiences are the audiences of the ServiceAccount tokens.
+	Audiences []string `json:"audiences,omitempty"`
+}
+
+// Policy allows the identities to access the repositories.
+type Policy struct {
+	// Users are the patterns of the identity names, e.g. "alice" or "system:serviceaccount:ci:{name}".
+	Users []string `json:"users,omitempty"`
+	// Groups are the patterns of the identity groups, e.g. "system:authenticated".
+	Groups []string `json:"groups,omitempty"`
+	// Repositories are the patterns of the repositories, e.g. "k8s/{image}".
+	Repositories []string `json:"repositories"`
+	// Actions are the allowed actions, defaults to pull.
+	Actions []string `json:"actions,omitempty"`
+}
+
+// LoadConfig reads the configuration from the YAML or JSON file.
+func LoadConfig(file string) (*Config, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	conf := &Config{}
+	err = yaml.NewYAMLOrJSONDecoder(f, 4096).Decode(conf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %q: %w", file, err)
+	}
+	return conf, nil
+}
+
+// Auth authenticates and authorizes the requests of the registry API.
+type Auth struct {
+	token    *tokenIssuer
+	htpasswd htpasswd
+	reviewer TokenReviewer
+	policies []*policy
+	// anonymous reports whether any policy allows the Anonymous.
+	anonymous bool
+}
+
+type option func(*Auth)
+
+// WithTokenReviewer sets the reviewer of the Kubernetes ServiceAccount tokens.
+func WithTokenReviewer(reviewer TokenReviewer) option {
+	return func(a *Auth) {
+		a.reviewer = reviewer
+	}
+}
+
+// NewAuth returns the Auth of the configuration.
+func NewAuth(conf *Config, opts ...option) (*Auth, error) {
+	a := &Auth{}
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	if conf.Token != nil {
+		t, err := newTokenIssuer(conf.Token)
+		if err != nil {
+			return nil, err
+		}
+		a.token = t
+	}
+
+	if conf.Htpasswd != "" {
+		h, err := loadHtpasswd(conf.Htpasswd)
+		if err != nil {
+			return nil, err
+		}
+		a.htpasswd = h
+	}
+
+	for _, p := range conf.Policies {
+		np, err := newPolicy(p)
+		if err != nil {
+			return nil, err
+		}
+		a.policies = append(a.policies, np)
+		if np.matchIdentity(Anonymous) {
+			a.anonymous = true
+		}
+	}
+	return a, nil
+}
+
+// Authorize checks the request is allowed to do the action on the repository,
+// an empty repository only requires the request to be authenticated, or any policy allowing the Anonymous.
+func (a *Auth) Authorize(r *http.Request, repo, action string) error {
+	_, err := a.AuthorizeIdentity(r, repo, action)
+	return err
+}
+
+// AuthorizeIdentity is Authorize returning the identity of the request,
+// the identity of the tokens issued by us has only the name of the subject.
+func (a *Auth) AuthorizeIdentity(r *http.Request, repo, action string) (*Identity, error) {
+	if a.token != nil {
+		return a.authorizeToken(r, repo, action)
+	}
+
+	id, err := a.authenticate(r)
+	if err != nil {
+		return nil, err
+	}
+	if repo == "" {
+		// The Anonymous is allowed to ping the registry if it may pull any repository.
+		if id == Anonymous && !a.anonymous {
+			return nil, ErrUnauthorized
+		}
+		return id, nil
+	}
+	if !a.allowed(id, repo, action) {
+		if id == Anonymous {
+			return nil, ErrUnauthorized
+		}
+		return nil, ErrDenied
+	}
+	return id, nil
+}
+
+// Authorizer returns the check of the actions on the repositories for the request,
+// the credentials are verified once, so it is for checking many repositories, e.g. to filter a list.
+func (a *Auth) Authorizer(r *http.Request) func(repo, action string) bool {
+	deny := func(repo, action string) bool {
+		return false
+	}
+
+	if a.token != nil {
+		bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			return deny
+		}
+		claims, err := a.token.verify(bearer, time.Now())
+		if err == nil {
+			return claims.allows
+		}
+		if a.token.issued(bearer) {
+			return deny
+		}
+		id, err := a.review(r.Context(), bearer)
+		if err != nil {
+			return deny
+		}
+		return func(repo, action string) bool {
+			return a.allowed(id, repo, action)
+		}
+	}
+
+	id, err := a.authenticate(r)
+	if err != nil {
+		return deny
+	}
+	return func(repo, action string) bool {
+		return a.allowed(id, repo, action)
+	}
+}
+
+func (a *Auth) authorizeToken(r *http.Request, repo, action string) (*Identity, error) {
+	bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil, ErrUnauthorized
+	}
+
+	claims, err := a.token.verify(bearer, time.Now())
+	if err != nil {
+		// The invalid or expired tokens issued by us are not reviewed.
+		if a.token.issued(bearer) {
+			return nil, fmt.Errorf("%w: %w", ErrUnauthorized, err)
+		}
+		// Not issued by us, try the ServiceAccount token.
+		id, err := a.review(r.Context(), bearer)
+		if err != nil {
+			return nil, err
+		}
+		if repo != "" && !a.allowed(id, repo, action) {
+			return nil, ErrDenied
+		}
+		return id, nil
+	}
+
+	if repo != "" && !claims.allows(repo, action) {
+		return nil, ErrUnauthorized
+	}
+	return &Identity{Name: claims.Subject}, nil
+}
+
+// Challenge sets the WWW-Authenticate header of the response.
+func (a *Auth) Challenge(w http.ResponseWriter, r *http.Request, repo, action string) {
+	if a.token == nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="jitdi"`)
+		return
+	}
+
+	challenge := fmt.Sprintf(`Bearer realm=%q,service=%q`, a.token.realm(r), a.token.service)
+	if repo != "" {
+		challenge += fmt.Sprintf(`,scope="repository:%s:%s"`, repo, action)
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+}
+
+// authenticate returns the identity of the basic or bearer credentials,
+// the requests without credentials are Anonymous.
+func (a *Auth) authenticate(r *http.Request) (*Identity, error) {
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return a.review(r.Context(), bearer)
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return Anonymous, nil
+	}
+
+	if a.htpasswd.verify(username, password) {
+		return &Identity{
+			Name:   username,
+			Groups: []string{GroupAuthenticated},
+		}, nil
+	}
+
+	// Only the tokens of the users not in the htpasswd are reviewed, the passwords are never sent to the API server.
+	if _, ok := a.htpasswd[username]; ok || !isJWT(password) {
+		return nil, ErrUnauthorized
+	}
+	return a.review(r.Context(), password)
+}
+
+func (a *Auth) review(ctx context.Context, token string) (*Identity, error) {
+	if a.reviewer == nil {
+		return nil, ErrUnauthorized
+	}
+	id, err := a.reviewer.Review(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnauthorized, err)
+	}
+	return id, nil
+}
+
+// allowed reports whether any policy allows the identity to do the action on the repository.
+func (a *Auth) allowed(id *Identity, repo, action string) bool {
+	return slices.ContainsFunc(a.policies, func(p *policy) bool {
+		return p.allows(id, repo, action)
+	})
+}
+
+type policy struct {
+	users        []*pattern.Matcher
+	groups       []*pattern.Matcher
+	repositories []*pattern.Matcher
+	actions      []string
+}
+
+func newPolicy(p Policy) (*policy, error) {
+	np := &policy{
+		actions: p.Actions,
+	}
+	if len(np.actions) == 0 {
+		np.actions = []string{ActionPull}
+	}
+
+	for _, list := range []struct {
+		patterns []string
+		matchers *[]*pattern.Matcher
+	}{
+		{p.Users, &np.users},
+		{p.Groups, &np.groups},
+		{p.Repositories, &np.repositories},
+	} {
+		for _, s := range list.patterns {
+			m, err := pattern.NewMatcher(s)
+			if err != nil {
+				return nil, err
+			}
+			*list.matchers = append(*list.matchers, m)
+		}
+	}
+	return np, nil
+}
+
+func (p *policy) allows(id *Identity, repo, action string) bool {
+	if !slices.Contains(p.actions, action) {
+		return false
+	}
+	if !matchAny(p.repositories, repo) {
+		return false
+	}
+	return p.matchIdentity(id)
+}
+
+// matchIdentity reports whether the identity is one of the users or in one of the groups of the policy.
+func (p *policy) matchIdentity(id *Identity) bool {
+	if matchAny(p.users, id.Name) {
+		return true
+	}
+	return slices.ContainsFunc(id.Groups, func(group string) bool {
+		return matchAny(p.groups, group)
+	})
+}
+
+func matchAny(matchers []*pattern.Matcher, s string) bool {
+	return slices.ContainsFunc(matchers, func(m *pattern.Matcher) bool {
+		return m.Match(s)
+	})
+}
@@ -0,0 +1,334 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// testSAToken is a ServiceAccount token authenticated by the TokenReview of newTestAuth.
+var testSAToken = strings.Join([]string{
+	base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"test"}`)),
+	base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"system:serviceaccount:ci:builder"}`)),
+	base64.RawURLEncoding.EncodeToString([]byte("signature")),
+}, ".")
+
+func newTestAuth(t *testing.T, token bool) *Auth {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword() error = %v", err)
+	}
+	file := path.Join(t.TempDir(), "htpasswd")
+	err = os.WriteFile(file, []byte("alice:"+string(hash)+"\n"), 0644)
+	if err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		if !isJWT(review.Spec.Token) || strings.HasPrefix(review.Spec.Token, tokenHeader+".") {
+			t.Errorf("reviewed %q, want only the ServiceAccount tokens", review.Spec.Token)
+		}
+		if review.Spec.Token == testSAToken {
+			review.Status.Authenticated = true
+			review.Status.User.Username = "system:serviceaccount:ci:builder"
+			review.Status.User.Groups = []string{GroupAuthenticated}
+		}
+		return true, review, nil
+	})
+
+	conf := &Config{
+		Htpasswd:   file,
+		Kubernetes: &KubernetesConfig{},
+		Policies: []Policy{
+			{
+				Users:        []string{"alice"},
+				Repositories: []string{"k8s/{image}"},
+			},
+			{
+				Users:        []string{"system:serviceaccount:ci:{name}"},
+				Repositories: []string{"ollama/{model}"},
+			},
+			{
+				Groups:       []string{"system:unauthenticated"},
+				Repositories: []string{"public/{image}"},
+			},
+		},
+	}
+	if token {
+		conf.Token = &TokenConfig{}
+	}
+	a, err := NewAuth(conf, WithTokenReviewer(NewTokenReviewer(clientset, nil)))
+	if err != nil {
+		t.Fatalf("NewAuth() error = %v", err)
+	}
+	return a
+}
+
+func TestAuthorizeBasic(t *testing.T) {
+	a := newTestAuth(t, false)
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		bearer   string
+		repo     string
+		want     error
+	}{
+		{
+			name: "anonymous ping",
+		},
+		{
+			name: "anonymous public",
+			repo: "public/alpine",
+		},
+		{
+			name: "anonymous private",
+			repo: "k8s/alpine",
+			want: ErrUnauthorized,
+		},
+		{
+			name:     "user ping",
+			username: "alice",
+			password: "secret",
+		},
+		{
+			name:     "user allowed",
+			username: "alice",
+			password: "secret",
+			repo:     "k8s/alpine/kubectl",
+		},
+		{
+			name:     "user denied",
+			username: "alice",
+			password: "secret",
+			repo:     "ollama/llama2",
+			want:     ErrDenied,
+		},
+		{
+			name:     "wrong password",
+			username: "alice",
+			password: "wrong",
+			repo:     "k8s/alpine",
+			want:     ErrUnauthorized,
+		},
+		{
+			name:     "token password of user",
+			username: "alice",
+			password: testSAToken,
+			repo:     "ollama/llama2",
+			want:     ErrUnauthorized,
+		},
+		{
+			name:     "password of unknown user",
+			username: "bob",
+			password: "secret",
+			repo:     "ollama/llama2",
+			want:     ErrUnauthorized,
+		},
+		{
+			name:     "service account password",
+			username: "builder",
+			password: testSAToken,
+			repo:     "ollama/llama2",
+		},
+		{
+			name:   "service account bearer",
+			bearer: testSAToken,
+			repo:   "ollama/llama2",
+		},
+		{
+			name:   "service account denied",
+			bearer: testSAToken,
+			repo:   "k8s/alpine",
+			want:   ErrDenied,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v2/", nil)
+			if tt.username != "" {
+				r.SetBasicAuth(tt.username, tt.password)
+			}
+			if tt.bearer != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			err := a.Authorize(r, tt.repo, ActionPull)
+			if !errors.Is(err, tt.want) || (err == nil) != (tt.want == nil) {
+				t.Errorf("Authorize() error = %v, want %v", err, tt.want)
+			}
+			if tt.repo != "" {
+				if got := a.Authorizer(r)(tt.repo, ActionPull); got != (tt.want == nil) {
+					t.Errorf("Authorizer() = %v, want %v", got, tt.want == nil)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthorizeAnonymousPing(t *testing.T) {
+	tests := []struct {
+		name     string
+		policies []Policy
+		want     error
+	}{
+		{
+			name: "anonymous policy",
+			policies: []Policy{
+				{Groups: []string{"system:unauthenticated"}, Repositories: []string{"public/{image}"}},
+			},
+		},
+		{
+			name: "without anonymous policy",
+			policies: []Policy{
+				{Groups: []string{GroupAuthenticated}, Repositories: []string{"public/{image}"}},
+			},
+			want: ErrUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := NewAuth(&Config{Policies: tt.policies})
+			if err != nil {
+				t.Fatalf("NewAuth() error = %v", err)
+			}
+			err = a.Authorize(httptest.NewRequest(http.MethodGet, "/v2/", nil), "", ActionPull)
+			if !errors.Is(err, tt.want) || (err == nil) != (tt.want == nil) {
+				t.Errorf("Authorize() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizeToken(t *testing.T) {
+	a := newTestAuth(t, true)
+
+	r := httptest.NewRequest(http.MethodGet, "http://jitdi.local/v2/k8s/alpine/manifests/latest", nil)
+	err := a.Authorize(r, "k8s/alpine", ActionPull)
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("Authorize() without token error = %v", err)
+	}
+	w := httptest.NewRecorder()
+	a.Challenge(w, r, "k8s/alpine", ActionPull)
+	want := `Bearer realm="http://jitdi.local/jitdi/token",service="jitdi",scope="repository:k8s/alpine:pull"`
+	if got := w.Header().Get("WWW-Authenticate"); got != want {
+		t.Fatalf("Challenge() got = %q, want %q", got, want)
+	}
+
+	tr := httptest.NewRequest(http.MethodGet, "/jitdi/token?service=jitdi&scope=repository:k8s/alpine:pull&scope=repository:ollama/llama2:pull", nil)
+	tr.SetBasicAuth("alice", "secret")
+	w = httptest.NewRecorder()
+	a.ServeToken(w, tr)
+	if w.Code != http.StatusOK {
+		t.Fatalf("ServeToken() code = %d, body = %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Token string `json:"token"`
+	}
+	err = json.NewDecoder(w.Body).Decode(&resp)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	r.Header.Set("Authorization", "Bearer "+resp.Token)
+	id, err := a.AuthorizeIdentity(r, "k8s/alpine", ActionPull)
+	if err != nil {
+		t.Fatalf("AuthorizeIdentity() with token error = %v", err)
+	}
+	if id.Name != "alice" {
+		t.Fatalf("AuthorizeIdentity() got identity %q, want %q", id.Name, "alice")
+	}
+	err = a.Authorize(r, "ollama/llama2", ActionPull)
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("Authorize() without scope error = %v", err)
+	}
+
+	_, err = a.token.verify(resp.Token, time.Now().Add(time.Hour))
+	if err == nil {
+		t.Fatalf("verify() expired token error = nil")
+	}
+	_, err = a.token.verify(resp.Token[:len(resp.Token)-2], time.Now())
+	if err == nil {
+		t.Fatalf("verify() tampered token error = nil")
+	}
+	r.Header.Set("Authorization", "Bearer "+resp.Token[:len(resp.Token)-2])
+	err = a.Authorize(r, "k8s/alpine", ActionPull)
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("Authorize() with tampered token error = %v", err)
+	}
+}
+
+func TestTokenReviewer(t *testing.T) {
+	tests := []struct {
+		name          string
+		audiences     []string
+		authenticated bool
+		// reviewed is the audiences of the token returned by the API server.
+		reviewed []string
+		wantErr  bool
+	}{
+		{
+			name:          "without audiences",
+			authenticated: true,
+		},
+		{
+			name:          "matched audience",
+			audiences:     []string{"jitdi"},
+			authenticated: true,
+			reviewed:      []string{"https://kubernetes.default.svc", "jitdi"},
+		},
+		{
+			name:          "audiences ignored by the API server",
+			audiences:     []string{"jitdi"},
+			authenticated: true,
+			reviewed:      []string{"https://kubernetes.default.svc"},
+			wantErr:       true,
+		},
+		{
+			name:    "not authenticated",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reviews := 0
+			clientset := fake.NewSimpleClientset()
+			clientset.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				reviews++
+				review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+				review.Status.Authenticated = tt.authenticated
+				review.Status.User.Username = "system:serviceaccount:ci:builder"
+				review.Status.Audiences = tt.reviewed
+				return true, review, nil
+			})
+
+			reviewer := NewTokenReviewer(clientset, tt.audiences)
+			for i := 0; i != 2; i++ {
+				_, err := reviewer.Review(context.Background(), testSAToken)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("Review() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			}
+			// The results are cached, including the rejected ones.
+			if reviews != 1 {
+				t.Errorf("Review() created %d TokenReviews, want 1", reviews)
+			}
+		})
+	}
+}
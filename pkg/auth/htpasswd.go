@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// htpasswd is the bcrypt hashed passwords of the users.
+type htpasswd map[string][]byte
+
+func loadHtpasswd(file string) (htpasswd, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := htpasswd{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid htpasswd line %q", line)
+		}
+		if !strings.HasPrefix(hash, "$2") {
+			return nil, fmt.Errorf("unsupported password hash of user %q, only bcrypt is supported", user)
+		}
+		h[user] = []byte(hash)
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h htpasswd) verify(user, password string) bool {
+	hash, ok := h[user]
+	if !ok {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
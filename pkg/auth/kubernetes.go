@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// TokenReviewer authenticates the Kubernetes ServiceAccount tokens.
+type TokenReviewer interface {
+	Review(ctx context.Context, token string) (*Identity, error)
+}
+
+const (
+	// reviewCacheTTL is how long the authenticated results of TokenReview are cached.
+	reviewCacheTTL = time.Minute
+	// reviewFailureCacheTTL is how long the rejected tokens are cached, so they are not reviewed on every request.
+	reviewFailureCacheTTL = 10 * time.Second
+)
+
+type tokenReviewer struct {
+	clientset kubernetes.Interface
+	audiences []string
+
+	mut   sync.Mutex
+	cache map[[sha256.Size]byte]reviewResult
+}
+
+type reviewResult struct {
+	identity *Identity
+	err      error
+	expires  time.Time
+}
+
+// NewTokenReviewer returns the TokenReviewer with the TokenReview API of Kubernetes.
+func NewTokenReviewer(clientset kubernetes.Interface, audiences []string) TokenReviewer {
+	return &tokenReviewer{
+		clientset: clientset,
+		audiences: audiences,
+		cache:     map[[sha256.Size]byte]reviewResult{},
+	}
+}
+
+func (t *tokenReviewer) Review(ctx context.Context, token string) (*Identity, error) {
+	key := sha256.Sum256([]byte(token))
+	now := time.Now()
+
+	t.mut.Lock()
+	result, ok := t.cache[key]
+	t.mut.Unlock()
+	if ok && now.Before(result.expires) {
+		return result.identity, result.err
+	}
+
+	review, err := t.clientset.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{
+			Token:     token,
+			Audiences: t.audiences,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	result = reviewResult{
+		expires: now.Add(reviewCacheTTL),
+	}
+	result.identity, result.err = t.identity(review)
+	if result.err != nil {
+		result.expires = now.Add(reviewFailureCacheTTL)
+	}
+
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	for k, v := range t.cache {
+		if now.After(v.expires) {
+			delete(t.cache, k)
+		}
+	}
+	t.cache[key] = result
+	return result.identity, result.err
+}
+
+// identity returns the identity of the review, or why the token is rejected.
+func (t *tokenReviewer) identity(review *authenticationv1.TokenReview) (*Identity, error) {
+	if !review.Status.Authenticated {
+		if review.Status.Error != "" {
+			return nil, errors.New(review.Status.Error)
+		}
+		return nil, errors.New("token is not authenticated")
+	}
+	// The API server may ignore the audiences of the spec, so they are checked again.
+	if len(t.audiences) != 0 && !slices.ContainsFunc(review.Status.Audiences, func(audience string) bool {
+		return slices.Contains(t.audiences, audience)
+	}) {
+		return nil, fmt.Errorf("token audiences %v do not match %v", review.Status.Audiences, t.audiences)
+	}
+	return &Identity{
+		Name:   review.Status.User.Username,
+		Groups: review.Status.User.Groups,
+	}, nil
+}
+
+// isJWT reports whether the token has the shape of a JWT, like the ServiceAccount tokens.
+func isJWT(token string) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	for _, part := range parts {
+		if part == "" {
+			return false
+		}
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return false
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	err = json.Unmarshal(data, &header)
+	return err == nil && header.Alg != ""
+}
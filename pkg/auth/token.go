@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+var errInvalidToken = errors.New("invalid token")
+
+// tokenIssuer issues and verifies the registry tokens, they are JWTs signed with HS256.
+type tokenIssuer struct {
+	key        []byte
+	realmURL   string
+	service    string
+	issuer     string
+	expiration time.Duration
+}
+
+func newTokenIssuer(conf *TokenConfig) (*tokenIssuer, error) {
+	t := &tokenIssuer{
+		realmURL:   conf.Realm,
+		service:    conf.Service,
+		issuer:     conf.Issuer,
+		expiration: conf.Expiration.Duration,
+	}
+	if t.service == "" {
+		t.service = "jitdi"
+	}
+	if t.issuer == "" {
+		t.issuer = "jitdi"
+	}
+	if t.expiration <= 0 {
+		t.expiration = 5 * time.Minute
+	}
+
+	if conf.KeyFile != "" {
+		key, err := os.ReadFile(conf.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		t.key = key
+	} else {
+		t.key = make([]byte, 32)
+		_, err := rand.Read(t.key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (t *tokenIssuer) realm(r *http.Request) string {
+	if t.realmURL != "" {
+		return t.realmURL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + "/jitdi/token"
+}
+
+// access is the granted actions of a resource.
+type access struct {
+	Type    string   `json:"type"`
+	Name    string   `json:"name"`
+	Actions []string `json:"actions"`
+}
+
+type claims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  string   `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	IssuedAt  int64    `json:"iat"`
+	Access    []access `json:"access"`
+}
+
+func (c *claims) allows(repo, action string) bool {
+	return slices.ContainsFunc(c.Access, func(a access) bool {
+		return a.Type == "repository" && a.Name == repo && slices.Contains(a.Actions, action)
+	})
+}
+
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+func (t *tokenIssuer) sign(c *claims) (string, error) {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	unsigned := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, t.key)
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// issued reports whether the token looks issued by us, by the header or the issuer, without verifying it.
+func (t *tokenIssuer) issued(token string) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	if parts[0] == tokenHeader {
+		return true
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	c := &claims{}
+	err = json.Unmarshal(payload, c)
+	return err == nil && c.Issuer == t.issuer
+}
+
+func (t *tokenIssuer) verify(token string, now time.Time) (*claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != tokenHeader {
+		return nil, errInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	mac := hmac.New(sha256.New, t.key)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, errInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	c := &claims{}
+	err = json.Unmarshal(payload, c)
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	if c.Issuer != t.issuer || c.Audience != t.service {
+		return nil, errInvalidToken
+	}
+	if now.Unix() >= c.ExpiresAt {
+		return nil, fmt.Errorf("%w: expired", errInvalidToken)
+	}
+	return c, nil
+}
+
+// ServeToken is the token endpoint of the registry token authentication,
+// it grants the requested scopes allowed by the policies.
+//
+//	GET /jitdi/token?service=jitdi&scope=repository:k8s/alpine/kubectl:pull
+func (a *Auth) ServeToken(w http.ResponseWriter, r *http.Request) {
+	if a.token == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	id, err := a.authenticate(r)
+	if err != nil {
+		a.Challenge(w, r, "", "")
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	if service := query.Get("service"); service != "" && service != a.token.service {
+		http.Error(w, fmt.Sprintf("unknown service %q", service), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	c := &claims{
+		Issuer:    a.token.issuer,
+		Subject:   id.Name,
+		Audience:  a.token.service,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(a.token.expiration).Unix(),
+		Access:    []access{},
+	}
+	for _, scope := range query["scope"] {
+		// repository:<name>:<actions>, the name may contain a port of the host.
+		typ, rest, ok := strings.Cut(scope, ":")
+		i := strings.LastIndex(rest, ":")
+		if !ok || typ != "repository" || i < 0 {
+			continue
+		}
+		name, actions := rest[:i], strings.Split(rest[i+1:], ",")
+
+		granted := []string{}
+		for _, action := range actions {
+			if a.allowed(id, name, action) {
+				granted = append(granted, action)
+			}
+		}
+		if len(granted) != 0 {
+			c.Access = append(c.Access, access{
+				Type:    typ,
+				Name:    name,
+				Actions: granted,
+			})
+		}
+	}
+
+	token, err := a.token.sign(c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"token":        token,
+		"access_token": token,
+		"expires_in":   int64(a.token.expiration / time.Second),
+		"issued_at":    now.UTC().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,73 @@
+package builder
+
+import (
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// annotatedImage is an image with the annotations computed when the manifest is needed,
+// so the annotations can depend on the layers which are computed only after they are pushed.
+type annotatedImage struct {
+	v1.Image
+	annotations func() (map[string]string, error)
+
+	mut       sync.Mutex
+	annotated v1.Image
+}
+
+// AnnotateImage returns the image with the annotations of the manifest,
+// annotations is called once it succeeds when the manifest is needed.
+func AnnotateImage(image v1.Image, annotations func() (map[string]string, error)) v1.Image {
+	return &annotatedImage{
+		Image:       image,
+		annotations: annotations,
+	}
+}
+
+func (a *annotatedImage) image() (v1.Image, error) {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	if a.annotated != nil {
+		return a.annotated, nil
+	}
+	annotations, err := a.annotations()
+	if err != nil {
+		return nil, err
+	}
+	a.annotated = mutate.Annotations(a.Image, annotations).(v1.Image)
+	return a.annotated, nil
+}
+
+func (a *annotatedImage) Manifest() (*v1.Manifest, error) {
+	image, err := a.image()
+	if err != nil {
+		return nil, err
+	}
+	return image.Manifest()
+}
+
+func (a *annotatedImage) RawManifest() ([]byte, error) {
+	image, err := a.image()
+	if err != nil {
+		return nil, err
+	}
+	return image.RawManifest()
+}
+
+func (a *annotatedImage) Digest() (v1.Hash, error) {
+	image, err := a.image()
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	return image.Digest()
+}
+
+func (a *annotatedImage) Size() (int64, error) {
+	image, err := a.image()
+	if err != nil {
+		return 0, err
+	}
+	return image.Size()
+}
@@ -0,0 +1,55 @@
+package builder
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestAnnotateImage(t *testing.T) {
+	image, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	errNotReady := errors.New("not ready")
+	annotated := AnnotateImage(image, func() (map[string]string, error) {
+		calls++
+		if calls == 1 {
+			return nil, errNotReady
+		}
+		return map[string]string{"key": "value"}, nil
+	})
+
+	_, err = annotated.Manifest()
+	if !errors.Is(err, errNotReady) {
+		t.Fatalf("Manifest() error = %v, want %v", err, errNotReady)
+	}
+
+	manifest, err := annotated.Manifest()
+	if err != nil {
+		t.Fatalf("Manifest() error = %v", err)
+	}
+	if manifest.Annotations["key"] != "value" {
+		t.Errorf("Annotations = %v, want key=value", manifest.Annotations)
+	}
+
+	got, err := annotated.Digest()
+	if err != nil {
+		t.Fatalf("Digest() error = %v", err)
+	}
+	want, err := mutate.Annotations(image, map[string]string{"key": "value"}).(v1.Image).Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("Digest() = %s, want %s", got, want)
+	}
+	if calls != 2 {
+		t.Errorf("annotations called %d times, want 2", calls)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/google/go-containerregistry/pkg/v1/stream"
 
 	"github.com/wzshiming/jitdi/pkg/atomic"
+	"github.com/wzshiming/jitdi/pkg/metrics"
 )
 
 type cacheFileLayer struct {
@@ -18,6 +19,8 @@ type cacheFileLayer struct {
 
 	diffID v1.Hash
 	size   int64
+
+	observed bool
 }
 
 func NewCacheFileLayer(linkPath string, layer v1.Layer) v1.Layer {
@@ -53,7 +56,8 @@ func (c *cacheFileLayer) Digest() (v1.Hash, error) {
 	}
 
 	if errors.Is(err, stream.ErrNotComputed) {
-		h, diffID, size, err := decodeLinkInfo(c.linkPath)
+		h, diffID, size, err := DecodeLinkInfo(c.linkPath)
+		c.observe(err == nil)
 		if err == nil {
 			c.size = size
 			c.diffID = diffID
@@ -68,6 +72,15 @@ func (c *cacheFileLayer) Digest() (v1.Hash, error) {
 	return v1.Hash{}, err
 }
 
+// observe records the lookup of the link once per layer.
+func (c *cacheFileLayer) observe(hit bool) {
+	if c.observed {
+		return
+	}
+	c.observed = true
+	metrics.ObserveCache(metrics.CacheLink, hit)
+}
+
 func (c *cacheFileLayer) Size() (int64, error) {
 	if c.size > 0 {
 		return c.size, nil
@@ -87,7 +100,8 @@ func encodeLinkInfo(linkPath string, digest v1.Hash, diffID v1.Hash, size int64)
 	return err
 }
 
-func decodeLinkInfo(linkPath string) (digest, diffID v1.Hash, size int64, err error) {
+// DecodeLinkInfo reads the digest, diffID and size of the layer from the link file.
+func DecodeLinkInfo(linkPath string) (digest, diffID v1.Hash, size int64, err error) {
 	c, err := os.ReadFile(linkPath)
 	if err != nil {
 		return v1.Hash{}, v1.Hash{}, 0, err
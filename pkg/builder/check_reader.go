@@ -0,0 +1,47 @@
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+)
+
+type checkReader struct {
+	io.ReadCloser
+	hash  hash.Hash
+	check func(v1.Hash) error
+	err   error
+}
+
+// NewCheckReader returns the reader calling the check with the sha256 of the content at the end of the reading,
+// the error of the check is returned instead of io.EOF.
+func NewCheckReader(rc io.ReadCloser, check func(v1.Hash) error) io.ReadCloser {
+	return &checkReader{
+		ReadCloser: rc,
+		hash:       sha256.New(),
+		check:      check,
+	}
+}
+
+func (c *checkReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.ReadCloser.Read(p)
+	c.hash.Write(p[:n])
+	if errors.Is(err, io.EOF) {
+		err = c.check(v1.Hash{
+			Algorithm: "sha256",
+			Hex:       hex.EncodeToString(c.hash.Sum(nil)),
+		})
+		if err == nil {
+			err = io.EOF
+		}
+		c.err = err
+	}
+	return n, err
+}
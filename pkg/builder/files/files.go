@@ -1,6 +1,7 @@
 package files
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -21,12 +22,22 @@ type Files struct {
 	transport http.RoundTripper
 }
 
-func NewFiles(mode int64, modTime time.Time, transport http.RoundTripper) *Files {
+// NewFiles returns the files builder, the redirects of the remote files are followed only if checkRedirect accepts them.
+func NewFiles(mode int64, modTime time.Time, transport http.RoundTripper, checkRedirect func(u *url.URL) error) *Files {
 	return &Files{
 		mode:    mode,
 		modTime: modTime,
 		client: &http.Client{
 			Transport: transport,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= 10 {
+					return errors.New("stopped after 10 redirects")
+				}
+				if checkRedirect == nil {
+					return nil
+				}
+				return checkRedirect(req.URL)
+			},
 		},
 	}
 }
@@ -59,7 +70,7 @@ func (f *Files) tarRemote(u *url.URL, newPath string) (*builder.File, error) {
 
 func (f *Files) tarRemoteFileToFile(u *url.URL, newPath string) (*builder.File, error) {
 	uri := u.String()
-	resp, err := http.Head(uri)
+	resp, err := f.client.Head(uri)
 	if err != nil {
 		return nil, fmt.Errorf("http.Head(%q): %w", uri, err)
 	}
@@ -115,31 +126,26 @@ func (f *Files) tarLocal(hostPath, newPath string) ([]*builder.File, error) {
 	return []*builder.File{f.tarFileToFile(hostPath, newPath)}, nil
 }
 
+// tarDirToDir returns the files of the directory in the order of the names, so the layers are reproducible.
 func (f *Files) tarDirToDir(hostPath, newPath string) ([]*builder.File, error) {
-	fs := []*builder.File{}
-	err := filepath.Walk(hostPath, func(p string, info os.FileInfo, err error) error {
-		if hostPath == p {
-			return nil
-		}
-		if err != nil {
-			return fmt.Errorf("filepath.Walk(%q): %w", hostPath, err)
-		}
+	entries, err := os.ReadDir(hostPath)
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadDir(%q): %w", hostPath, err)
+	}
 
-		if info.IsDir() {
-			files, err := f.tarDirToDir(p, path.Join(newPath, path.Base(p)))
+	fs := []*builder.File{}
+	for _, entry := range entries {
+		p := filepath.Join(hostPath, entry.Name())
+		if entry.IsDir() {
+			files, err := f.tarDirToDir(p, path.Join(newPath, entry.Name()))
 			if err != nil {
-				return err
+				return nil, err
 			}
 			fs = append(fs, files...)
 		} else {
-			file := f.tarFileToFile(p, path.Join(newPath, path.Base(p)))
+			file := f.tarFileToFile(p, path.Join(newPath, entry.Name()))
 			fs = append(fs, file)
 		}
-
-		return nil
-	})
-	if err != nil {
-		return nil, err
 	}
 
 	return fs, nil
@@ -0,0 +1,89 @@
+package files
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBuildDir(t *testing.T) {
+	dir := t.TempDir()
+	for _, p := range []string{"z", "a/y", "a/b/x", "c/w"} {
+		p = filepath.Join(dir, p)
+		err := os.MkdirAll(filepath.Dir(p), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(p, []byte(p), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fs, err := NewFiles(0644, time.Time{}, nil, nil).Build(dir, "/src")
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	got := []string{}
+	for _, f := range fs {
+		got = append(got, f.Path)
+	}
+	want := []string{"/src/a/b/x", "/src/a/y", "/src/c/w", "/src/z"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Build() = %v, want %v", got, want)
+	}
+}
+
+func TestBuildRemoteRedirect(t *testing.T) {
+	errNotAllowed := errors.New("not allowed")
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "4")
+		_, _ = w.Write([]byte("file"))
+	}))
+	defer target.Close()
+
+	source := httptest.NewServer(http.RedirectHandler(target.URL+"/file", http.StatusFound))
+	defer source.Close()
+
+	tests := []struct {
+		name          string
+		checkRedirect func(u *url.URL) error
+		wantErr       error
+	}{
+		{
+			name: "redirect allowed",
+		},
+		{
+			name: "redirect not allowed",
+			checkRedirect: func(u *url.URL) error {
+				if u.Host == target.Listener.Addr().String() {
+					return errNotAllowed
+				}
+				return nil
+			},
+			wantErr: errNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, err := NewFiles(0644, time.Time{}, nil, tt.checkRedirect).Build(source.URL+"/file", "/file")
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Fatalf("Build() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(fs) != 1 || fs[0].Path != "/file" {
+				t.Errorf("Build() = %v, want /file", fs)
+			}
+		})
+	}
+}
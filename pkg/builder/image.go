@@ -87,6 +87,31 @@ func (i *Image) AppendFileAsNewLayerWithLink(file *File, link string) error {
 	return nil
 }
 
+// AppendFileAsNewLayerWithCheck appends the file as a new layer,
+// the check is called with the diffID when the layer is read to the end, and its error fails the reading.
+func (i *Image) AppendFileAsNewLayerWithCheck(file *File, check func(diffID v1.Hash) error) error {
+	rc := NewCheckReader(Tar(file), check)
+
+	layer := stream.NewLayer(rc,
+		stream.WithMediaType(i.layerMediaType()),
+		stream.WithCompressionLevel(0),
+	)
+
+	img, err := mutate.Append(i.image, mutate.Addendum{
+		Layer: layer,
+		History: v1.History{
+			Author:    "jitdi",
+			CreatedBy: fmt.Sprintf("Add %s", file.Path),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	i.image = img
+
+	return nil
+}
+
 func (i *Image) Image() v1.Image {
 	return i.image
 }
@@ -157,3 +157,13 @@ func (o *Ollama) tarLayer(layer v1.Layer, workDir string) (*builder.File, error)
 		},
 	}, nil
 }
+
+// ManifestPath returns the path of the model manifest in the Ollama models directory.
+func ManifestPath(modelsDir string, ref name.Reference) string {
+	return path.Join(modelsDir, "manifests", ref.Context().RegistryStr(), ref.Context().RepositoryStr(), ref.Identifier())
+}
+
+// BlobPath returns the path of the blob in the Ollama models directory.
+func BlobPath(modelsDir string, digest v1.Hash) string {
+	return path.Join(modelsDir, "blobs", digest.Algorithm+"-"+digest.Hex)
+}
@@ -0,0 +1,144 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+)
+
+func newTestClient(t *testing.T, dir string, cert *tls.Certificate) *http.Client {
+	ca, err := os.ReadFile(path.Join(dir, CAFile))
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca)
+	conf := &tls.Config{
+		RootCAs: pool,
+	}
+	if cert != nil {
+		conf.Certificates = []tls.Certificate{*cert}
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: conf,
+		},
+	}
+}
+
+func newTestServer(t *testing.T, r *Reloader) string {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", r.TLSConfig())
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	s := &http.Server{
+		Handler:  http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		ErrorLog: log.New(io.Discard, "", 0),
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return "https://" + l.Addr().String()
+}
+
+func TestBootstrapAndReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, err := Bootstrap(dir, []string{"127.0.0.1"})
+	if err != nil {
+		t.Fatalf("Bootstrap() error = %v", err)
+	}
+	r, err := NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewReloader() error = %v", err)
+	}
+	u := newTestServer(t, r)
+
+	resp, err := newTestClient(t, dir, nil).Get(u)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	resp.Body.Close()
+
+	old := r.cert
+
+	// The existing certificate covers the hosts, so it is kept.
+	_, _, err = Bootstrap(dir, []string{"127.0.0.1"})
+	if err != nil {
+		t.Fatalf("Bootstrap() error = %v", err)
+	}
+	changed, err := r.reload()
+	if err != nil || changed {
+		t.Fatalf("reload() changed = %v, error = %v", changed, err)
+	}
+
+	// A new host reissues the certificate with the same CA.
+	_, _, err = Bootstrap(dir, []string{"127.0.0.1", "jitdi.local"})
+	if err != nil {
+		t.Fatalf("Bootstrap() error = %v", err)
+	}
+	changed, err = r.reload()
+	if err != nil || !changed {
+		t.Fatalf("reload() changed = %v, error = %v", changed, err)
+	}
+	if r.cert == old {
+		t.Fatalf("reload() kept the old certificate")
+	}
+
+	resp, err = newTestClient(t, dir, nil).Get(u)
+	if err != nil {
+		t.Fatalf("Get() after reload error = %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestClientCA(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, err := Bootstrap(dir, []string{"127.0.0.1"})
+	if err != nil {
+		t.Fatalf("Bootstrap() error = %v", err)
+	}
+	r, err := NewReloader(certFile, keyFile, WithClientCA(path.Join(dir, CAFile)))
+	if err != nil {
+		t.Fatalf("NewReloader() error = %v", err)
+	}
+	u := newTestServer(t, r)
+
+	_, err = newTestClient(t, dir, nil).Get(u)
+	if err == nil {
+		t.Fatalf("Get() without client certificate error = nil")
+	}
+
+	ca, caKey, err := loadPair(path.Join(dir, CAFile), path.Join(dir, caKeyFile))
+	if err != nil {
+		t.Fatalf("loadPair() error = %v", err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	tmpl, err := template("node", certValidity)
+	if err != nil {
+		t.Fatalf("template() error = %v", err)
+	}
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, key.Public(), caKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate() error = %v", err)
+	}
+
+	resp, err := newTestClient(t, dir, &tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}).Get(u)
+	if err != nil {
+		t.Fatalf("Get() with client certificate error = %v", err)
+	}
+	resp.Body.Close()
+}
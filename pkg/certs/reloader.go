@@ -0,0 +1,171 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloader serves the certificate of the files, and reloads them when they change.
+type Reloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+	interval     time.Duration
+	logger       *slog.Logger
+
+	mut       sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	contents  [][]byte
+}
+
+type option func(*Reloader)
+
+// WithClientCA verifies the client certificates with the CA bundle file.
+func WithClientCA(file string) option {
+	return func(r *Reloader) {
+		r.clientCAFile = file
+	}
+}
+
+// WithInterval sets the interval of checking the files for changes.
+func WithInterval(interval time.Duration) option {
+	return func(r *Reloader) {
+		r.interval = interval
+	}
+}
+
+// WithLogger sets the logger.
+func WithLogger(logger *slog.Logger) option {
+	return func(r *Reloader) {
+		r.logger = logger
+	}
+}
+
+// NewReloader returns a Reloader of the certificate and key files.
+func NewReloader(certFile, keyFile string, opts ...option) (*Reloader, error) {
+	r := &Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: 10 * time.Second,
+		logger:   slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	_, err := r.reload()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Start checks the files for changes until the context is done.
+func (r *Reloader) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				changed, err := r.reload()
+				if err != nil {
+					// Keep serving the previous certificate, the files may be in the middle of an update.
+					r.logger.Warn("failed to reload certificate", "cert", r.certFile, "err", err)
+				} else if changed {
+					r.logger.Info("Reloaded certificate", "cert", r.certFile)
+				}
+			}
+		}
+	}()
+}
+
+// reload loads the files if any of them changed.
+func (r *Reloader) reload() (bool, error) {
+	files := []string{r.certFile, r.keyFile}
+	if r.clientCAFile != "" {
+		files = append(files, r.clientCAFile)
+	}
+
+	contents := make([][]byte, 0, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return false, err
+		}
+		contents = append(contents, data)
+	}
+
+	r.mut.RLock()
+	old := r.contents
+	r.mut.RUnlock()
+	if equalContents(old, contents) {
+		return false, nil
+	}
+
+	cert, err := tls.X509KeyPair(contents[0], contents[1])
+	if err != nil {
+		return false, err
+	}
+
+	var clientCAs *x509.CertPool
+	if r.clientCAFile != "" {
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(contents[2]) {
+			return false, fmt.Errorf("no certificates in client CA file %q", r.clientCAFile)
+		}
+	}
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.contents = contents
+	return true, nil
+}
+
+func equalContents(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// TLSConfig returns the server config with the current certificate and client CAs of each handshake.
+func (r *Reloader) TLSConfig() *tls.Config {
+	conf := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			r.mut.RLock()
+			defer r.mut.RUnlock()
+			return r.cert, nil
+		},
+	}
+	if r.clientCAFile != "" {
+		conf.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mut.RLock()
+			defer r.mut.RUnlock()
+			c := conf.Clone()
+			c.GetConfigForClient = nil
+			c.ClientAuth = tls.RequireAndVerifyClientCert
+			c.ClientCAs = r.clientCAs
+			return c, nil
+		}
+	}
+	return conf
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+
+	"github.com/wzshiming/jitdi/pkg/builder"
+	"github.com/wzshiming/jitdi/pkg/pattern"
+)
+
+// The annotations of the built manifests.
+const (
+	AnnotationBaseDigest = "org.opencontainers.image.base.digest"
+	AnnotationBaseName   = "org.opencontainers.image.base.name"
+	AnnotationSource     = "org.opencontainers.image.source"
+
+	// AnnotationRule is the match of the rule.
+	AnnotationRule = "io.zsm.jitdi.rule"
+	// AnnotationParams is the JSON of the parameters matched by the rule.
+	AnnotationParams = "io.zsm.jitdi.params"
+	// AnnotationInputs is the JSON of the inputs, the files added with their sources and checksums.
+	AnnotationInputs = "io.zsm.jitdi.inputs"
+)
+
+// buildAnnotations returns the annotations of a manifest built from the base with the inputs.
+func buildAnnotations(action *pattern.Action, baseDigest v1.Hash, inputs []Input) (map[string]string, error) {
+	annotations := map[string]string{
+		AnnotationBaseName:   action.GetBaseImage(),
+		AnnotationBaseDigest: baseDigest.String(),
+		AnnotationRule:       action.GetRuleMatch(),
+	}
+
+	params, err := json.Marshal(action.GetParams())
+	if err != nil {
+		return nil, err
+	}
+	annotations[AnnotationParams] = string(params)
+
+	if len(inputs) != 0 {
+		data, err := json.Marshal(inputs)
+		if err != nil {
+			return nil, err
+		}
+		annotations[AnnotationInputs] = string(data)
+
+		sources := []string{}
+		for _, input := range inputs {
+			if !slices.Contains(sources, input.Source) {
+				sources = append(sources, input.Source)
+			}
+		}
+		annotations[AnnotationSource] = strings.Join(sources, ",")
+	}
+	return annotations, nil
+}
+
+// annotateImage returns the image with the annotations of the base and the inputs,
+// the inputs are locked when the manifest is needed after the layers are pushed.
+func annotateImage(image v1.Image, action *pattern.Action, baseDigest v1.Hash, inputs []Input, platform *v1.Platform) v1.Image {
+	return builder.AnnotateImage(image, func() (map[string]string, error) {
+		locked, err := lockInputs(image, inputs, platform)
+		if err != nil {
+			return nil, err
+		}
+		return buildAnnotations(action, baseDigest, locked)
+	})
+}
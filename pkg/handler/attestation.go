@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+
+	"github.com/wzshiming/jitdi/pkg/pattern"
+	"github.com/wzshiming/jitdi/pkg/storage"
+)
+
+const (
+	// MediaTypeInToto is the media type and the artifact type of the in-toto attestations.
+	MediaTypeInToto types.MediaType = "application/vnd.in-toto+json"
+	// MediaTypeEmpty is the media type of the empty config of the artifacts.
+	MediaTypeEmpty types.MediaType = "application/vnd.oci.empty.v1+json"
+
+	// PredicateSLSAProvenance is the predicate type of the SLSA provenance.
+	PredicateSLSAProvenance = "https://slsa.dev/provenance/v1"
+
+	// AnnotationPredicateType is the annotation of the predicate type of the attestation layer.
+	AnnotationPredicateType = "in-toto.io/predicate-type"
+
+	statementType = "https://in-toto.io/Statement/v1"
+	buildType     = "https://github.com/wzshiming/jitdi/build/v1"
+	builderID     = "https://github.com/wzshiming/jitdi"
+)
+
+// emptyConfig is the content of the empty config.
+var emptyConfig = []byte("{}")
+
+// artifactManifest is an image manifest of an artifact.
+type artifactManifest struct {
+	v1.Manifest
+	ArtifactType types.MediaType `json:"artifactType,omitempty"`
+}
+
+// statement is an in-toto statement.
+type statement struct {
+	Type          string               `json:"_type"`
+	Subject       []resourceDescriptor `json:"subject"`
+	PredicateType string               `json:"predicateType"`
+	Predicate     slsaProvenance       `json:"predicate"`
+}
+
+type resourceDescriptor struct {
+	URI    string            `json:"uri,omitempty"`
+	Name   string            `json:"name,omitempty"`
+	Digest map[string]string `json:"digest"`
+}
+
+type slsaProvenance struct {
+	BuildDefinition slsaBuildDefinition `json:"buildDefinition"`
+	RunDetails      slsaRunDetails      `json:"runDetails"`
+}
+
+type slsaBuildDefinition struct {
+	BuildType            string               `json:"buildType"`
+	ExternalParameters   map[string]any       `json:"externalParameters"`
+	ResolvedDependencies []resourceDescriptor `json:"resolvedDependencies,omitempty"`
+}
+
+type slsaRunDetails struct {
+	Builder struct {
+		ID string `json:"id"`
+	} `json:"builder"`
+}
+
+func digestSet(h v1.Hash) map[string]string {
+	return map[string]string{h.Algorithm: h.Hex}
+}
+
+// newSLSAProvenance returns the in-toto statement of the SLSA provenance of the build.
+func newSLSAProvenance(action *pattern.Action, p *Provenance) (*statement, error) {
+	base, err := name.NewDigest(p.Base)
+	if err != nil {
+		return nil, err
+	}
+	baseDigest, err := v1.NewHash(base.DigestStr())
+	if err != nil {
+		return nil, err
+	}
+
+	dependencies := []resourceDescriptor{
+		{
+			URI:    "oci://" + action.GetBaseImage(),
+			Digest: digestSet(baseDigest),
+		},
+	}
+	for _, input := range p.Inputs {
+		resourceName := input.Path
+		if input.Platform != "" {
+			resourceName = input.Platform + ":" + input.Path
+		}
+		dependencies = append(dependencies, resourceDescriptor{
+			URI:    input.Source,
+			Name:   resourceName,
+			Digest: digestSet(input.DiffID),
+		})
+	}
+
+	s := &statement{
+		Type: statementType,
+		Subject: []resourceDescriptor{
+			{
+				Name:   p.Repository,
+				Digest: digestSet(p.Digest),
+			},
+		},
+		PredicateType: PredicateSLSAProvenance,
+		Predicate: slsaProvenance{
+			BuildDefinition: slsaBuildDefinition{
+				BuildType: buildType,
+				ExternalParameters: map[string]any{
+					"reference": p.Repository + ":" + p.Tag,
+					"rule":      p.Rule,
+					"params":    action.GetParams(),
+				},
+				ResolvedDependencies: dependencies,
+			},
+		},
+	}
+	s.Predicate.RunDetails.Builder.ID = builderID
+	return s, nil
+}
+
+// pushAttestation pushes the SLSA provenance of the build as a referrer of the subject.
+func pushAttestation(ctx context.Context, s storage.Storage, action *pattern.Action, p *Provenance, subject v1.Descriptor) error {
+	st, err := newSLSAProvenance(action, p)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return pushArtifact(ctx, s, p.Repository, MediaTypeInToto, data, map[string]string{
+		AnnotationPredicateType: PredicateSLSAProvenance,
+	}, subject)
+}
+
+// pushArtifact pushes the data as an artifact of the type referring to the subject,
+// the data is the only layer with the annotations.
+func pushArtifact(ctx context.Context, s storage.Storage, repo string, artifactType types.MediaType, data []byte, annotations map[string]string, subject v1.Descriptor) error {
+	config, err := s.PutBlob(ctx, repo, bytes.NewReader(emptyConfig))
+	if err != nil {
+		return err
+	}
+	layer, err := s.PutBlob(ctx, repo, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	m := artifactManifest{
+		Manifest: v1.Manifest{
+			SchemaVersion: 2,
+			MediaType:     types.OCIManifestSchema1,
+			Config: v1.Descriptor{
+				MediaType: MediaTypeEmpty,
+				Digest:    config.Digest,
+				Size:      config.Size,
+			},
+			Layers: []v1.Descriptor{
+				{
+					MediaType:   artifactType,
+					Digest:      layer.Digest,
+					Size:        layer.Size,
+					Annotations: annotations,
+				},
+			},
+			Subject: &subject,
+		},
+		ArtifactType: artifactType,
+	}
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	manifest := &storage.Manifest{
+		MediaType: types.OCIManifestSchema1,
+		Data:      raw,
+	}
+	err = s.PutManifest(ctx, repo, manifest.Digest().String(), manifest)
+	if err != nil {
+		return err
+	}
+	_, err = storage.AddReferrer(ctx, s, repo, manifest)
+	return err
+}
@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+
+	"github.com/wzshiming/jitdi/pkg/storage"
+)
+
+func TestBuildAnnotations(t *testing.T) {
+	src := t.TempDir()
+	writeTestFile(t, filepath.Join(src, "kubectl"), "kubectl v1.29.3")
+	h := newTestHandler(t, t.TempDir(), pushTestBase(t), src)
+	pullTestImage(t, h, "test/kubectl", "v1.29.3")
+
+	ctx := context.Background()
+	m, err := h.storage.GetManifest(ctx, "test/kubectl", "v1.29.3")
+	if err != nil {
+		t.Fatalf("GetManifest() error = %v", err)
+	}
+	image, err := storage.Image(ctx, h.storage, "test/kubectl", m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := image.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	annotations := manifest.Annotations
+
+	if annotations[AnnotationRule] != "test/{name}:{tag}" {
+		t.Errorf("%s = %q, want the match of the rule", AnnotationRule, annotations[AnnotationRule])
+	}
+	if _, err := v1.NewHash(annotations[AnnotationBaseDigest]); err != nil {
+		t.Errorf("%s = %q, want the digest of the base", AnnotationBaseDigest, annotations[AnnotationBaseDigest])
+	}
+	if source := filepath.Join(src, "kubectl"); annotations[AnnotationSource] != source {
+		t.Errorf("%s = %q, want %q", AnnotationSource, annotations[AnnotationSource], source)
+	}
+
+	var params map[string]string
+	err = json.Unmarshal([]byte(annotations[AnnotationParams]), &params)
+	if err != nil {
+		t.Fatalf("%s: %v", AnnotationParams, err)
+	}
+	if params["name"] != "kubectl" || params["tag"] != "v1.29.3" {
+		t.Errorf("%s = %v, want the params of the reference", AnnotationParams, params)
+	}
+
+	config, err := image.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffIDs := config.RootFS.DiffIDs
+	var inputs []Input
+	err = json.Unmarshal([]byte(annotations[AnnotationInputs]), &inputs)
+	if err != nil {
+		t.Fatalf("%s: %v", AnnotationInputs, err)
+	}
+	want := []Input{
+		{Source: filepath.Join(src, "kubectl"), Path: "/usr/local/bin/kubectl", DiffID: diffIDs[len(diffIDs)-1]},
+	}
+	if !reflect.DeepEqual(inputs, want) {
+		t.Errorf("%s = %+v, want %+v", AnnotationInputs, inputs, want)
+	}
+}
+
+func TestAttestation(t *testing.T) {
+	src := t.TempDir()
+	writeTestFile(t, filepath.Join(src, "kubectl"), "kubectl v1.29.3")
+	h := newTestHandler(t, t.TempDir(), pushTestBase(t), src)
+	pullTestImage(t, h, "test/kubectl", "v1.29.3")
+
+	ctx := context.Background()
+	m, err := h.storage.GetManifest(ctx, "test/kubectl", "v1.29.3")
+	if err != nil {
+		t.Fatalf("GetManifest() error = %v", err)
+	}
+	manifest, err := v1.ParseManifest(bytes.NewReader(m.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	layer, data := getTestReferrer(t, h, "test/kubectl", m.Digest(), MediaTypeInToto)
+	if layer.Annotations[AnnotationPredicateType] != PredicateSLSAProvenance {
+		t.Errorf("%s = %q, want %q", AnnotationPredicateType, layer.Annotations[AnnotationPredicateType], PredicateSLSAProvenance)
+	}
+
+	var st statement
+	err = json.Unmarshal(data, &st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Type != statementType || st.PredicateType != PredicateSLSAProvenance {
+		t.Errorf("statement = %s %s, want %s %s", st.Type, st.PredicateType, statementType, PredicateSLSAProvenance)
+	}
+	wantSubject := []resourceDescriptor{
+		{Name: "test/kubectl", Digest: digestSet(m.Digest())},
+	}
+	if !reflect.DeepEqual(st.Subject, wantSubject) {
+		t.Errorf("subject = %+v, want %+v", st.Subject, wantSubject)
+	}
+
+	baseDigest, err := v1.NewHash(manifest.Annotations[AnnotationBaseDigest])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var inputs []Input
+	err = json.Unmarshal([]byte(manifest.Annotations[AnnotationInputs]), &inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantDependencies := []resourceDescriptor{
+		{URI: "oci://" + manifest.Annotations[AnnotationBaseName], Digest: digestSet(baseDigest)},
+		{URI: filepath.Join(src, "kubectl"), Name: "/usr/local/bin/kubectl", Digest: digestSet(inputs[0].DiffID)},
+	}
+	if got := st.Predicate.BuildDefinition.ResolvedDependencies; !reflect.DeepEqual(got, wantDependencies) {
+		t.Errorf("resolvedDependencies = %+v, want %+v", got, wantDependencies)
+	}
+	if st.Predicate.BuildDefinition.ExternalParameters["reference"] != "test/kubectl:v1.29.3" {
+		t.Errorf("externalParameters = %v, want the reference", st.Predicate.BuildDefinition.ExternalParameters)
+	}
+}
+
+// getTestReferrer returns the layer and the data of the referrer of the artifact type of the manifest.
+func getTestReferrer(t *testing.T, h *Handler, repo string, digest v1.Hash, artifactType types.MediaType) (v1.Descriptor, []byte) {
+	t.Helper()
+	ctx := context.Background()
+	referrers, err := storage.Referrers(ctx, h.storage, repo, digest)
+	if err != nil {
+		t.Fatalf("Referrers() error = %v", err)
+	}
+	for _, desc := range referrers.Manifests {
+		if desc.ArtifactType != string(artifactType) {
+			continue
+		}
+		m, err := h.storage.GetManifest(ctx, repo, desc.Digest.String())
+		if err != nil {
+			t.Fatalf("GetManifest() error = %v", err)
+		}
+		artifact, err := v1.ParseManifest(bytes.NewReader(m.Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if artifact.Subject == nil || artifact.Subject.Digest != digest {
+			t.Fatalf("subject = %v, want %s", artifact.Subject, digest)
+		}
+		if len(artifact.Layers) != 1 {
+			t.Fatalf("artifact has %d layers, want 1", len(artifact.Layers))
+		}
+		rc, _, err := h.storage.GetBlob(ctx, repo, artifact.Layers[0].Digest)
+		if err != nil {
+			t.Fatalf("GetBlob() error = %v", err)
+		}
+		defer rc.Close()
+		data, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return artifact.Layers[0], data
+	}
+	t.Fatalf("no referrer of %s in %+v", artifactType, referrers.Manifests)
+	return v1.Descriptor{}, nil
+}
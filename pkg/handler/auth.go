@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/wzshiming/jitdi/pkg/auth"
+)
+
+// WithAuth requires the requests to be authorized.
+func WithAuth(a *auth.Auth) option {
+	return func(h *Handler) {
+		h.auth = a
+	}
+}
+
+// authorize checks the request against the auth, and writes the error if it is not allowed,
+// it returns the name of the identity of the request, empty without the auth.
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if h.auth == nil {
+		return "", true
+	}
+
+	repo := requestRepository(r)
+	action := auth.ActionPull
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		action = auth.ActionPush
+	}
+	id, err := h.auth.AuthorizeIdentity(r, repo, action)
+	if err == nil {
+		return id.Name, true
+	}
+
+	if errors.Is(err, auth.ErrDenied) {
+		_ = regErrDenied.Write(w)
+		return "", false
+	}
+	scope := action
+	if action == auth.ActionPush {
+		scope = auth.ActionPull + "," + auth.ActionPush
+	}
+	h.auth.Challenge(w, r, repo, scope)
+	_ = regErrUnauthorized.Write(w)
+	return "", false
+}
+
+// canPull reports whether the request is allowed to pull the repository.
+func (h *Handler) canPull(r *http.Request, repo string) bool {
+	if repo == "" {
+		return false
+	}
+	if h.auth == nil {
+		return true
+	}
+	return h.auth.Authorize(r, repo, auth.ActionPull) == nil
+}
+
+// authorizer returns the check of the actions on the repositories for the request, nil without the auth.
+func (h *Handler) authorizer(r *http.Request) func(repo, action string) bool {
+	if h.auth == nil {
+		return nil
+	}
+	return h.auth.Authorizer(r)
+}
+
+// requestRepository returns the repository of the request, it is empty if the request is not for a repository.
+func requestRepository(r *http.Request) string {
+	p := r.URL.Path
+	if p == "/jitdi/explain" {
+		image, _ := splitReference(r.URL.Query().Get("ref"))
+		return image
+	}
+	if ref, ok := strings.CutPrefix(p, "/jitdi/export/"); ok {
+		image, _ := splitReference(ref)
+		return image
+	}
+	if ref, ok := strings.CutPrefix(p, "/jitdi/sbom/"); ok {
+		if image, _, ok := strings.Cut(ref, "@"); ok {
+			return image
+		}
+		image, _ := splitReference(ref)
+		return image
+	}
+
+	image, _, _, ok := splitRegistryPath(p)
+	if !ok {
+		return ""
+	}
+	return image
+}
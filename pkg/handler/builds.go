@@ -0,0 +1,438 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wzshiming/jitdi/pkg/apis/v1alpha1"
+	"github.com/wzshiming/jitdi/pkg/auth"
+	"github.com/wzshiming/jitdi/pkg/pattern"
+)
+
+// recentBuilds is the number of the finished builds kept for /debug/builds.
+const recentBuilds = 20
+
+// ConditionBuilding is the condition of the Image, whether it is building, with the progress of the build.
+const ConditionBuilding = "Building"
+
+var (
+	// progressInterval is the interval the progress of the builds is logged and set to the conditions.
+	progressInterval = 10 * time.Second
+	// progressEventInterval is the interval the builds are sent to the event streams.
+	progressEventInterval = time.Second
+)
+
+// buildTracker tracks the builds in flight and the recent finished builds.
+type buildTracker struct {
+	mut      sync.Mutex
+	inFlight []*buildStatus
+	recent   []*buildStatus
+}
+
+// buildStatus is the status of a build.
+type buildStatus struct {
+	reference string
+	rule      string
+	started   time.Time
+
+	mut      sync.Mutex
+	base     string
+	finished time.Time
+	err      error
+	files    []*fileProgress
+}
+
+// fileProgress is the progress of reading a file of a build.
+type fileProgress struct {
+	path    string
+	source  string
+	started time.Time
+	total   atomic.Int64
+	done    atomic.Int64
+}
+
+// start records the build of the reference is started.
+func (t *buildTracker) start(reference, rule string) *buildStatus {
+	b := &buildStatus{
+		reference: reference,
+		rule:      rule,
+		started:   time.Now(),
+	}
+	t.mut.Lock()
+	t.inFlight = append(t.inFlight, b)
+	t.mut.Unlock()
+	return b
+}
+
+// finish records the build is finished with the error.
+func (t *buildTracker) finish(b *buildStatus, err error) {
+	b.mut.Lock()
+	b.finished = time.Now()
+	b.err = err
+	b.mut.Unlock()
+
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	for i, f := range t.inFlight {
+		if f == b {
+			t.inFlight = append(t.inFlight[:i], t.inFlight[i+1:]...)
+			break
+		}
+	}
+	t.recent = append(t.recent, b)
+	if len(t.recent) > recentBuilds {
+		t.recent = t.recent[len(t.recent)-recentBuilds:]
+	}
+}
+
+// setBase sets the base image of the build, pinned by the digest.
+func (b *buildStatus) setBase(base string) {
+	b.mut.Lock()
+	b.base = base
+	b.mut.Unlock()
+}
+
+// file returns the progress of the file, the progress is reset if the file is read again.
+func (b *buildStatus) file(path, source string, total int64) *fileProgress {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	var f *fileProgress
+	for _, p := range b.files {
+		if p.path == path {
+			f = p
+			break
+		}
+	}
+	if f == nil {
+		f = &fileProgress{
+			path:   path,
+			source: source,
+		}
+		b.files = append(b.files, f)
+	}
+	f.started = time.Now()
+	f.total.Store(total)
+	f.done.Store(0)
+	return f
+}
+
+// BuildInfo is the snapshot of a build.
+type BuildInfo struct {
+	Reference string     `json:"reference"`
+	Rule      string     `json:"rule"`
+	Base      string     `json:"base,omitempty"`
+	Started   time.Time  `json:"started"`
+	Finished  *time.Time `json:"finished,omitempty"`
+	Duration  string     `json:"duration"`
+	Error     string     `json:"error,omitempty"`
+	Files     []FileInfo `json:"files"`
+}
+
+// FileInfo is the snapshot of the progress of a file, the bytes done of the total size.
+type FileInfo struct {
+	Path    string  `json:"path"`
+	Source  string  `json:"source"`
+	Done    int64   `json:"done"`
+	Total   int64   `json:"total"`
+	Percent float64 `json:"percent"`
+	// Rate is the bytes read per second.
+	Rate int64 `json:"rate"`
+	// ETA is the estimated time to read the rest of the file, empty if unknown.
+	ETA string `json:"eta,omitempty"`
+}
+
+func (f *fileProgress) info() FileInfo {
+	info := FileInfo{
+		Path:   f.path,
+		Source: f.source,
+		Done:   f.done.Load(),
+		Total:  f.total.Load(),
+	}
+	if info.Total > 0 {
+		info.Percent = math.Floor(float64(info.Done) / float64(info.Total) * 100)
+	}
+	elapsed := time.Since(f.started)
+	if elapsed > 0 {
+		info.Rate = int64(float64(info.Done) / elapsed.Seconds())
+	}
+	if info.Rate > 0 && info.Total > info.Done {
+		eta := time.Duration(float64(info.Total-info.Done) / float64(info.Rate) * float64(time.Second))
+		info.ETA = eta.Round(time.Second).String()
+	}
+	return info
+}
+
+// String returns the progress like "pulling llama2:13b: 43% (5.6/13 GB), ETA 2m0s".
+func (f FileInfo) String() string {
+	if f.Total <= 0 {
+		return fmt.Sprintf("pulling %s: %s", f.Source, formatBytes(f.Done))
+	}
+	s := fmt.Sprintf("pulling %s: %.0f%% (%s)", f.Source, f.Percent, formatBytesOf(f.Done, f.Total))
+	if f.ETA != "" {
+		s += ", ETA " + f.ETA
+	}
+	return s
+}
+
+// Progress returns the progress of the files being read, empty if none.
+func (b BuildInfo) Progress() string {
+	var progress []string
+	for _, f := range b.Files {
+		if f.Total > 0 && f.Done >= f.Total {
+			continue
+		}
+		progress = append(progress, f.String())
+	}
+	return strings.Join(progress, "; ")
+}
+
+var byteUnits = []string{"B", "kB", "MB", "GB", "TB", "PB"}
+
+// byteUnit returns the decimal unit of the size and its scale.
+func byteUnit(n int64) (string, float64) {
+	scale := 1.0
+	i := 0
+	for float64(n) >= scale*1000 && i < len(byteUnits)-1 {
+		scale *= 1000
+		i++
+	}
+	return byteUnits[i], scale
+}
+
+func formatBytes(n int64) string {
+	unit, scale := byteUnit(n)
+	return formatFloat(float64(n)/scale) + " " + unit
+}
+
+// formatBytesOf formats the done and the total in the unit of the total, like "5.6/13 GB".
+func formatBytesOf(done, total int64) string {
+	unit, scale := byteUnit(total)
+	return formatFloat(float64(done)/scale) + "/" + formatFloat(float64(total)/scale) + " " + unit
+}
+
+// formatFloat formats the number with at most a decimal.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(math.Floor(f*10)/10, 'f', -1, 64)
+}
+
+func (b *buildStatus) info() BuildInfo {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	info := BuildInfo{
+		Reference: b.reference,
+		Rule:      b.rule,
+		Base:      b.base,
+		Started:   b.started,
+		Files:     make([]FileInfo, 0, len(b.files)),
+	}
+	if b.finished.IsZero() {
+		info.Duration = time.Since(b.started).Round(time.Millisecond).String()
+	} else {
+		finished := b.finished
+		info.Finished = &finished
+		info.Duration = finished.Sub(b.started).Round(time.Millisecond).String()
+	}
+	if b.err != nil {
+		info.Error = b.err.Error()
+	}
+	for _, f := range b.files {
+		info.Files = append(info.Files, f.info())
+	}
+	return info
+}
+
+// Builds returns the snapshots of the builds in flight and the recent finished builds, the latest first.
+func (h *Handler) Builds() (inFlight, recent []BuildInfo) {
+	h.builds.mut.Lock()
+	defer h.builds.mut.Unlock()
+	inFlight = make([]BuildInfo, 0, len(h.builds.inFlight))
+	for i := len(h.builds.inFlight) - 1; i >= 0; i-- {
+		inFlight = append(inFlight, h.builds.inFlight[i].info())
+	}
+	recent = make([]BuildInfo, 0, len(h.builds.recent))
+	for i := len(h.builds.recent) - 1; i >= 0; i-- {
+		recent = append(recent, h.builds.recent[i].info())
+	}
+	return inFlight, recent
+}
+
+// reportProgress logs the progress of the build and sets it to the ConditionBuilding periodically,
+// until the returned func is called with the result of the build.
+func (h *Handler) reportProgress(action *pattern.Action, status *buildStatus) func(err error) {
+	h.setImageCondition(action, v1alpha1.Condition{
+		Type:    ConditionBuilding,
+		Status:  v1alpha1.ConditionTrue,
+		Reason:  "Building",
+		Message: "building " + status.reference,
+	})
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			progress := status.info().Progress()
+			if progress == "" {
+				continue
+			}
+			slog.Info("build progress", "ref", status.reference, "progress", progress)
+			h.setImageCondition(action, v1alpha1.Condition{
+				Type:    ConditionBuilding,
+				Status:  v1alpha1.ConditionTrue,
+				Reason:  "Building",
+				Message: progress,
+			})
+		}
+	}()
+
+	return func(err error) {
+		close(done)
+		wg.Wait()
+
+		condition := v1alpha1.Condition{
+			Type:    ConditionBuilding,
+			Status:  v1alpha1.ConditionFalse,
+			Reason:  "Succeeded",
+			Message: "built " + status.reference,
+		}
+		if err != nil {
+			condition.Reason = "Failed"
+			condition.Message = err.Error()
+		}
+		h.setImageCondition(action, condition)
+	}
+}
+
+type buildsResponse struct {
+	InFlight []BuildInfo `json:"inFlight"`
+	Recent   []BuildInfo `json:"recent"`
+}
+
+// debugBuilds serves the builds in flight and the recent finished builds of the repositories the request can pull,
+// they are sent as the server-sent events every second if the request accepts text/event-stream.
+//
+//	GET /debug/builds
+func (h *Handler) debugBuilds(w http.ResponseWriter, r *http.Request) {
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		h.streamBuilds(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(h.visibleBuilds(h.authorizer(r)))
+}
+
+// visibleBuilds returns the builds of the repositories allowed to pull by the authorizer, all of them if it is nil.
+func (h *Handler) visibleBuilds(authorizer func(repo, action string) bool) buildsResponse {
+	inFlight, recent := h.Builds()
+	if authorizer == nil {
+		return buildsResponse{
+			InFlight: inFlight,
+			Recent:   recent,
+		}
+	}
+
+	allowed := map[string]bool{}
+	filter := func(builds []BuildInfo) []BuildInfo {
+		visible := make([]BuildInfo, 0, len(builds))
+		for _, b := range builds {
+			repo := buildRepository(b.Reference)
+			ok, checked := allowed[repo]
+			if !checked {
+				ok = authorizer(repo, auth.ActionPull)
+				allowed[repo] = ok
+			}
+			if ok {
+				visible = append(visible, b)
+			}
+		}
+		return visible
+	}
+	return buildsResponse{
+		InFlight: filter(inFlight),
+		Recent:   filter(recent),
+	}
+}
+
+// buildRepository returns the repository of the reference of a build, <repo>:<tag> or <repo>@<digest>.
+func buildRepository(reference string) string {
+	if image, _, ok := strings.Cut(reference, "@"); ok {
+		return image
+	}
+	image, _ := splitReference(reference)
+	return image
+}
+
+func (h *Handler) streamBuilds(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	// The credentials are verified once for the stream.
+	authorizer := h.authorizer(r)
+	ticker := time.NewTicker(progressEventInterval)
+	defer ticker.Stop()
+	for {
+		data, err := json.Marshal(h.visibleBuilds(authorizer))
+		if err != nil {
+			slog.Error("marshal builds", "err", err)
+			return
+		}
+		_, err = fmt.Fprintf(w, "event: builds\ndata: %s\n\n", data)
+		if err != nil {
+			return
+		}
+		err = rc.Flush()
+		if err != nil {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type buildStatusKey struct{}
+
+func withBuildStatus(ctx context.Context, b *buildStatus) context.Context {
+	return context.WithValue(ctx, buildStatusKey{}, b)
+}
+
+func buildStatusFrom(ctx context.Context) *buildStatus {
+	b, _ := ctx.Value(buildStatusKey{}).(*buildStatus)
+	return b
+}
+
+// progressReader records the bytes read into the progress.
+type progressReader struct {
+	io.ReadCloser
+	progress *fileProgress
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.ReadCloser.Read(b)
+	p.progress.done.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,280 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/wzshiming/jitdi/pkg/auth"
+)
+
+func TestDebugBuildsVisible(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	htpasswd := filepath.Join(t.TempDir(), "htpasswd")
+	writeTestFile(t, htpasswd, "alice:"+string(hash)+"\nbob:"+string(hash)+"\n")
+
+	a, err := auth.NewAuth(&auth.Config{
+		Htpasswd: htpasswd,
+		Policies: []auth.Policy{
+			{Users: []string{"alice"}, Repositories: []string{"k8s/{image}"}},
+			{Users: []string{"bob"}, Repositories: []string{"ollama/{model}"}},
+			{Groups: []string{"system:unauthenticated"}, Repositories: []string{"k8s/{image}"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewAuth() error = %v", err)
+	}
+
+	h, err := NewHandler(WithCache(t.TempDir()), WithAuth(a))
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+	rebuild := "ollama/llama2@sha256:" + strings.Repeat("0", 64)
+	h.builds.start("k8s/kubectl:v1.29.3", "k8s/{image}:{tag}")
+	h.builds.finish(h.builds.start(rebuild, "ollama/{model}:{tag}"), nil)
+
+	tests := []struct {
+		name     string
+		user     string
+		wantCode int
+		want     []string
+	}{
+		{
+			name:     "anonymous",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "alice",
+			user:     "alice",
+			wantCode: http.StatusOK,
+			want:     []string{"k8s/kubectl:v1.29.3"},
+		},
+		{
+			name:     "bob",
+			user:     "bob",
+			wantCode: http.StatusOK,
+			want:     []string{rebuild},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/debug/builds", nil)
+			if tt.user != "" {
+				r.SetBasicAuth(tt.user, "secret")
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var resp buildsResponse
+			err := json.NewDecoder(rec.Body).Decode(&resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, b := range append(resp.InFlight, resp.Recent...) {
+				got = append(got, b.Reference)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("builds = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDebugBuildsWithoutAuth(t *testing.T) {
+	h, err := NewHandler(WithCache(t.TempDir()))
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+	h.builds.start("k8s/kubectl:v1.29.3", "k8s/{image}:{tag}")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/builds", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("code = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestBuildInfoProgress(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []FileInfo
+		want  string
+	}{
+		{
+			name: "no files",
+		},
+		{
+			name: "pulling",
+			files: []FileInfo{
+				{Source: "llama2:13b", Done: 5_600_000_000, Total: 13_000_000_000, Percent: 43, ETA: "2m0s"},
+			},
+			want: "pulling llama2:13b: 43% (5.6/13 GB), ETA 2m0s",
+		},
+		{
+			name: "unknown total",
+			files: []FileInfo{
+				{Source: "https://dl.k8s.io/kubectl", Done: 1_500_000},
+			},
+			want: "pulling https://dl.k8s.io/kubectl: 1.5 MB",
+		},
+		{
+			name: "done files skipped",
+			files: []FileInfo{
+				{Source: "a", Done: 100, Total: 100, Percent: 100},
+				{Source: "b", Done: 512, Total: 2048, Percent: 25},
+				{Source: "c", Total: 999},
+			},
+			want: "pulling b: 25% (0.5/2 kB); pulling c: 0% (0/999 B)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := BuildInfo{Files: tt.files}.Progress()
+			if got != tt.want {
+				t.Errorf("Progress() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileProgressInfo(t *testing.T) {
+	f := &fileProgress{
+		path:    "/model",
+		source:  "llama2:13b",
+		started: time.Now().Add(-10 * time.Second),
+	}
+	f.total.Store(1000)
+	f.done.Store(500)
+
+	info := f.info()
+	if info.Percent != 50 {
+		t.Errorf("Percent = %v, want 50", info.Percent)
+	}
+	if info.Rate < 49 || info.Rate > 50 {
+		t.Errorf("Rate = %d, want 50", info.Rate)
+	}
+	if info.ETA != "10s" {
+		t.Errorf("ETA = %q, want 10s", info.ETA)
+	}
+
+	f.done.Store(1000)
+	info = f.info()
+	if info.ETA != "" {
+		t.Errorf("ETA = %q of the read file, want empty", info.ETA)
+	}
+}
+
+func TestDebugBuildsStream(t *testing.T) {
+	defer func(interval time.Duration) {
+		progressEventInterval = interval
+	}(progressEventInterval)
+	progressEventInterval = 10 * time.Millisecond
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	htpasswd := filepath.Join(t.TempDir(), "htpasswd")
+	writeTestFile(t, htpasswd, "alice:"+string(hash)+"\n")
+
+	a, err := auth.NewAuth(&auth.Config{
+		Htpasswd: htpasswd,
+		Policies: []auth.Policy{
+			{Users: []string{"alice"}, Repositories: []string{"k8s/{image}"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewAuth() error = %v", err)
+	}
+
+	h, err := NewHandler(WithCache(t.TempDir()), WithAuth(a))
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+	status := h.builds.start("k8s/kubectl:v1.29.3", "k8s/{image}:{tag}")
+	h.builds.start("ollama/llama2:13b", "ollama/{model}:{tag}")
+
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	r, err := http.NewRequest(http.MethodGet, s.URL+"/debug/builds", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Accept", "text/event-stream")
+	r.SetBasicAuth("alice", "secret")
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	events := bufio.NewScanner(resp.Body)
+	next := func() buildsResponse {
+		t.Helper()
+		var event string
+		for events.Scan() {
+			line := events.Text()
+			if e, ok := strings.CutPrefix(line, "event: "); ok {
+				event = e
+				continue
+			}
+			data, ok := strings.CutPrefix(line, "data: ")
+			if !ok {
+				continue
+			}
+			if event != "builds" {
+				t.Fatalf("event = %q, want builds", event)
+			}
+			var builds buildsResponse
+			err := json.Unmarshal([]byte(data), &builds)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return builds
+		}
+		t.Fatalf("stream ended: %v", events.Err())
+		return buildsResponse{}
+	}
+
+	builds := next()
+	if len(builds.InFlight) != 1 || builds.InFlight[0].Reference != "k8s/kubectl:v1.29.3" {
+		t.Fatalf("inFlight = %+v, want the build of alice", builds.InFlight)
+	}
+
+	// The following events have the progress of the files.
+	status.file("/usr/local/bin/kubectl", "https://dl.k8s.io/kubectl", 1000).done.Store(500)
+	for i := 0; ; i++ {
+		builds = next()
+		if len(builds.InFlight) == 1 && len(builds.InFlight[0].Files) == 1 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("inFlight = %+v, want the progress of the file", builds.InFlight)
+		}
+	}
+	if f := builds.InFlight[0].Files[0]; f.Done != 500 || f.Total != 1000 || f.Percent != 50 {
+		t.Errorf("file = %+v, want 500 of 1000 done", f)
+	}
+}
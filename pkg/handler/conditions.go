@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/wzshiming/jitdi/pkg/apis/v1alpha1"
+	"github.com/wzshiming/jitdi/pkg/pattern"
+)
+
+var (
+	// conditionUpdateInterval is the interval the pending conditions are set to the Images.
+	conditionUpdateInterval = time.Second
+	// conditionUpdateTimeout is the timeout of setting the conditions of an Image.
+	conditionUpdateTimeout = 10 * time.Second
+)
+
+// conditionQueue is the conditions pending to be set to the Images by their names,
+// only the latest condition of each type is kept, so the updates of an Image are coalesced.
+type conditionQueue struct {
+	mut     sync.Mutex
+	pending map[string][]v1alpha1.Condition
+}
+
+func (q *conditionQueue) add(crName string, condition v1alpha1.Condition) {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	if q.pending == nil {
+		q.pending = map[string][]v1alpha1.Condition{}
+	}
+	conditions := q.pending[crName]
+	i := slices.IndexFunc(conditions, func(c v1alpha1.Condition) bool {
+		return c.Type == condition.Type
+	})
+	if i < 0 {
+		q.pending[crName] = append(conditions, condition)
+	} else {
+		conditions[i] = condition
+	}
+}
+
+func (q *conditionQueue) take() map[string][]v1alpha1.Condition {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	pending := q.pending
+	q.pending = nil
+	return pending
+}
+
+// setImageCondition sets the condition of the Image of the rule in the background,
+// the rules not from the Image resources are ignored.
+func (h *Handler) setImageCondition(action *pattern.Action, condition v1alpha1.Condition) {
+	if h.clientset == nil || h.imageStore == nil {
+		return
+	}
+
+	h.crMut.Lock()
+	crName, ok := h.imageCRNames[action.GetRuleMatch()]
+	h.crMut.Unlock()
+	if !ok {
+		return
+	}
+	h.conditions.add(crName, condition)
+}
+
+// startUpdateConditions sets the pending conditions to the Images every conditionUpdateInterval.
+func (h *Handler) startUpdateConditions(ctx context.Context) {
+	ticker := time.NewTicker(conditionUpdateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for crName, conditions := range h.conditions.take() {
+				h.updateImageConditions(ctx, crName, conditions)
+			}
+		}
+	}
+}
+
+func (h *Handler) updateImageConditions(ctx context.Context, crName string, conditions []v1alpha1.Condition) {
+	item, ok, err := h.imageStore.GetByKey(crName)
+	if err != nil || !ok {
+		return
+	}
+	image := item.(*v1alpha1.Image).DeepCopy()
+
+	changed := false
+	for _, condition := range conditions {
+		var c bool
+		image.Status.Conditions, c = setCondition(image.Status.Conditions, condition)
+		changed = changed || c
+	}
+	if !changed {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, conditionUpdateTimeout)
+	defer cancel()
+	_, err = h.clientset.ApisV1alpha1().Images().UpdateStatus(ctx, image, metav1.UpdateOptions{})
+	if err != nil {
+		slog.Warn("failed to update status", "image", crName, "err", err)
+	}
+}
+
+// setCondition sets the condition of the type, it reports whether the conditions are changed.
+func setCondition(conditions []v1alpha1.Condition, condition v1alpha1.Condition) ([]v1alpha1.Condition, bool) {
+	now := metav1.NewTime(time.Now())
+	i := slices.IndexFunc(conditions, func(c v1alpha1.Condition) bool {
+		return c.Type == condition.Type
+	})
+	if i < 0 {
+		condition.LastTransitionTime = now
+		return append(conditions, condition), true
+	}
+
+	old := conditions[i]
+	if old.Status == condition.Status && old.Reason == condition.Reason && old.Message == condition.Message {
+		return conditions, false
+	}
+	condition.LastTransitionTime = old.LastTransitionTime
+	if old.Status != condition.Status {
+		condition.LastTransitionTime = now
+	}
+	conditions = slices.Clone(conditions)
+	conditions[i] = condition
+	return conditions, true
+}
@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/wzshiming/jitdi/pkg/apis/v1alpha1"
+	"github.com/wzshiming/jitdi/pkg/client/clientset/versioned"
+	"github.com/wzshiming/jitdi/pkg/pattern"
+)
+
+// newTestConditionHandler returns the handler of an Image of the rule, its status is updated to the API server.
+func newTestConditionHandler(t *testing.T, apiServer *httptest.Server) (*Handler, *pattern.Action) {
+	t.Helper()
+	spec := v1alpha1.ImageSpec{
+		Match:     "k8s/{image}:{tag}",
+		BaseImage: "docker.io/library/alpine",
+	}
+	rule, err := pattern.NewRule(&spec)
+	if err != nil {
+		t.Fatalf("NewRule() error = %v", err)
+	}
+	action, ok := rule.Match("k8s/kubectl:v1.29.3")
+	if !ok {
+		t.Fatal("Match() not matched")
+	}
+
+	clientset, err := versioned.NewForConfig(&rest.Config{Host: apiServer.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	err = store.Add(&v1alpha1.Image{
+		ObjectMeta: metav1.ObjectMeta{Name: "kubectl"},
+		Spec:       spec,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Handler{
+		clientset:    clientset,
+		imageStore:   store,
+		imageCRNames: map[string]string{spec.Match: "kubectl"},
+	}, action
+}
+
+func TestSetImageCondition(t *testing.T) {
+	var (
+		mut     sync.Mutex
+		updated []v1alpha1.Image
+	)
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var image v1alpha1.Image
+		err := json.NewDecoder(r.Body).Decode(&image)
+		if err != nil {
+			t.Error(err)
+		}
+		mut.Lock()
+		updated = append(updated, image)
+		mut.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(image)
+	}))
+	defer apiServer.Close()
+
+	h, action := newTestConditionHandler(t, apiServer)
+	for _, message := range []string{"building", "pulling 10%", "pulling 20%"} {
+		h.setImageCondition(action, v1alpha1.Condition{
+			Type:    ConditionBuilding,
+			Status:  v1alpha1.ConditionTrue,
+			Reason:  "Building",
+			Message: message,
+		})
+	}
+	mut.Lock()
+	if len(updated) != 0 {
+		t.Errorf("setImageCondition() updated %d times, want in the background", len(updated))
+	}
+	mut.Unlock()
+
+	for crName, conditions := range h.conditions.take() {
+		h.updateImageConditions(context.Background(), crName, conditions)
+	}
+
+	mut.Lock()
+	defer mut.Unlock()
+	if len(updated) != 1 {
+		t.Fatalf("updated %d times, want the conditions coalesced into 1", len(updated))
+	}
+	conditions := updated[0].Status.Conditions
+	if len(conditions) != 1 || conditions[0].Message != "pulling 20%" {
+		t.Errorf("conditions = %+v, want the latest", conditions)
+	}
+	if h.conditions.take() != nil {
+		t.Errorf("take() is not empty after taken")
+	}
+}
+
+func TestUpdateImageConditionsTimeout(t *testing.T) {
+	defer func(timeout time.Duration) {
+		conditionUpdateTimeout = timeout
+	}(conditionUpdateTimeout)
+	conditionUpdateTimeout = 100 * time.Millisecond
+
+	unblock := make(chan struct{})
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer apiServer.Close()
+	defer close(unblock)
+
+	h, action := newTestConditionHandler(t, apiServer)
+	h.setImageCondition(action, v1alpha1.Condition{
+		Type:   ConditionBuilding,
+		Status: v1alpha1.ConditionTrue,
+		Reason: "Building",
+	})
+
+	start := time.Now()
+	for crName, conditions := range h.conditions.take() {
+		h.updateImageConditions(context.Background(), crName, conditions)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("updateImageConditions() took %s, want it bounded by the timeout", elapsed)
+	}
+}
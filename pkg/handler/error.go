@@ -2,7 +2,11 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+
+	"github.com/wzshiming/jitdi/pkg/pattern"
 )
 
 type regError struct {
@@ -40,12 +44,84 @@ func regErrInternal(err error) *regError {
 	}
 }
 
+// regErrBadRequest returns a bad request error.
+func regErrBadRequest(err error) *regError {
+	return &regError{
+		Status:  http.StatusBadRequest,
+		Code:    "BAD_REQUEST",
+		Message: err.Error(),
+	}
+}
+
+// regErrTooManyRequests returns a too many requests error.
+func regErrTooManyRequests(err error) *regError {
+	return &regError{
+		Status:  http.StatusTooManyRequests,
+		Code:    "TOOMANYREQUESTS",
+		Message: err.Error(),
+	}
+}
+
+// regErrDeniedWith returns a denied error with the reason.
+func regErrDeniedWith(err error) *regError {
+	return &regError{
+		Status:  http.StatusForbidden,
+		Code:    "DENIED",
+		Message: err.Error(),
+	}
+}
+
+// regErrDigestInvalid returns an error of the digest not matching the content.
+func regErrDigestInvalid(err error) *regError {
+	return &regError{
+		Status:  http.StatusBadRequest,
+		Code:    "DIGEST_INVALID",
+		Message: err.Error(),
+	}
+}
+
+// regErrManifestInvalid returns an error of the invalid manifest.
+func regErrManifestInvalid(err error) *regError {
+	return &regError{
+		Status:  http.StatusBadRequest,
+		Code:    "MANIFEST_INVALID",
+		Message: err.Error(),
+	}
+}
+
+// regErrManifestBlobUnknown returns an error of the blob referenced by the manifest not existing.
+func regErrManifestBlobUnknown(err error) *regError {
+	return &regError{
+		Status:  http.StatusBadRequest,
+		Code:    "MANIFEST_BLOB_UNKNOWN",
+		Message: err.Error(),
+	}
+}
+
+var regErrUnauthorized = &regError{
+	Status:  http.StatusUnauthorized,
+	Code:    "UNAUTHORIZED",
+	Message: "authentication required",
+}
+
+var regErrDenied = &regError{
+	Status:  http.StatusForbidden,
+	Code:    "DENIED",
+	Message: "requested access to the resource is denied",
+}
+
 var regErrBlobUnknown = &regError{
 	Status:  http.StatusNotFound,
 	Code:    "BLOB_UNKNOWN",
 	Message: "Unknown blob",
 }
 
+var regErrBlobUploadUnknown = &regError{
+	Status:  http.StatusNotFound,
+	Code:    "BLOB_UPLOAD_UNKNOWN",
+	Message: "Unknown blob upload",
+}
+
 var regErrUnsupported = &regError{
 	Status:  http.StatusMethodNotAllowed,
 	Code:    "UNSUPPORTED",
@@ -57,3 +133,28 @@ var regErrNotFound = &regError{
 	Code:    "NAME_UNKNOWN",
 	Message: "Unknown name",
 }
+
+var regErrManifestUnknown = &regError{
+	Status:  http.StatusNotFound,
+	Code:    "MANIFEST_UNKNOWN",
+	Message: "Unknown manifest",
+}
+
+// writeBuildError writes the error of the limits and the sources, and reports whether it is one.
+func writeBuildError(w http.ResponseWriter, err error) bool {
+	var rateErr *RateLimitedError
+	switch {
+	case errors.As(err, &rateErr):
+		w.Header().Set("Retry-After", strconv.Itoa(int(rateErr.RetryAfter.Seconds())+1))
+		_ = regErrTooManyRequests(err).Write(w)
+	case errors.Is(err, ErrTooManyBuilds):
+		w.Header().Set("Retry-After", "10")
+		_ = regErrTooManyRequests(err).Write(w)
+	case errors.Is(err, ErrOutputTooLarge), errors.Is(err, ErrBuildTimeout), errors.Is(err, pattern.ErrSourceNotAllowed),
+		errors.Is(err, ErrBaseNotVerified):
+		_ = regErrDeniedWith(err).Write(w)
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,223 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/wzshiming/jitdi/pkg/apis/v1alpha1"
+	"github.com/wzshiming/jitdi/pkg/pattern"
+)
+
+// WithEventLogPath sets the file the build events are appended to, defaults to the "events.jsonl" in the cache directory.
+func WithEventLogPath(eventLogPath string) option {
+	return func(h *Handler) {
+		h.eventLogPath = eventLogPath
+	}
+}
+
+// WithKubeEvents emits the build events as the Events of the Image resources.
+func WithKubeEvents(clientset kubernetes.Interface) option {
+	return func(h *Handler) {
+		h.kubeEvents = clientset
+	}
+}
+
+// Requester is who requested a build.
+type Requester struct {
+	ClientIP  string `json:"clientIP,omitempty"`
+	UserAgent string `json:"userAgent,omitempty"`
+	// Identity is the name of the authenticated identity, empty without the authentication.
+	Identity string `json:"identity,omitempty"`
+}
+
+type requesterKey struct{}
+
+func withRequester(ctx context.Context, r Requester) context.Context {
+	return context.WithValue(ctx, requesterKey{}, r)
+}
+
+func requesterFrom(ctx context.Context) Requester {
+	r, _ := ctx.Value(requesterKey{}).(Requester)
+	return r
+}
+
+// BuildEvent is the record of a build in the event log.
+type BuildEvent struct {
+	Time      time.Time         `json:"time"`
+	Requester Requester         `json:"requester"`
+	Reference string            `json:"reference"`
+	Rule      string            `json:"rule"`
+	Params    map[string]string `json:"params,omitempty"`
+	// Base is the base image pinned by the digest, empty if the build failed before resolving it.
+	Base     string  `json:"base,omitempty"`
+	Digest   string  `json:"digest,omitempty"`
+	Duration float64 `json:"durationSeconds"`
+	Error    string  `json:"error,omitempty"`
+}
+
+var (
+	// kubeEventQueueSize is the number of the build events pending to be emitted, the events are dropped if it is full.
+	kubeEventQueueSize = 100
+	// kubeEventTimeout is the timeout of emitting a build event as the Event.
+	kubeEventTimeout = 10 * time.Second
+)
+
+// buildRecord is a build event pending to be emitted.
+type buildRecord struct {
+	action   *pattern.Action
+	event    *BuildEvent
+	buildErr error
+}
+
+// newBuildEvent returns the event of the finished build.
+func newBuildEvent(ctx context.Context, action *pattern.Action, status *buildStatus, p *Provenance) *BuildEvent {
+	info := status.info()
+	e := &BuildEvent{
+		Time:      time.Now().UTC(),
+		Requester: requesterFrom(ctx),
+		Reference: info.Reference,
+		Rule:      info.Rule,
+		Params:    action.GetParams(),
+		Base:      info.Base,
+		Error:     info.Error,
+	}
+	if info.Finished != nil {
+		e.Duration = info.Finished.Sub(info.Started).Seconds()
+	}
+	if p != nil {
+		e.Base = p.Base
+		e.Digest = p.Digest.String()
+	}
+	return e
+}
+
+// recordBuild appends the event of the finished build to the event log,
+// and queues it to be emitted as the Event of the Image in the background, it is dropped if the queue is full.
+func (h *Handler) recordBuild(action *pattern.Action, e *BuildEvent, buildErr error) {
+	if h.eventLogPath != "" {
+		err := h.appendEvent(e)
+		if err != nil {
+			slog.Warn("failed to append event", "path", h.eventLogPath, "err", err)
+		}
+	}
+
+	if h.kubeEvents == nil {
+		return
+	}
+	select {
+	case h.kubeEventQueue <- buildRecord{action: action, event: e, buildErr: buildErr}:
+	default:
+		slog.Warn("dropped build event", "ref", e.Reference)
+	}
+}
+
+// startEmitKubeEvents emits the queued events as the Events of the Images.
+func (h *Handler) startEmitKubeEvents(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case r := <-h.kubeEventQueue:
+			h.emitKubeEvent(ctx, r.action, r.event, r.buildErr)
+		}
+	}
+}
+
+// appendEvent appends the event as a line of JSON.
+func (h *Handler) appendEvent(e *BuildEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	h.eventLogMut.Lock()
+	defer h.eventLogMut.Unlock()
+
+	err = os.MkdirAll(filepath.Dir(h.eventLogPath), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(h.eventLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// emitKubeEvent emits the event as the Event of the Image of the rule,
+// the Images are cluster scoped, so the Events are in the default namespace.
+func (h *Handler) emitKubeEvent(ctx context.Context, action *pattern.Action, e *BuildEvent, buildErr error) {
+	if h.imageStore == nil {
+		return
+	}
+	h.crMut.Lock()
+	crName, ok := h.imageCRNames[action.GetRuleMatch()]
+	h.crMut.Unlock()
+	if !ok {
+		return
+	}
+	item, ok, err := h.imageStore.GetByKey(crName)
+	if err != nil || !ok {
+		return
+	}
+	image := item.(*v1alpha1.Image)
+
+	requester := e.Requester.ClientIP
+	if e.Requester.Identity != "" {
+		requester = e.Requester.Identity + " from " + requester
+	}
+	eventType := corev1.EventTypeNormal
+	reason := "Built"
+	message := fmt.Sprintf("Built %s as %s", e.Reference, e.Digest)
+	if buildErr != nil {
+		eventType = corev1.EventTypeWarning
+		reason = "BuildFailed"
+		message = fmt.Sprintf("Failed to build %s: %s", e.Reference, e.Error)
+	}
+	if requester != "" {
+		message += ", requested by " + requester
+	}
+
+	now := metav1.NewTime(e.Time)
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: crName + ".",
+			Namespace:    metav1.NamespaceDefault,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion:      v1alpha1.GroupVersion.String(),
+			Kind:            "Image",
+			Name:            image.Name,
+			UID:             image.UID,
+			ResourceVersion: image.ResourceVersion,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         corev1.EventSource{Component: "jitdi"},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	ctx, cancel := context.WithTimeout(ctx, kubeEventTimeout)
+	defer cancel()
+	_, err = h.kubeEvents.CoreV1().Events(event.Namespace).Create(ctx, event, metav1.CreateOptions{})
+	if err != nil {
+		slog.Warn("failed to create event", "image", crName, "err", err)
+	}
+}
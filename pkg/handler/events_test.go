@@ -0,0 +1,185 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/wzshiming/jitdi/pkg/apis/v1alpha1"
+	"github.com/wzshiming/jitdi/pkg/pattern"
+)
+
+// newTestBuildEvent returns the event of a finished build of kubectl.
+func newTestBuildEvent(t *testing.T, h *Handler, buildErr error) (*pattern.Action, *BuildEvent) {
+	t.Helper()
+	rule, err := pattern.NewRule(&v1alpha1.ImageSpec{
+		Match:     "k8s/{image}:{tag}",
+		BaseImage: "docker.io/library/alpine",
+	})
+	if err != nil {
+		t.Fatalf("NewRule() error = %v", err)
+	}
+	action, ok := rule.Match("k8s/kubectl:v1.29.3")
+	if !ok {
+		t.Fatal("Match() not matched")
+	}
+
+	ctx := withRequester(context.Background(), Requester{
+		ClientIP:  "10.0.0.1",
+		UserAgent: "containerd",
+		Identity:  "alice",
+	})
+	status := h.builds.start("k8s/kubectl:v1.29.3", action.GetRuleMatch())
+	h.builds.finish(status, buildErr)
+
+	var p *Provenance
+	if buildErr == nil {
+		p = &Provenance{
+			Base:   "docker.io/library/alpine@sha256:" + strings.Repeat("1", 64),
+			Digest: v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("2", 64)},
+		}
+	}
+	return action, newBuildEvent(ctx, action, status, p)
+}
+
+func TestRecordBuildEventLog(t *testing.T) {
+	eventLogPath := filepath.Join(t.TempDir(), "events.jsonl")
+	h, err := NewHandler(WithCache(t.TempDir()), WithEventLogPath(eventLogPath))
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+
+	action, e := newTestBuildEvent(t, h, nil)
+	h.recordBuild(action, e, nil)
+	action, e = newTestBuildEvent(t, h, errors.New("build failed"))
+	h.recordBuild(action, e, errors.New("build failed"))
+
+	data, err := os.ReadFile(eventLogPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("event log = %q, want 2 events", data)
+	}
+
+	want := []map[string]any{
+		{
+			"requester": map[string]any{"clientIP": "10.0.0.1", "userAgent": "containerd", "identity": "alice"},
+			"reference": "k8s/kubectl:v1.29.3",
+			"rule":      "k8s/{image}:{tag}",
+			"params":    map[string]any{"image": "kubectl", "tag": "v1.29.3"},
+			"base":      "docker.io/library/alpine@sha256:" + strings.Repeat("1", 64),
+			"digest":    "sha256:" + strings.Repeat("2", 64),
+		},
+		{
+			"requester": map[string]any{"clientIP": "10.0.0.1", "userAgent": "containerd", "identity": "alice"},
+			"reference": "k8s/kubectl:v1.29.3",
+			"rule":      "k8s/{image}:{tag}",
+			"params":    map[string]any{"image": "kubectl", "tag": "v1.29.3"},
+			"error":     "build failed",
+		},
+	}
+	for i, line := range lines {
+		var got map[string]any
+		err := json.Unmarshal(line, &got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := got["time"]; !ok {
+			t.Errorf("event %d has no time", i)
+		}
+		if _, ok := got["durationSeconds"]; !ok {
+			t.Errorf("event %d has no durationSeconds", i)
+		}
+		delete(got, "time")
+		delete(got, "durationSeconds")
+		if !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("event %d = %v, want %v", i, got, want[i])
+		}
+	}
+}
+
+func TestRecordBuildKubeEvent(t *testing.T) {
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	err := store.Add(&v1alpha1.Image{
+		ObjectMeta: metav1.ObjectMeta{Name: "kubectl", UID: "uid"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientset := fake.NewSimpleClientset()
+	h := &Handler{
+		imageStore:     store,
+		imageCRNames:   map[string]string{"k8s/{image}:{tag}": "kubectl"},
+		kubeEvents:     clientset,
+		kubeEventQueue: make(chan buildRecord, kubeEventQueueSize),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.startEmitKubeEvents(ctx)
+
+	buildErr := errors.New("build failed")
+	action, e := newTestBuildEvent(t, h, buildErr)
+	h.recordBuild(action, e, buildErr)
+
+	for i := 0; ; i++ {
+		events, err := clientset.CoreV1().Events(metav1.NamespaceDefault).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events.Items) == 1 {
+			event := events.Items[0]
+			if event.Reason != "BuildFailed" || event.Type != "Warning" {
+				t.Errorf("event = %s %s, want Warning BuildFailed", event.Type, event.Reason)
+			}
+			want := "Failed to build k8s/kubectl:v1.29.3: build failed, requested by alice from 10.0.0.1"
+			if event.Message != want {
+				t.Errorf("message = %q, want %q", event.Message, want)
+			}
+			if event.InvolvedObject.Kind != "Image" || event.InvolvedObject.Name != "kubectl" || event.InvolvedObject.UID != "uid" {
+				t.Errorf("involvedObject = %+v, want the Image kubectl", event.InvolvedObject)
+			}
+			return
+		}
+		if i == 100 {
+			t.Fatalf("events = %d, want 1", len(events.Items))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestRecordBuildQueueFull(t *testing.T) {
+	h := &Handler{
+		kubeEvents:     fake.NewSimpleClientset(),
+		kubeEventQueue: make(chan buildRecord, 1),
+	}
+	action, e := newTestBuildEvent(t, h, nil)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.recordBuild(action, e, nil)
+		h.recordBuild(action, e, nil)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("recordBuild() blocked on the full queue")
+	}
+	if len(h.kubeEventQueue) != 1 {
+		t.Errorf("queued %d events, want 1", len(h.kubeEventQueue))
+	}
+}
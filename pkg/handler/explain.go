@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+
+	"github.com/wzshiming/jitdi/pkg/apis/v1alpha1"
+	"github.com/wzshiming/jitdi/pkg/pattern"
+)
+
+// Explanation is how a reference is resolved by the rules, nothing is built.
+type Explanation struct {
+	Reference string `json:"reference"`
+	// Rule is the match of the rule the reference is built by, empty if no rule matches.
+	Rule string `json:"rule,omitempty"`
+	// Candidates is all the rules in the order they are tried, the first matched one is used.
+	Candidates []*ExplainedRule `json:"candidates"`
+}
+
+// ExplainedRule is the result of a rule matching the reference.
+type ExplainedRule struct {
+	Rule    string `json:"rule"`
+	Matched bool   `json:"matched"`
+	// Reason is why the rule does not match.
+	Reason    string             `json:"reason,omitempty"`
+	Params    map[string]string  `json:"params,omitempty"`
+	BaseImage string             `json:"baseImage,omitempty"`
+	Platforms []*ExplainedMutate `json:"platforms,omitempty"`
+	// Denied is why the build is rejected by the sources of the rule.
+	Denied string `json:"denied,omitempty"`
+}
+
+// ExplainedMutate is the mutates of a platform with the params substituted.
+type ExplainedMutate struct {
+	// Platform is empty for the image without the platforms,
+	// its mutates are substituted as linux/amd64 and applied to each platform of the base image.
+	Platform string            `json:"platform,omitempty"`
+	Mutates  []v1alpha1.Mutate `json:"mutates"`
+	// Denied is why the mutates are rejected by the sources of the rule.
+	Denied string `json:"denied,omitempty"`
+}
+
+// Explain returns how the reference is resolved by the rules without building it,
+// the reference is <image>:<tag>.
+func (h *Handler) Explain(ref string) *Explanation {
+	image, tag := splitReference(ref)
+	ref = image + ":" + tag
+
+	e := &Explanation{
+		Reference:  ref,
+		Candidates: []*ExplainedRule{},
+	}
+	for _, rule := range h.getImageRules() {
+		c := explainRule(rule, ref)
+		if c.Matched && e.Rule == "" {
+			e.Rule = c.Rule
+		}
+		e.Candidates = append(e.Candidates, c)
+	}
+	return e
+}
+
+func explainRule(rule *pattern.Rule, ref string) *ExplainedRule {
+	c := &ExplainedRule{
+		Rule: rule.String(),
+	}
+	action, err := rule.Explain(ref)
+	if err != nil {
+		c.Reason = err.Error()
+		return c
+	}
+	c.Matched = true
+	c.Params = action.GetParams()
+	c.BaseImage = action.GetBaseImage()
+	err = action.CheckBaseImage()
+	if err != nil {
+		c.Denied = err.Error()
+	}
+
+	platforms := []*v1.Platform{nil}
+	if ps := action.GetPlatforms(); len(ps) != 0 {
+		platforms = platforms[:0]
+		for _, p := range ps {
+			platforms = append(platforms, &v1.Platform{OS: p.OS, Architecture: p.Architecture, Variant: p.Variant})
+		}
+	}
+	for _, p := range platforms {
+		m := &ExplainedMutate{
+			Mutates: action.GetMutates(p),
+		}
+		if p != nil {
+			m.Platform = p.String()
+		}
+		err = action.CheckMutates(m.Mutates)
+		if err != nil {
+			m.Denied = err.Error()
+		}
+		c.Platforms = append(c.Platforms, m)
+	}
+	return c
+}
+
+// explain serves the explanation of the reference.
+//
+//	GET /jitdi/explain?ref=<image>:<tag>
+func (h *Handler) explain(w http.ResponseWriter, r *http.Request) {
+	ref := r.URL.Query().Get("ref")
+	if ref == "" {
+		_ = regErrBadRequest(errors.New("ref is required")).Write(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(h.Explain(ref))
+}
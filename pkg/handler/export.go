@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+
+	"github.com/wzshiming/jitdi/pkg/storage"
+)
+
+// ErrNoMatch is returned when no rule matches the image.
+var ErrNoMatch = errors.New("no rule matched")
+
+const (
+	// ExportFormatDocker is a tarball that can be loaded by `docker load`.
+	ExportFormatDocker = "docker"
+	// ExportFormatOCI is a tarball of an OCI image layout.
+	ExportFormatOCI = "oci"
+)
+
+// ExportOptions is the options of Export.
+type ExportOptions struct {
+	// Format is the format of the tarball, ExportFormatDocker or ExportFormatOCI.
+	Format string
+	// Platform selects the image from the image index.
+	// It is required by ExportFormatDocker, and defaults to linux/amd64.
+	Platform *v1.Platform
+	// Name is the image name in the tarball, defaults to the reference.
+	Name string
+}
+
+// Export builds the image if needed and writes it as a tarball.
+func (h *Handler) Export(ctx context.Context, w io.Writer, ref string, opts ExportOptions) error {
+	t, err := h.resolveExport(ctx, ref, &opts)
+	if err != nil {
+		return err
+	}
+	return writeExport(w, t, opts)
+}
+
+func (h *Handler) export(w http.ResponseWriter, r *http.Request) {
+	ref := strings.TrimPrefix(r.URL.Path, "/jitdi/export/")
+	query := r.URL.Query()
+
+	opts := ExportOptions{
+		Format: query.Get("format"),
+		Name:   r.Host + "/" + ref,
+	}
+	if p := query.Get("platform"); p != "" {
+		platform, err := v1.ParsePlatform(p)
+		if err != nil {
+			_ = regErrBadRequest(err).Write(w)
+			return
+		}
+		opts.Platform = platform
+	}
+
+	t, err := h.resolveExport(r.Context(), ref, &opts)
+	if err != nil {
+		if errors.Is(err, ErrNoMatch) {
+			_ = regErrNotFound.Write(w)
+			return
+		}
+		slog.Error("export", "err", err, "ref", ref)
+		if writeBuildError(w, err) {
+			return
+		}
+		_ = regErrInternal(err).Write(w)
+		return
+	}
+
+	filename := strings.NewReplacer("/", "_", ":", "_").Replace(ref) + "." + opts.Format + ".tar"
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	err = writeExport(w, t, opts)
+	if err != nil {
+		slog.Error("export", "err", err, "ref", ref)
+	}
+}
+
+func (h *Handler) resolveExport(ctx context.Context, ref string, opts *ExportOptions) (partial.Describable, error) {
+	switch opts.Format {
+	case "":
+		opts.Format = ExportFormatDocker
+	case ExportFormatDocker, ExportFormatOCI:
+	default:
+		return nil, fmt.Errorf("unsupported export format %q", opts.Format)
+	}
+	if opts.Name == "" {
+		opts.Name = ref
+	}
+	if opts.Platform == nil && opts.Format == ExportFormatDocker {
+		opts.Platform = &v1.Platform{
+			OS:           "linux",
+			Architecture: "amd64",
+		}
+	}
+
+	image, tag := splitReference(ref)
+	action, ok := h.match(ctx, image, tag)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoMatch, ref)
+	}
+
+	manifest, err := h.getOrBuildManifest(ctx, image, tag, action)
+	if err != nil {
+		return nil, err
+	}
+
+	if !manifest.MediaType.IsIndex() {
+		return storage.Image(ctx, h.storage, image, manifest)
+	}
+
+	index, err := storage.ImageIndex(ctx, h.storage, image, manifest)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Platform == nil {
+		return index, nil
+	}
+
+	indexManifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	for _, desc := range indexManifest.Manifests {
+		if desc.Platform != nil && desc.Platform.Satisfies(*opts.Platform) {
+			return index.Image(desc.Digest)
+		}
+	}
+	return nil, fmt.Errorf("no image for platform %s in %s", opts.Platform, ref)
+}
+
+func writeExport(w io.Writer, t partial.Describable, opts ExportOptions) error {
+	switch opts.Format {
+	case ExportFormatOCI:
+		return storage.WriteOCIArchive(w, opts.Name, t)
+	default:
+		image, ok := t.(v1.Image)
+		if !ok {
+			return fmt.Errorf("docker format requires an image, got %T", t)
+		}
+		tag, err := name.NewTag(opts.Name)
+		if err != nil {
+			return err
+		}
+		return tarball.Write(tag, image, w)
+	}
+}
+
+// splitReference splits the reference into the image and the tag, the tag defaults to latest.
+func splitReference(ref string) (image, tag string) {
+	i := strings.LastIndex(ref, ":")
+	if i < 0 || i < strings.LastIndex(ref, "/") {
+		return ref, "latest"
+	}
+	return ref[:i], ref[i+1:]
+}
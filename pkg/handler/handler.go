@@ -2,6 +2,8 @@ package handler
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"path"
@@ -13,40 +15,100 @@ import (
 
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
 	"github.com/wzshiming/httpseek"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/time/rate"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 
 	"github.com/wzshiming/jitdi/pkg/apis/v1alpha1"
 	"github.com/wzshiming/jitdi/pkg/atomic"
+	"github.com/wzshiming/jitdi/pkg/auth"
 	"github.com/wzshiming/jitdi/pkg/builder"
 	"github.com/wzshiming/jitdi/pkg/client/clientset/versioned"
+	"github.com/wzshiming/jitdi/pkg/metrics"
 	"github.com/wzshiming/jitdi/pkg/pattern"
+	"github.com/wzshiming/jitdi/pkg/signer"
 	"github.com/wzshiming/jitdi/pkg/storage"
+	"github.com/wzshiming/jitdi/pkg/tracing"
 )
 
+// localRegistry is the placeholder registry of the built images,
+// so that the repository in storage is the same as the matched image.
+const localRegistry = "jitdi.local"
+
 type Handler struct {
+	cachePath    string
+	cacheFormat  string
 	manifestPath string
 	blobPath     string
 	linkPath     string
 
 	storageRegistry string
+	storageURL      string
+	storage         storage.Storage
+
+	offline     bool
+	offlinePath string
+
+	provenancePath string
+
+	artifactPush bool
+
+	signer *signer.Signer
+
+	transport http.RoundTripper
+
+	// handler is the traced serveHTTP.
+	handler http.Handler
+
+	verifyOnServe  bool
+	verifyInterval time.Duration
+	verified       atomic.SyncMap[v1.Hash, struct{}]
+
+	auth *auth.Auth
 
-	buildMutex atomic.SyncMap[string, *sync.RWMutex]
+	maxConcurrentBuilds int
+	maxBuildDuration    time.Duration
+	maxOutputSize       int64
+	clientRateLimit     rate.Limit
+	clientRateBurst     int
+	limits              limits
+
+	buildCalls atomic.SyncMap[string, *buildCall]
+	builds     buildTracker
+	conditions conditionQueue
 
 	crMut sync.Mutex
 
 	imageRules []*pattern.Rule
-	imageCR    []*pattern.Rule
-	imageStore cache.Store
+	// imageConfig is the Images of the config files, their rules are the imageRules.
+	imageConfig []*v1alpha1.Image
+	imageCR     []*pattern.Rule
+	imageStore  cache.Store
+	// imageSynced reports whether the informer of the Image resources is synced.
+	imageSynced cache.InformerSynced
+	// imageCRNames is the names of the Image resources by the match of their rules.
+	imageCRNames map[string]string
 
 	registryRules map[string]*v1alpha1.RegistrySpec
 	registryCR    map[string]*v1alpha1.RegistrySpec
 	registryStore cache.Store
 
 	clientset *versioned.Clientset
+
+	eventLogPath string
+	eventLogMut  sync.Mutex
+	// kubeEvents emits the builds as the Events of the Image resources.
+	kubeEvents kubernetes.Interface
+	// kubeEventQueue is the build events pending to be emitted.
+	kubeEventQueue chan buildRecord
 }
 
 type option func(*Handler)
@@ -57,6 +119,13 @@ func WithStorageRegistry(storageRegistry string) option {
 	}
 }
 
+// WithStorage sets the storage backend by URL, it takes precedence over WithCache and WithStorageRegistry.
+func WithStorage(storageURL string) option {
+	return func(h *Handler) {
+		h.storageURL = storageURL
+	}
+}
+
 func WithClientset(clientset *versioned.Clientset) option {
 	return func(h *Handler) {
 		h.clientset = clientset
@@ -65,19 +134,58 @@ func WithClientset(clientset *versioned.Clientset) option {
 
 func WithCache(cache string) option {
 	return func(h *Handler) {
+		h.cachePath = cache
 		h.manifestPath = path.Join(cache, "manifests")
 		h.blobPath = path.Join(cache, "blobs")
 		h.linkPath = path.Join(cache, "links")
 	}
 }
 
+// WithCacheFormat sets the layout of the images in the cache directory,
+// "jitdi" for the jitdi cache layout or "oci" for an OCI image-layout.
+func WithCacheFormat(format string) option {
+	return func(h *Handler) {
+		h.cacheFormat = format
+	}
+}
+
+// WithOffline disables the network access of the builds,
+// the dependencies are resolved from the offline directory.
+func WithOffline(offline bool) option {
+	return func(h *Handler) {
+		h.offline = offline
+	}
+}
+
+// WithOfflinePath sets the offline directory, defaults to the "offline" in the cache directory,
+// it is required for the offline mode without the cache directory.
+func WithOfflinePath(offlinePath string) option {
+	return func(h *Handler) {
+		h.offlinePath = offlinePath
+	}
+}
+
+// WithVerifyOnServe re-hashes each blob the first time it is served after restart.
+func WithVerifyOnServe(verifyOnServe bool) option {
+	return func(h *Handler) {
+		h.verifyOnServe = verifyOnServe
+	}
+}
+
+// WithVerifyInterval re-hashes all blobs of the storage on the interval, 0 disables it.
+func WithVerifyInterval(interval time.Duration) option {
+	return func(h *Handler) {
+		h.verifyInterval = interval
+	}
+}
+
 func WithImageConfig(imageConfig []*v1alpha1.Image) option {
 	return func(h *Handler) {
 		rules := make([]*pattern.Rule, 0, len(imageConfig))
 		for _, c := range imageConfig {
 			r, err := pattern.NewRule(&c.Spec)
 			if err != nil {
-				slog.Error("newImageRule", "err", err)
+				slog.Error("newImageRule", "err", err, "image", c.Name)
 				continue
 			}
 			rules = append(rules, r)
@@ -88,6 +196,7 @@ func WithImageConfig(imageConfig []*v1alpha1.Image) option {
 		})
 
 		h.imageRules = rules
+		h.imageConfig = imageConfig
 	}
 }
 
@@ -109,13 +218,79 @@ func NewHandler(opts ...option) (*Handler, error) {
 		opt(h)
 	}
 
+	h.transport = tracing.Transport(newTransport())
+	h.handler = tracing.Handler("ServeHTTP", Route, http.HandlerFunc(h.serveHTTP))
+
+	if h.maxConcurrentBuilds > 0 {
+		h.limits.builds = make(chan struct{}, h.maxConcurrentBuilds)
+	}
+	if h.clientRateLimit > 0 && h.clientRateBurst <= 0 {
+		h.clientRateBurst = 1
+	}
+
+	if h.provenancePath == "" {
+		if h.cachePath == "" {
+			return nil, fmt.Errorf("provenance path is required without the cache directory")
+		}
+		h.provenancePath = path.Join(h.cachePath, "provenance")
+	}
+
+	if h.offlinePath == "" && h.cachePath != "" {
+		h.offlinePath = path.Join(h.cachePath, "offline")
+	}
+	if h.offline && h.offlinePath == "" {
+		return nil, fmt.Errorf("offline path is required without the cache directory")
+	}
+
+	if h.eventLogPath == "" && h.cachePath != "" {
+		h.eventLogPath = path.Join(h.cachePath, "events.jsonl")
+	}
+	if h.kubeEvents != nil {
+		h.kubeEventQueue = make(chan buildRecord, kubeEventQueueSize)
+		go h.startEmitKubeEvents(context.Background())
+	}
+
+	s, err := h.newStorage()
+	if err != nil {
+		return nil, err
+	}
+	h.storage = s
+
+	if h.cachePath != "" {
+		metrics.SetCacheUsage(cacheUsage(h.cachePath))
+	}
+
 	if h.clientset != nil {
-		go h.startWatchImageCR(context.Background())
+		h.startWatchImageCR(context.Background())
+		go h.startUpdateConditions(context.Background())
+	}
+
+	if h.verifyInterval > 0 {
+		go h.startVerifyBlobs(context.Background(), h.verifyInterval)
 	}
 
 	return h, nil
 }
 
+func (h *Handler) newStorage() (storage.Storage, error) {
+	keychain := storage.WithKeychain(registryKeychain{h})
+	switch {
+	case h.storageURL != "":
+		return storage.NewStorage(h.storageURL, keychain)
+	case h.storageRegistry != "":
+		return storage.NewRegistryStorage(h.storageRegistry, false, keychain)
+	}
+
+	switch h.cacheFormat {
+	case "", "jitdi":
+		return storage.NewLocalStorage(h.blobPath, h.manifestPath), nil
+	case "oci":
+		return storage.NewOCILayoutStorage(h.cachePath)
+	default:
+		return nil, fmt.Errorf("unsupported cache format %q", h.cacheFormat)
+	}
+}
+
 func (h *Handler) startWatchImageCR(ctx context.Context) {
 	api := h.clientset.ApisV1alpha1().Images()
 	store, controller := cache.NewInformer(
@@ -142,7 +317,8 @@ func (h *Handler) startWatchImageCR(ctx context.Context) {
 		},
 	)
 	h.imageStore = store
-	controller.Run(ctx.Done())
+	h.imageSynced = controller.HasSynced
+	go controller.Run(ctx.Done())
 }
 
 func (h *Handler) startWatchRegistryCR(ctx context.Context) {
@@ -197,21 +373,24 @@ func (h *Handler) getImageRules() []*pattern.Rule {
 		list := h.imageStore.List()
 		cr := make([]*pattern.Rule, 0, len(h.imageRules)+len(list))
 		cr = append(cr, h.imageRules...)
+		names := map[string]string{}
 
 		for _, item := range list {
 			image := item.(*v1alpha1.Image)
 			r, err := pattern.NewRule(&image.Spec)
 			if err != nil {
-				slog.Error("newImageRule", "err", err)
+				slog.Error("newImageRule", "err", err, "image", image.Name)
 				continue
 			}
 			cr = append(cr, r)
+			names[image.Spec.Match] = image.Name
 		}
 		sort.Slice(cr, func(i, j int) bool {
 			return cr[i].LessThan(cr[j])
 		})
 
 		h.imageCR = cr
+		h.imageCRNames = names
 	}
 
 	return h.imageCR
@@ -260,83 +439,267 @@ func (h *Handler) getAuthn(host string) authn.Authenticator {
 	return nil
 }
 
-func (h *Handler) getPusher(ref name.Reference) (storage.Pusher, error) {
-	reg := ref.Context().RegistryStr()
+type registryKeychain struct {
+	h *Handler
+}
 
-	auth := h.getAuthn(reg)
+func (k registryKeychain) Resolve(res authn.Resource) (authn.Authenticator, error) {
+	auth := k.h.getAuthn(res.RegistryStr())
 	if auth == nil {
-		return storage.NewPusher()
+		return authn.Anonymous, nil
 	}
-
-	return storage.NewPusher(
-		storage.WithAuth(
-			auth,
-		),
-	)
+	return auth, nil
 }
 
 func (h *Handler) getPuller(ref name.Reference) (*storage.Puller, error) {
 	reg := ref.Context().RegistryStr()
 
+	transport := metrics.CountTransport(metrics.SourceRegistry, h.transport)
+
 	auth := h.getAuthn(reg)
 	if auth == nil {
-		return storage.NewPuller()
+		return storage.NewPuller(
+			storage.WithTransport(transport),
+		)
 	}
 
 	return storage.NewPuller(
 		storage.WithAuth(
 			auth,
 		),
+		storage.WithTransport(transport),
 	)
 }
 
+// getBase returns the base image or image index.
+func (h *Handler) getBase(ctx context.Context, ref name.Reference) (partial.Describable, error) {
+	if h.offline {
+		return h.getOfflineBase(ctx, ref)
+	}
+
+	puller, err := h.getPuller(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	desc, err := puller.Get(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if desc.MediaType.IsIndex() {
+		return desc.ImageIndex()
+	}
+	return desc.Image()
+}
+
+func (h *Handler) getTransport() http.RoundTripper {
+	if h.offline {
+		return &offlineTransport{
+			filesPath:  path.Join(h.offlinePath, offlineFilesDir),
+			ollamaPath: path.Join(h.offlinePath, offlineOllamaDir),
+		}
+	}
+	return h.transport
+}
+
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if !strings.HasPrefix(r.URL.Path, "/v2/") {
-		_ = regErrNotFound.Write(w)
+	h.handler.ServeHTTP(w, r)
+}
+
+func (h *Handler) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/jitdi/token" && h.auth != nil {
+		h.auth.ServeToken(w, r)
 		return
 	}
 
-	if r.Method != http.MethodGet && r.Method != http.MethodHead {
-		_ = regErrUnsupported.Write(w)
+	// The probes are served without the credentials.
+	if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+		h.serveProbe(w, r)
+		return
+	}
+
+	// The public key is public, the clients verify the signatures without the credentials.
+	if r.URL.Path == "/jitdi/cosign.pub" && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		h.publicKey(w, r)
+		return
+	}
+
+	identity, ok := h.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := withClient(r.Context(), requestClient(r))
+	ctx = withRequester(ctx, Requester{
+		ClientIP:  requestClient(r),
+		UserAgent: r.UserAgent(),
+		Identity:  identity,
+	})
+	r = r.WithContext(ctx)
+
+	if strings.HasPrefix(r.URL.Path, "/jitdi/") {
+		h.serveJitdi(w, r)
+		return
+	}
+
+	if r.URL.Path == "/debug/builds" {
+		// The builds are served only to the authenticated users.
+		if h.auth == nil {
+			_ = regErrNotFound.Write(w)
+			return
+		}
+		if identity == auth.Anonymous.Name {
+			h.auth.Challenge(w, r, "", auth.ActionPull)
+			_ = regErrUnauthorized.Write(w)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			_ = regErrUnsupported.Write(w)
+			return
+		}
+		h.debugBuilds(w, r)
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, "/v2/") {
+		_ = regErrNotFound.Write(w)
 		return
 	}
 
 	if r.URL.Path == "/v2/" {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			_ = regErrUnsupported.Write(w)
+			return
+		}
 		w.Write([]byte("{}"))
 		return
 	}
 
-	parts := strings.Split(r.URL.Path, "/")
-	if len(parts) < 4 {
+	image, typ, reference, ok := splitRegistryPath(r.URL.Path)
+	if !ok {
 		_ = regErrNotFound.Write(w)
 		return
 	}
 
-	image := strings.Join(parts[2:len(parts)-2], "/")
+	read := r.Method == http.MethodGet || r.Method == http.MethodHead
+	switch {
+	case typ == "blobs" && read:
+		h.blobs(w, r, image, reference)
+	case typ == "manifests" && read:
+		h.manifests(w, r, image, reference)
+	case typ == "referrers" && read:
+		h.referrers(w, r, image, reference)
+	case typ == "uploads" && h.artifactPush && r.Method == http.MethodPost && reference == "":
+		h.startUpload(w, r, image)
+	case typ == "uploads" && h.artifactPush && (r.Method == http.MethodPatch || r.Method == http.MethodPut):
+		h.patchUpload(w, r, image, reference)
+	case typ == "manifests" && h.artifactPush && r.Method == http.MethodPut:
+		h.putManifest(w, r, image, reference)
+	case !read:
+		_ = regErrUnsupported.Write(w)
+	default:
+		_ = regErrNotFound.Write(w)
+	}
+}
 
-	typ := parts[len(parts)-2]
+// splitRegistryPath splits the path of the registry API into the image, the type and the reference,
+//
+//	/v2/<name>/blobs/<digest>
+//	/v2/<name>/blobs/uploads/<id>
+//	/v2/<name>/manifests/<reference>
+//	/v2/<name>/referrers/<digest>
+func splitRegistryPath(p string) (image, typ, reference string, ok bool) {
+	parts := strings.Split(p, "/")
+	if len(parts) < 5 || parts[0] != "" || parts[1] != "v2" {
+		return "", "", "", false
+	}
+	end := len(parts) - 2
+	typ, reference = parts[end], parts[end+1]
+	if typ == "uploads" {
+		if parts[end-1] != "blobs" {
+			return "", "", "", false
+		}
+		end--
+	}
 	switch typ {
-	case "blobs":
-		h.blobs(w, r, image, parts[len(parts)-1])
-	case "manifests":
-		h.manifests(w, r, image, parts[len(parts)-1])
+	case "blobs", "uploads", "manifests", "referrers":
+	default:
+		return "", "", "", false
+	}
+	image = strings.Join(parts[2:end], "/")
+	if image == "" {
+		return "", "", "", false
+	}
+	return image, typ, reference, true
+}
+
+func (h *Handler) serveJitdi(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		_ = regErrUnsupported.Write(w)
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/jitdi/export/"):
+		h.export(w, r)
+	case strings.HasPrefix(r.URL.Path, "/jitdi/sbom/"):
+		h.sbom(w, r)
+	case r.URL.Path == "/jitdi/explain":
+		h.explain(w, r)
 	default:
 		_ = regErrNotFound.Write(w)
 	}
 }
 
 func (h *Handler) manifests(w http.ResponseWriter, r *http.Request, image, tag string) {
-	if strings.HasPrefix(tag, "sha256:") {
-		if h.storageRegistry != "" {
-			h.forwardManifestBlob(w, r, image, tag)
+	if storage.IsDigest(tag) {
+		manifest, err := h.getOrRebuildManifest(r.Context(), image, tag)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				_ = regErrManifestUnknown.Write(w)
+				return
+			}
+			slog.Error("image.Rebuild", "err", err)
+			if writeBuildError(w, err) {
+				return
+			}
+			_ = regErrInternal(err).Write(w)
+			return
+		}
+		serveManifest(w, r, manifest)
+		return
+	}
+
+	// The artifacts are not built, e.g. the signatures and the referrers indexes.
+	if isArtifactTag(tag) {
+		manifest, err := h.storage.GetManifest(r.Context(), image, tag)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				_ = regErrManifestUnknown.Write(w)
+				return
+			}
+			_ = regErrInternal(err).Write(w)
 			return
 		}
-		blobPath := storage.LocalBlobPath(h.blobPath, tag)
-		serveManifest(w, r, blobPath)
+		serveManifest(w, r, manifest)
 		return
 	}
 
+	action, ok := h.match(r.Context(), image, tag)
+	if !ok {
+		regErrNotFound.Write(w)
+		return
+	}
+
+	h.buildManifests(w, r, image, tag, action)
+}
+
+func (h *Handler) match(ctx context.Context, image, tag string) (*pattern.Action, bool) {
 	ref := image + ":" + tag
+	_, span := tracing.Start(ctx, "match", attribute.String("jitdi.reference", ref))
+	defer span.End()
+
 	rules := h.getImageRules()
 
 	var action *pattern.Action
@@ -347,96 +710,201 @@ func (h *Handler) manifests(w http.ResponseWriter, r *http.Request, image, tag s
 		}
 		return ok
 	})
-
-	if i < 0 {
-		regErrNotFound.Write(w)
-		return
+	if i >= 0 {
+		span.SetAttributes(attribute.String("jitdi.rule", action.GetRuleMatch()))
 	}
+	return action, i >= 0
+}
 
-	h.localManifests(w, r, image, tag, action)
-	return
+// buildCall is a build in progress, the concurrent builds of the same reference wait for it and share its error.
+type buildCall struct {
+	mut sync.RWMutex
+	err error
 }
 
-func (h *Handler) buildAndSave(ctx context.Context, repo, tag string, action *pattern.Action) error {
-	ref := repo + ":" + tag
-	mut, ok := h.buildMutex.LoadOrStore(ref, &sync.RWMutex{})
-	if ok {
-		mut.RLock()
-		defer mut.RUnlock()
-		return nil
+// buildAndSave builds the image of the action and saves it to the storage with the reference.
+// If pinned is not nil, it is a rebuild of the provenance, the base is pinned
+// and the build is saved by its digest instead of the tag.
+func (h *Handler) buildAndSave(ctx context.Context, repo, reference string, action *pattern.Action, pinned *Provenance) (retErr error) {
+	ref := repo + ":" + reference
+	if pinned != nil {
+		ref = repo + "@" + reference
 	}
-
-	mut.Lock()
+	// The call is locked before it is stored, so the waiters always wait for the result of the build.
+	call := &buildCall{}
+	call.mut.Lock()
+	if c, ok := h.buildCalls.LoadOrStore(ref, call); ok {
+		c.mut.RLock()
+		defer c.mut.RUnlock()
+		return c.err
+	}
+	var event *BuildEvent
 	defer func() {
-		h.buildMutex.Delete(ref)
-		mut.Unlock()
-	}()
+		call.err = retErr
+		h.buildCalls.Delete(ref)
+		call.mut.Unlock()
 
-	source := action.GetBaseImage()
-
-	refSource, err := name.ParseReference(source)
-	if err != nil {
-		return err
-	}
-	puller, err := h.getPuller(refSource)
-	if err != nil {
-		return err
-	}
+		// The event is recorded after the waiters are released.
+		if event != nil {
+			h.recordBuild(action, event, retErr)
+		}
+	}()
 
-	desc, err := puller.Get(ctx, refSource)
+	// The rejected builds are not started, they are neither measured nor tracked.
+	release, err := h.admit(ctx, action)
 	if err != nil {
 		return err
 	}
+	defer release()
 
-	var (
-		pusher         storage.Pusher
-		refDestination name.Reference
+	finish := metrics.StartBuild(action.GetRuleMatch())
+	ctx, span := tracing.Start(ctx, "build",
+		attribute.String("jitdi.reference", ref),
+		attribute.String("jitdi.rule", action.GetRuleMatch()),
 	)
+	status := h.builds.start(ref, action.GetRuleMatch())
+	ctx = withBuildStatus(ctx, status)
+	report := h.reportProgress(action, status)
+	var p *Provenance
+	defer func() {
+		finish(retErr)
+		tracing.End(span, retErr)
+		h.builds.finish(status, retErr)
+		report(retErr)
+		event = newBuildEvent(ctx, action, status, p)
+	}()
 
-	destination := h.storageRegistry
-	if destination != "" {
-		refDestination, err = name.ParseReference(destination + "/" + action.GetMatchImage())
+	maxDuration, maxSize := h.buildLimits(action)
+	if maxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, maxDuration, fmt.Errorf("%w of %s", ErrBuildTimeout, maxDuration))
+		defer cancel()
+	}
+
+	s := h.storage
+	var staged *stagedStorage
+	if pinned != nil {
+		// The rebuild is committed only if it reproduces the pinned digest.
+		staged, err = newStagedStorage(h.storage)
 		if err != nil {
 			return err
 		}
+		defer staged.Close()
+		s = staged
+	}
+	if maxSize > 0 {
+		s = newLimitedStorage(s, maxSize)
+	}
 
-		pusher, err = h.getPusher(refDestination)
+	p, err = h.build(ctx, s, h.linkPath, repo, action, pinned)
+	if err != nil {
+		if context.Cause(ctx) != nil {
+			return context.Cause(ctx)
+		}
+		return err
+	}
+
+	if pinned != nil {
+		err = checkRebuild(pinned, p)
 		if err != nil {
 			return err
 		}
-	} else {
-		refDestination, err = name.ParseReference(action.GetMatchImage())
+		return staged.commit(ctx)
+	}
+
+	err = h.saveProvenance(p)
+	if err != nil {
+		slog.Warn("failed to save provenance", "ref", ref, "err", err)
+	}
+	return nil
+}
+
+// build builds the image of the action into the storage, the layers of the files are cached by the linkPath if not empty.
+func (h *Handler) build(ctx context.Context, s storage.Storage, linkPath string, repo string, action *pattern.Action, pinned *Provenance) (*Provenance, error) {
+	if h.offline {
+		err := h.checkOffline(ctx, action)
 		if err != nil {
-			return err
+			return nil, err
 		}
+	}
+
+	err := action.CheckBaseImage()
+	if err != nil {
+		return nil, err
+	}
+	err = action.CheckMutates(action.GetMutates(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	source := action.GetBaseImage()
+	if pinned != nil {
+		source = pinned.Base
+	}
+
+	refSource, err := name.ParseReference(source)
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := h.getBase(ctx, refSource)
+	if err != nil {
+		return nil, err
+	}
+
+	baseDigest, err := base.Digest()
+	if err != nil {
+		return nil, err
+	}
+
+	if status := buildStatusFrom(ctx); status != nil {
+		status.setBase(refSource.Context().Digest(baseDigest.String()).String())
+	}
 
-		pusher = storage.NewLocalPusher(h.blobPath, h.manifestPath)
+	err = h.verifyBase(ctx, action, refSource, baseDigest)
+	if err != nil {
+		return nil, err
 	}
 
+	refDestination, err := name.ParseReference(localRegistry + "/" + action.GetMatchImage())
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Provenance{
+		Repository: repo,
+		Tag:        refDestination.Identifier(),
+		Rule:       action.GetRuleMatch(),
+		Base:       refSource.Context().Digest(baseDigest.String()).String(),
+	}
+
+	pusher := storage.NewStoragePusher(s)
+	if h.signer != nil {
+		pusher = signer.NewPusher(pusher, h.signer)
+	}
+
+	// subject is the descriptor of the build, the attestation refers to it.
+	var subject *v1.Descriptor
+
 	// Fixed time, keep the result consistent
 	now := time.Time{}
 
-	roundTripper := httpseek.NewMustReaderTransport(http.DefaultTransport, func(request *http.Request, err error) error {
+	transport := &contextTransport{ctx: ctx, transport: h.getTransport()}
+	roundTripper := httpseek.NewMustReaderTransport(transport, func(request *http.Request, err error) error {
 		slog.Warn("httpseek", "err", err, "request", request)
 		return nil
 	})
 
-	linkPath := h.linkPath
-
-	if desc.MediaType.IsIndex() {
-		imageIndex, err := desc.ImageIndex()
+	switch base := base.(type) {
+	case v1.ImageIndex:
+		index, err := builder.NewImageIndex(base)
 		if err != nil {
-			return err
-		}
-
-		index, err := builder.NewImageIndex(imageIndex)
-		if err != nil {
-			return err
+			return nil, err
 		}
 
 		indexManifest, err := index.ImageIndex().IndexManifest()
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		ps := action.GetPlatforms()
@@ -450,58 +918,134 @@ func (h *Handler) buildAndSave(ctx context.Context, repo, tag string, action *pa
 				continue
 			}
 
-			if ps != nil {
-				i := slices.IndexFunc(ps, func(p v1alpha1.Platform) bool {
-					return platform.OS == p.OS && platform.Architecture == p.Architecture
-				})
-				if i < 0 {
-					continue
-				}
+			if !matchPlatform(ps, platform) {
+				continue
 			}
 
 			image, err := index.ImageIndex().Image(manifest.Digest)
 			if err != nil {
-				return err
+				return nil, err
 			}
 
-			newImage, err := mutateImage(image, action.GetMutates(manifest.Platform), linkPath, now, roundTripper)
+			mutates := action.GetMutates(manifest.Platform)
+			err = action.CheckMutates(mutates)
 			if err != nil {
-				return err
+				return nil, err
 			}
 
+			newImage, inputs, err := mutateImage(ctx, image, mutates, linkPath, lockedInputs(pinned, manifest.Platform), now, roundTripper, action.CheckRedirect)
+			if err != nil {
+				return nil, err
+			}
+			newImage = annotateImage(newImage, action, manifest.Digest, inputs, manifest.Platform)
+
 			err = pusher.PushImageWithIndex(ctx, refDestination.Context(), newImage)
 			if err != nil {
-				return err
+				return nil, err
+			}
+
+			inputs, err = lockInputs(newImage, inputs, manifest.Platform)
+			if err != nil {
+				return nil, err
 			}
+			p.Inputs = append(p.Inputs, inputs...)
 
 			err = index.AppendImage(newImage, platform)
 			if err != nil {
-				return err
+				return nil, err
 			}
+
+			digest, err := newImage.Digest()
+			if err != nil {
+				return nil, err
+			}
+			p.Manifests = append(p.Manifests, digest)
 		}
 
-		err = pusher.PushImageIndex(ctx, refDestination, index.ImageIndex())
+		annotations, err := buildAnnotations(action, baseDigest, p.Inputs)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		newIndex := mutate.Annotations(index.ImageIndex(), annotations).(v1.ImageIndex)
 
-	} else {
-		image, err := desc.Image()
+		p.Digest, err = newIndex.Digest()
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		image, err = mutateImage(image, action.GetMutates(desc.Platform), linkPath, now, roundTripper)
+		// The rebuilds are saved by the digest without the tag.
+		dest := refDestination
+		if pinned != nil {
+			dest = refDestination.Context().Digest(p.Digest.String())
+		}
+		err = pusher.PushImageIndex(ctx, dest, newIndex)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		err = pusher.PushImage(ctx, refDestination, image)
+		subject, err = partial.Descriptor(newIndex)
 		if err != nil {
-			return err
+			return nil, err
+		}
+
+	case v1.Image:
+		mutates := action.GetMutates(nil)
+		err = action.CheckMutates(mutates)
+		if err != nil {
+			return nil, err
+		}
+
+		image, inputs, err := mutateImage(ctx, base, mutates, linkPath, lockedInputs(pinned, nil), now, roundTripper, action.CheckRedirect)
+		if err != nil {
+			return nil, err
+		}
+		image = annotateImage(image, action, baseDigest, inputs, nil)
+
+		// The rebuilds are saved by the digest without the tag.
+		if pinned != nil {
+			err = pusher.PushImageWithIndex(ctx, refDestination.Context(), image)
+		} else {
+			err = pusher.PushImage(ctx, refDestination, image)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		p.Digest, err = image.Digest()
+		if err != nil {
+			return nil, err
+		}
+
+		p.Inputs, err = lockInputs(image, inputs, nil)
+		if err != nil {
+			return nil, err
 		}
 
+		subject, err = partial.Descriptor(image)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return nil
+	err = pushAttestation(ctx, s, action, p, *subject)
+	if err != nil {
+		return nil, fmt.Errorf("push attestation: %w", err)
+	}
+
+	err = pushSBOM(ctx, s, action, p, *subject)
+	if err != nil {
+		return nil, fmt.Errorf("push sbom: %w", err)
+	}
+
+	return p, nil
+}
+
+// matchPlatform reports whether the platform is one of ps, an empty ps matches all.
+func matchPlatform(ps []v1alpha1.Platform, platform *v1.Platform) bool {
+	if ps == nil {
+		return true
+	}
+	return slices.IndexFunc(ps, func(p v1alpha1.Platform) bool {
+		return platform.OS == p.OS && platform.Architecture == p.Architecture
+	}) >= 0
 }
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/wzshiming/jitdi/pkg/storage"
+)
+
+// readyTimeout is the timeout of the readiness checks.
+const readyTimeout = 5 * time.Second
+
+// readyCheck is a check of the readiness.
+type readyCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+func (h *Handler) readyChecks() []readyCheck {
+	var checks []readyCheck
+	if h.cachePath != "" {
+		checks = append(checks, readyCheck{name: "cache", check: h.checkCache})
+	}
+	if c, ok := h.storage.(storage.Checker); ok {
+		checks = append(checks, readyCheck{name: "storage", check: c.Check})
+	}
+	if h.clientset != nil {
+		checks = append(checks, readyCheck{name: "informer", check: h.checkInformer})
+	}
+	return checks
+}
+
+// checkCache returns an error if the cache directory is not writable.
+func (h *Handler) checkCache(ctx context.Context) error {
+	err := os.MkdirAll(h.cachePath, 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(h.cachePath, ".readyz-")
+	if err != nil {
+		return err
+	}
+	_ = f.Close()
+	return os.Remove(f.Name())
+}
+
+// checkInformer returns an error if the Image resources are not synced.
+func (h *Handler) checkInformer(ctx context.Context) error {
+	if h.imageSynced == nil || !h.imageSynced() {
+		return fmt.Errorf("images are not synced")
+	}
+	return nil
+}
+
+// serveProbe serves the liveness and the readiness.
+//
+//	GET /healthz
+//	GET /readyz
+func (h *Handler) serveProbe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		_ = regErrUnsupported.Write(w)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if r.URL.Path == "/healthz" {
+		_, _ = w.Write([]byte("ok\n"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	var buf strings.Builder
+	ready := true
+	for _, c := range h.readyChecks() {
+		err := c.check(ctx)
+		if err != nil {
+			ready = false
+			fmt.Fprintf(&buf, "[-]%s failed: %s\n", c.name, err)
+		} else {
+			fmt.Fprintf(&buf, "[+]%s ok\n", c.name)
+		}
+	}
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		buf.WriteString("readyz check failed\n")
+	} else {
+		buf.WriteString("readyz check passed\n")
+	}
+	_, _ = w.Write([]byte(buf.String()))
+}
@@ -0,0 +1,316 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"golang.org/x/time/rate"
+
+	"github.com/wzshiming/jitdi/pkg/pattern"
+	"github.com/wzshiming/jitdi/pkg/storage"
+	"github.com/wzshiming/jitdi/pkg/tracing"
+)
+
+var (
+	// ErrTooManyBuilds is returned when the concurrent builds reach the limit.
+	ErrTooManyBuilds = errors.New("too many concurrent builds")
+	// ErrRateLimited is returned when the client triggers builds faster than the rate limit.
+	ErrRateLimited = errors.New("build rate limit exceeded")
+	// ErrOutputTooLarge is returned when a build writes more than the maximum output size.
+	ErrOutputTooLarge = errors.New("build output exceeds the maximum size")
+	// ErrBuildTimeout is returned when a build takes longer than the maximum build duration.
+	ErrBuildTimeout = errors.New("build exceeds the maximum duration")
+)
+
+// maxClientLimiters is the number of the client rate limiters kept before the idle ones are pruned.
+const maxClientLimiters = 10000
+
+// WithMaxConcurrentBuilds limits the concurrent builds of all rules, 0 is unlimited.
+func WithMaxConcurrentBuilds(n int) option {
+	return func(h *Handler) {
+		h.maxConcurrentBuilds = n
+	}
+}
+
+// WithMaxBuildDuration limits the duration of each build, 0 is unlimited.
+func WithMaxBuildDuration(d time.Duration) option {
+	return func(h *Handler) {
+		h.maxBuildDuration = d
+	}
+}
+
+// WithMaxOutputSize limits the size of the blobs written by each build, 0 is unlimited.
+func WithMaxOutputSize(size int64) option {
+	return func(h *Handler) {
+		h.maxOutputSize = size
+	}
+}
+
+// WithClientRateLimit limits the builds triggered by each client to the rate per second with the burst, 0 is unlimited.
+func WithClientRateLimit(limit float64, burst int) option {
+	return func(h *Handler) {
+		h.clientRateLimit = rate.Limit(limit)
+		h.clientRateBurst = burst
+	}
+}
+
+// limits holds the state of the admission limits.
+type limits struct {
+	builds chan struct{}
+
+	ruleMut    sync.Mutex
+	ruleBuilds map[string]chan struct{}
+
+	clientMut      sync.Mutex
+	clientLimiters map[string]*rate.Limiter
+}
+
+// RateLimitedError is ErrRateLimited with the time until the client can trigger a build.
+type RateLimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrRateLimited, e.RetryAfter.Round(time.Second))
+}
+
+func (e *RateLimitedError) Unwrap() error {
+	return ErrRateLimited
+}
+
+type clientKey struct{}
+
+// withClient returns the context with the client triggering the builds.
+func withClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+// requestClient returns the client of the request, it is the remote IP.
+func requestClient(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// admit checks the limits before a build of the action, the release must be called after the build.
+func (h *Handler) admit(ctx context.Context, action *pattern.Action) (release func(), err error) {
+	if client, ok := ctx.Value(clientKey{}).(string); ok && h.clientRateLimit > 0 {
+		err = h.allowClient(client)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var slots []chan struct{}
+	release = func() {
+		for _, slot := range slots {
+			<-slot
+		}
+	}
+
+	if h.limits.builds != nil {
+		select {
+		case h.limits.builds <- struct{}{}:
+			slots = append(slots, h.limits.builds)
+		default:
+			return nil, ErrTooManyBuilds
+		}
+	}
+
+	if l := action.GetLimits(); l != nil && l.MaxConcurrentBuilds > 0 {
+		slot := h.ruleBuilds(action.GetRuleMatch(), l.MaxConcurrentBuilds)
+		select {
+		case slot <- struct{}{}:
+			slots = append(slots, slot)
+		default:
+			release()
+			return nil, fmt.Errorf("%w of rule %q", ErrTooManyBuilds, action.GetRuleMatch())
+		}
+	}
+
+	return release, nil
+}
+
+// ruleBuilds returns the semaphore of the rule, a new one replaces it when the limit of the rule changes.
+func (h *Handler) ruleBuilds(rule string, n int) chan struct{} {
+	h.limits.ruleMut.Lock()
+	defer h.limits.ruleMut.Unlock()
+
+	slot, ok := h.limits.ruleBuilds[rule]
+	if ok && cap(slot) == n {
+		return slot
+	}
+	if h.limits.ruleBuilds == nil {
+		h.limits.ruleBuilds = map[string]chan struct{}{}
+	}
+	slot = make(chan struct{}, n)
+	h.limits.ruleBuilds[rule] = slot
+	return slot
+}
+
+func (h *Handler) allowClient(client string) error {
+	h.limits.clientMut.Lock()
+	defer h.limits.clientMut.Unlock()
+
+	if h.limits.clientLimiters == nil {
+		h.limits.clientLimiters = map[string]*rate.Limiter{}
+	}
+
+	limiter, ok := h.limits.clientLimiters[client]
+	if !ok {
+		if len(h.limits.clientLimiters) >= maxClientLimiters {
+			// The limiters with all tokens back are the same as new ones.
+			for k, l := range h.limits.clientLimiters {
+				if l.Tokens() >= float64(h.clientRateBurst) {
+					delete(h.limits.clientLimiters, k)
+				}
+			}
+		}
+		limiter = rate.NewLimiter(h.clientRateLimit, h.clientRateBurst)
+		h.limits.clientLimiters[client] = limiter
+	}
+
+	r := limiter.Reserve()
+	if delay := r.Delay(); delay > 0 {
+		r.Cancel()
+		return &RateLimitedError{RetryAfter: delay}
+	}
+	return nil
+}
+
+// buildLimits returns the maximum duration and output size of a build of the action,
+// the smaller one of the handler and the rule, 0 is unlimited.
+func (h *Handler) buildLimits(action *pattern.Action) (time.Duration, int64) {
+	duration, size := h.maxBuildDuration, h.maxOutputSize
+	l := action.GetLimits()
+	if l == nil {
+		return duration, size
+	}
+	if l.MaxBuildDuration != nil && l.MaxBuildDuration.Duration > 0 &&
+		(duration == 0 || l.MaxBuildDuration.Duration < duration) {
+		duration = l.MaxBuildDuration.Duration
+	}
+	if l.MaxOutputSize != nil && l.MaxOutputSize.Value() > 0 &&
+		(size == 0 || l.MaxOutputSize.Value() < size) {
+		size = l.MaxOutputSize.Value()
+	}
+	return duration, size
+}
+
+// limitedStorage fails the writes when the blobs of the build exceed the maximum size.
+type limitedStorage struct {
+	storage.Storage
+	max int64
+
+	mut     sync.Mutex
+	size    int64
+	counted map[v1.Hash]struct{}
+}
+
+func newLimitedStorage(s storage.Storage, max int64) *limitedStorage {
+	return &limitedStorage{
+		Storage: s,
+		max:     max,
+		counted: map[v1.Hash]struct{}{},
+	}
+}
+
+func (s *limitedStorage) add(digest v1.Hash, size int64) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if _, ok := s.counted[digest]; ok {
+		return nil
+	}
+	s.counted[digest] = struct{}{}
+	s.size += size
+	if s.size > s.max {
+		return fmt.Errorf("%w of %d bytes", ErrOutputTooLarge, s.max)
+	}
+	return nil
+}
+
+// StatBlob counts the existing blobs, the pusher skips writing them.
+func (s *limitedStorage) StatBlob(ctx context.Context, repo string, digest v1.Hash) (*v1.Descriptor, error) {
+	desc, err := s.Storage.StatBlob(ctx, repo, digest)
+	if err != nil {
+		return nil, err
+	}
+	err = s.add(desc.Digest, desc.Size)
+	if err != nil {
+		return nil, err
+	}
+	return desc, nil
+}
+
+func (s *limitedStorage) PutBlob(ctx context.Context, repo string, r io.Reader) (*v1.Descriptor, error) {
+	s.mut.Lock()
+	remaining := s.max - s.size
+	s.mut.Unlock()
+
+	desc, err := s.Storage.PutBlob(ctx, repo, &limitedReader{r: r, remaining: remaining, max: s.max})
+	if err != nil {
+		return nil, err
+	}
+	err = s.add(desc.Digest, desc.Size)
+	if err != nil {
+		return nil, err
+	}
+	return desc, nil
+}
+
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+	max       int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, fmt.Errorf("%w of %d bytes", ErrOutputTooLarge, l.max)
+	}
+	return n, err
+}
+
+// contextTransport binds the requests to the context, so they are canceled with the build.
+type contextTransport struct {
+	ctx       context.Context
+	transport http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(tracing.WithParent(r.Context(), t.ctx))
+	stop := context.AfterFunc(t.ctx, cancel)
+	resp, err := t.transport.RoundTrip(r.WithContext(ctx))
+	if err != nil {
+		stop()
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: func() {
+		stop()
+		cancel()
+	}}
+	return resp, nil
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel func()
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
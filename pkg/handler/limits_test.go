@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wzshiming/jitdi/pkg/apis/v1alpha1"
+	"github.com/wzshiming/jitdi/pkg/pattern"
+)
+
+func TestAdmitRuleConcurrentBuilds(t *testing.T) {
+	const n = 8
+
+	// The first builds of the rule race only when they run in parallel.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	rule, err := pattern.NewRule(&v1alpha1.ImageSpec{
+		Match:     "k8s/{image}:{tag}",
+		BaseImage: "docker.io/library/alpine",
+		Limits:    &v1alpha1.Limits{MaxConcurrentBuilds: n},
+	})
+	if err != nil {
+		t.Fatalf("NewRule() error = %v", err)
+	}
+
+	for round := 0; round != 1000; round++ {
+		h := &Handler{}
+
+		var (
+			wg       sync.WaitGroup
+			start    = make(chan struct{})
+			mut      sync.Mutex
+			admitted int
+			denied   int
+		)
+		for i := 0; i != n+1; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				action, ok := rule.Match("k8s/kubectl:v1.29.3")
+				if !ok {
+					t.Error("Match() not matched")
+					return
+				}
+				<-start
+				// The builds are not released, so all of them are concurrent.
+				_, err := h.admit(context.Background(), action)
+
+				mut.Lock()
+				defer mut.Unlock()
+				switch {
+				case err == nil:
+					admitted++
+				case errors.Is(err, ErrTooManyBuilds):
+					denied++
+				default:
+					t.Errorf("admit() error = %v", err)
+				}
+			}()
+		}
+		close(start)
+		wg.Wait()
+
+		if admitted != n || denied != 1 {
+			t.Fatalf("admit() admitted %d and denied %d, want %d and 1", admitted, denied, n)
+		}
+	}
+}
+
+func TestBuildAndSaveRejected(t *testing.T) {
+	rule, err := pattern.NewRule(&v1alpha1.ImageSpec{
+		Match:     "k8s/{image}:{tag}",
+		BaseImage: "docker.io/library/alpine",
+		Limits:    &v1alpha1.Limits{MaxConcurrentBuilds: 1},
+	})
+	if err != nil {
+		t.Fatalf("NewRule() error = %v", err)
+	}
+	action, ok := rule.Match("k8s/kubectl:v1.29.3")
+	if !ok {
+		t.Fatal("Match() not matched")
+	}
+
+	h := &Handler{}
+	// Hold the only build of the rule.
+	release, err := h.admit(context.Background(), action)
+	if err != nil {
+		t.Fatalf("admit() error = %v", err)
+	}
+	defer release()
+
+	err = h.buildAndSave(context.Background(), "k8s/kubectl", "v1.29.3", action, nil)
+	if !errors.Is(err, ErrTooManyBuilds) {
+		t.Fatalf("buildAndSave() error = %v, want %v", err, ErrTooManyBuilds)
+	}
+
+	inFlight, recent := h.Builds()
+	if len(inFlight) != 0 || len(recent) != 0 {
+		t.Errorf("Builds() = %v, %v, want the rejected build not tracked", inFlight, recent)
+	}
+}
+
+func TestBuildAndSaveWaiter(t *testing.T) {
+	h := &Handler{}
+
+	// A build of the reference is in progress.
+	call := &buildCall{}
+	call.mut.Lock()
+	h.buildCalls.Store("k8s/kubectl:v1.29.3", call)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- h.buildAndSave(context.Background(), "k8s/kubectl", "v1.29.3", nil, nil)
+	}()
+
+	select {
+	case err := <-errCh:
+		t.Fatalf("buildAndSave() = %v before the build is done", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	call.err = ErrTooManyBuilds
+	h.buildCalls.Delete("k8s/kubectl:v1.29.3")
+	call.mut.Unlock()
+
+	err := <-errCh
+	if !errors.Is(err, ErrTooManyBuilds) {
+		t.Fatalf("buildAndSave() error = %v, want the error of the build %v", err, ErrTooManyBuilds)
+	}
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Route returns the route of the request, the template of its path, for the metrics.
+func Route(r *http.Request) string {
+	p := r.URL.Path
+	switch {
+	case p == "/v2/", p == "/jitdi/token", p == "/jitdi/cosign.pub", p == "/jitdi/explain", p == "/metrics",
+		p == "/healthz", p == "/readyz", p == "/debug/builds":
+		return p
+	case strings.HasPrefix(p, "/jitdi/export/"):
+		return "/jitdi/export/{reference}"
+	case strings.HasPrefix(p, "/jitdi/sbom/"):
+		return "/jitdi/sbom/{reference}"
+	}
+
+	_, typ, reference, ok := splitRegistryPath(p)
+	if !ok {
+		return "other"
+	}
+	switch typ {
+	case "uploads":
+		if reference == "" {
+			return "/v2/{name}/blobs/uploads/"
+		}
+		return "/v2/{name}/blobs/uploads/{uuid}"
+	case "blobs":
+		return "/v2/{name}/blobs/{digest}"
+	case "referrers":
+		return "/v2/{name}/referrers/{digest}"
+	}
+	return "/v2/{name}/manifests/{reference}"
+}
+
+// cacheUsageInterval is the interval the disk usage of the cache is walked at most.
+const cacheUsageInterval = time.Minute
+
+// cacheUsage returns the func returning the bytes of the files under the directory,
+// the result is cached for the cacheUsageInterval.
+func cacheUsage(dir string) func() float64 {
+	var (
+		mut     sync.Mutex
+		usage   float64
+		updated time.Time
+	)
+	return func() float64 {
+		mut.Lock()
+		defer mut.Unlock()
+		if time.Since(updated) < cacheUsageInterval {
+			return usage
+		}
+
+		var size int64
+		_ = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			info, err := d.Info()
+			if err == nil {
+				size += info.Size()
+			}
+			return nil
+		})
+		usage = float64(size)
+		updated = time.Now()
+		return usage
+	}
+}
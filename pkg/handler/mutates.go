@@ -1,107 +1,179 @@
 package handler
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"path"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/go-containerregistry/pkg/v1"
+	"go.opentelemetry.io/otel/attribute"
 
 	"github.com/wzshiming/jitdi/pkg/apis/v1alpha1"
 	"github.com/wzshiming/jitdi/pkg/atomic"
 	"github.com/wzshiming/jitdi/pkg/builder"
 	"github.com/wzshiming/jitdi/pkg/builder/files"
 	"github.com/wzshiming/jitdi/pkg/builder/ollama"
+	"github.com/wzshiming/jitdi/pkg/metrics"
+	"github.com/wzshiming/jitdi/pkg/tracing"
 )
 
-func mutateImage(image v1.Image, mutates []v1alpha1.Mutate, linkPath string, now time.Time, transport http.RoundTripper) (v1.Image, error) {
+// mutateImage returns the image with the mutates and the inputs of the appended layers in order.
+// The files are read when the image is pushed, their reading is traced in the context.
+// If the locked inputs by path is not nil, the inputs must be the locked ones, their layers are checked when they are read.
+func mutateImage(ctx context.Context, image v1.Image, mutates []v1alpha1.Mutate, linkPath string, locked map[string]Input, now time.Time, transport http.RoundTripper, checkRedirect func(u *url.URL) error) (v1.Image, []Input, error) {
 	var err error
-	for _, m := range mutates {
+	var inputs []Input
+	for i, m := range mutates {
+		var fs []*builder.File
+		var source string
+		_, span := tracing.Start(ctx, "mutateImage", attribute.Int("jitdi.mutate", i))
 		switch {
 		case m.File != nil:
-			image, err = mutateImageWithFile(image, m.File, linkPath, now, transport)
+			source = m.File.Source
+			span.SetAttributes(attribute.String("jitdi.source", source))
+			err = checkSource(locked, source)
+			if err == nil {
+				image, fs, err = mutateImageWithFile(ctx, image, m.File, linkPath, locked, now, transport, checkRedirect)
+			}
 		case m.Ollama != nil:
-			image, err = mutateImageWithOllama(image, m.Ollama, linkPath, now, transport)
+			source = m.Ollama.Model
+			span.SetAttributes(attribute.String("jitdi.model", source))
+			err = checkSource(locked, source)
+			if err == nil {
+				image, fs, err = mutateImageWithOllama(ctx, image, m.Ollama, linkPath, locked, now, transport)
+			}
 		default:
 			err = fmt.Errorf("unknown mutate")
 		}
+		tracing.End(span, err)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
+		for _, f := range fs {
+			inputs = append(inputs, Input{
+				Source: source,
+				Path:   f.Path,
+			})
+		}
+	}
+	err = checkRemoved(locked, inputs)
+	if err != nil {
+		return nil, nil, err
 	}
-	return image, nil
+	return image, inputs, nil
 }
 
-func mutateImageWithFile(image v1.Image, f *v1alpha1.File, linkPath string, now time.Time, transport http.RoundTripper) (v1.Image, error) {
+func mutateImageWithFile(ctx context.Context, image v1.Image, f *v1alpha1.File, linkPath string, locked map[string]Input, now time.Time, transport http.RoundTripper, checkRedirect func(u *url.URL) error) (v1.Image, []*builder.File, error) {
 	mode := int64(0644)
 	if f.Mode != "" {
 		m, err := strconv.ParseInt(f.Mode, 0, 0)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		mode = m
 	}
 
-	file := files.NewFiles(mode, now, transport)
+	file := files.NewFiles(mode, now, transport, checkRedirect)
 	fs, err := file.Build(f.Source, f.Destination)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	instrumentFiles(ctx, fileSourceType(f.Source), f.Source, fs)
 
 	img, err := builder.NewImage(image)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	for _, v := range fs {
-		if linkPath == "" {
+		switch {
+		case locked != nil:
+			err = appendLockedFile(img, v, f.Source, locked)
+		case linkPath == "":
 			err = img.AppendFileAsNewLayer(v)
-			if err != nil {
-				return nil, err
-			}
-		} else {
+		default:
 			err = img.AppendFileAsNewLayerWithLink(v, sumFileInfo(linkPath, v.Path, f))
-			if err != nil {
-				return nil, err
-			}
+		}
+		if err != nil {
+			return nil, nil, err
 		}
 	}
 
-	return img.Image(), nil
+	return img.Image(), fs, nil
 }
 
-func mutateImageWithOllama(image v1.Image, o *v1alpha1.Ollama, linkPath string, now time.Time, transport http.RoundTripper) (v1.Image, error) {
+func mutateImageWithOllama(ctx context.Context, image v1.Image, o *v1alpha1.Ollama, linkPath string, locked map[string]Input, now time.Time, transport http.RoundTripper) (v1.Image, []*builder.File, error) {
 	mode := int64(0644)
 
 	file := ollama.NewOllama(mode, now, transport)
 	fs, err := file.Build(o.Model, o.WorkDir, o.ModelName)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	instrumentFiles(ctx, metrics.SourceOllama, o.Model, fs)
 
 	img, err := builder.NewImage(image)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	for _, v := range fs {
-		if linkPath == "" {
+		switch {
+		case locked != nil:
+			err = appendLockedFile(img, v, o.Model, locked)
+		case linkPath == "":
 			err = img.AppendFileAsNewLayer(v)
-			if err != nil {
-				return nil, err
-			}
-		} else {
+		default:
 			err = img.AppendFileAsNewLayerWithLink(v, sumOllamaLayerInfo(linkPath, v.Path, o))
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return img.Image(), fs, nil
+}
+
+// fileSourceType returns the source type of the metrics of the file source.
+func fileSourceType(source string) string {
+	u, err := url.Parse(source)
+	if err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		return metrics.SourceHTTP
+	}
+	return metrics.SourceLocal
+}
+
+// instrumentFiles counts the bytes read from the files as fetched from the source type,
+// traces each reading of the files until it is closed, and records the progress into the build of the context.
+func instrumentFiles(ctx context.Context, sourceType, source string, fs []*builder.File) {
+	status := buildStatusFrom(ctx)
+	for _, f := range fs {
+		open := f.OpenReader
+		p := f.Path
+		f.OpenReader = func() (io.ReadCloser, int64, error) {
+			_, span := tracing.Start(ctx, "OpenReader",
+				attribute.String("jitdi.source_type", sourceType),
+				attribute.String("jitdi.path", p),
+			)
+			rc, size, err := open()
 			if err != nil {
-				return nil, err
+				tracing.End(span, err)
+				return nil, 0, err
+			}
+			span.SetAttributes(attribute.Int64("jitdi.size", size))
+			rc = metrics.CountReader(sourceType, rc)
+			if status != nil {
+				rc = &progressReader{ReadCloser: rc, progress: status.file(p, source, size)}
 			}
+			return tracing.Reader(span, rc), size, nil
 		}
 	}
-
-	return img.Image(), nil
 }
 
 func sumFileInfo(linkPath, mount string, f *v1alpha1.File) string {
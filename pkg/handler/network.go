@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/wzshiming/jitdi/pkg/pattern"
+)
+
+// blockedPrefixes are the link-local networks and the metadata endpoints of the clouds,
+// the sources of the builds never connect to them.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("fe80::/10"),
+	netip.MustParsePrefix("fd00:ec2::254/128"),
+	netip.MustParsePrefix("100.100.100.200/32"),
+	netip.MustParsePrefix("168.63.129.16/32"),
+}
+
+// dialControl rejects the connections to the blocked addresses,
+// it checks the resolved address so it cannot be bypassed by DNS.
+func dialControl(network, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	return checkAddr(ap.Addr())
+}
+
+// checkAddr returns an error if the address is blocked.
+func checkAddr(addr netip.Addr) error {
+	addr = addr.Unmap()
+	if slices.ContainsFunc(blockedPrefixes, func(p netip.Prefix) bool {
+		return p.Contains(addr)
+	}) {
+		return fmt.Errorf("%w: connecting to %s", pattern.ErrSourceNotAllowed, addr)
+	}
+	return nil
+}
+
+// proxyTransport checks the host of the requests through a proxy, as the dialer connects to the proxy instead of the host.
+// The addresses of the host are resolved and checked before the request,
+// the proxy resolves the host again, so it should block the metadata endpoints as well against DNS rebinding.
+type proxyTransport struct {
+	*http.Transport
+	lookup func(ctx context.Context, network, host string) ([]netip.Addr, error)
+}
+
+func (t *proxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.Proxy != nil {
+		proxy, err := t.Proxy(req)
+		if err != nil {
+			return nil, err
+		}
+		if proxy != nil {
+			err = t.checkHost(req.Context(), req.URL.Hostname())
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return t.Transport.RoundTrip(req)
+}
+
+func (t *proxyTransport) checkHost(ctx context.Context, host string) error {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return checkAddr(addr)
+	}
+	addrs, err := t.lookup(ctx, "ip", host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		err = checkAddr(addr)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newTransport returns the transport of the sources of the builds.
+func newTransport() http.RoundTripper {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   dialControl,
+	}).DialContext
+	return &proxyTransport{
+		Transport: t,
+		lookup:    net.DefaultResolver.LookupNetIP,
+	}
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"testing"
+
+	"github.com/wzshiming/jitdi/pkg/pattern"
+)
+
+func TestProxyTransport(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hosts := map[string][]netip.Addr{
+		"example.com":   {netip.MustParseAddr("93.184.216.34")},
+		"metadata.test": {netip.MustParseAddr("93.184.216.34"), netip.MustParseAddr("169.254.169.254")},
+	}
+
+	tests := []struct {
+		name    string
+		url     string
+		proxy   bool
+		wantErr error
+	}{
+		{
+			name:  "allowed host",
+			url:   "http://example.com/file",
+			proxy: true,
+		},
+		{
+			name:    "metadata address",
+			url:     "http://169.254.169.254/latest/meta-data/",
+			proxy:   true,
+			wantErr: pattern.ErrSourceNotAllowed,
+		},
+		{
+			name:    "host resolved to metadata address",
+			url:     "http://metadata.test/latest/meta-data/",
+			proxy:   true,
+			wantErr: pattern.ErrSourceNotAllowed,
+		},
+		{
+			name:    "without proxy",
+			url:     "http://169.254.169.254/latest/meta-data/",
+			wantErr: pattern.ErrSourceNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := newTransport().(*proxyTransport)
+			transport.Proxy = func(*http.Request) (*url.URL, error) {
+				if tt.proxy {
+					return proxyURL, nil
+				}
+				return nil, nil
+			}
+			transport.lookup = func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+				return hosts[host], nil
+			}
+
+			req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := transport.RoundTrip(req)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("RoundTrip() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RoundTrip() error = %v", err)
+			}
+			_ = resp.Body.Close()
+		})
+	}
+}
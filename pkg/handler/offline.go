@@ -0,0 +1,521 @@
+package handler
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+
+	"github.com/wzshiming/jitdi/pkg/builder/ollama"
+	"github.com/wzshiming/jitdi/pkg/pattern"
+	"github.com/wzshiming/jitdi/pkg/storage"
+)
+
+// The layout of the offline directory.
+//
+//	images/              OCI image layout of the base images, seeded by `jitdi import`
+//	files/<host>/<path>  mirror of the files of http(s) sources, keyed by URL
+//	ollama/              Ollama models directory, with manifests/ and blobs/
+const (
+	offlineImagesDir = "images"
+	offlineFilesDir  = "files"
+	offlineOllamaDir = "ollama"
+)
+
+// MissingError is returned in offline mode when dependencies of a build are not in the offline directory.
+type MissingError struct {
+	// Images are the base images missing from the imported images.
+	Images []string
+	// URLs are the sources missing from the file mirror or the Ollama models directory.
+	URLs []string
+	// Digests are the blobs missing from the Ollama models directory.
+	Digests []string
+}
+
+func (e *MissingError) Error() string {
+	var parts []string
+	if len(e.Images) != 0 {
+		parts = append(parts, "images: "+strings.Join(e.Images, ", "))
+	}
+	if len(e.URLs) != 0 {
+		parts = append(parts, "urls: "+strings.Join(e.URLs, ", "))
+	}
+	if len(e.Digests) != 0 {
+		parts = append(parts, "digests: "+strings.Join(e.Digests, ", "))
+	}
+	return "missing from the offline cache: " + strings.Join(parts, "; ")
+}
+
+func (e *MissingError) empty() bool {
+	return len(e.Images) == 0 && len(e.URLs) == 0 && len(e.Digests) == 0
+}
+
+func (e *MissingError) sort() {
+	for _, s := range [][]string{e.Images, e.URLs, e.Digests} {
+		sort.Strings(s)
+	}
+	e.Images = compactStrings(e.Images)
+	e.URLs = compactStrings(e.URLs)
+	e.Digests = compactStrings(e.Digests)
+}
+
+func compactStrings(s []string) []string {
+	out := s[:0]
+	for i, v := range s {
+		if i == 0 || v != s[i-1] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func (h *Handler) offlineImages() (storage.Storage, error) {
+	if h.offlinePath == "" {
+		return nil, fmt.Errorf("offline path is required without the cache directory")
+	}
+	return storage.NewOCILayoutStorage(path.Join(h.offlinePath, offlineImagesDir))
+}
+
+// offlineName is the name of the base image in the offline images.
+func offlineName(ref name.Reference) string {
+	return ref.Context().Name()
+}
+
+func (h *Handler) getOfflineBase(ctx context.Context, ref name.Reference) (partial.Describable, error) {
+	s, err := h.offlineImages()
+	if err != nil {
+		return nil, err
+	}
+	repo := offlineName(ref)
+	manifest, err := s.GetManifest(ctx, repo, ref.Identifier())
+	if err != nil {
+		return nil, err
+	}
+	if manifest.MediaType.IsIndex() {
+		return storage.ImageIndex(ctx, s, repo, manifest)
+	}
+	return storage.Image(ctx, s, repo, manifest)
+}
+
+// checkOffline lists all dependencies of the action missing from the offline directory.
+func (h *Handler) checkOffline(ctx context.Context, action *pattern.Action) error {
+	missing := &MissingError{}
+
+	source := action.GetBaseImage()
+	ref, err := name.ParseReference(source)
+	if err != nil {
+		return err
+	}
+
+	var platforms []*v1.Platform
+	base, err := h.getOfflineBase(ctx, ref)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		missing.Images = append(missing.Images, source)
+		for _, p := range action.GetPlatforms() {
+			platforms = append(platforms, &v1.Platform{OS: p.OS, Architecture: p.Architecture})
+		}
+	case err != nil:
+		return err
+	default:
+		if index, ok := base.(v1.ImageIndex); ok {
+			indexManifest, err := index.IndexManifest()
+			if err != nil {
+				return err
+			}
+			for _, manifest := range indexManifest.Manifests {
+				if manifest.Platform != nil && matchPlatform(action.GetPlatforms(), manifest.Platform) {
+					platforms = append(platforms, manifest.Platform)
+				}
+			}
+		}
+	}
+	if len(platforms) == 0 {
+		platforms = []*v1.Platform{nil}
+	}
+
+	for _, platform := range platforms {
+		for _, m := range action.GetMutates(platform) {
+			switch {
+			case m.File != nil:
+				h.checkOfflineFile(m.File.Source, missing)
+			case m.Ollama != nil:
+				err = h.checkOfflineOllama(m.Ollama.Model, missing)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if missing.empty() {
+		return nil
+	}
+	missing.sort()
+	return missing
+}
+
+func (h *Handler) checkOfflineFile(source string, missing *MissingError) {
+	p := source
+	u, err := url.Parse(source)
+	if err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		p = mirrorPath(path.Join(h.offlinePath, offlineFilesDir), u)
+	}
+	_, err = os.Stat(p)
+	if err != nil {
+		missing.URLs = append(missing.URLs, source)
+	}
+}
+
+func (h *Handler) checkOfflineOllama(model string, missing *MissingError) error {
+	ref, err := name.ParseReference(model)
+	if err != nil {
+		return fmt.Errorf("parsing reference %q: %w", model, err)
+	}
+
+	dir := path.Join(h.offlinePath, offlineOllamaDir)
+	data, err := os.ReadFile(ollama.ManifestPath(dir, ref))
+	if err != nil {
+		missing.URLs = append(missing.URLs, fmt.Sprintf("https://%s/v2/%s/manifests/%s", ref.Context().RegistryStr(), ref.Context().RepositoryStr(), ref.Identifier()))
+		return nil
+	}
+
+	manifest, err := v1.ParseManifest(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("parsing manifest of %q: %w", model, err)
+	}
+	for _, desc := range append([]v1.Descriptor{manifest.Config}, manifest.Layers...) {
+		_, err = os.Stat(ollama.BlobPath(dir, desc.Digest))
+		if err != nil {
+			missing.Digests = append(missing.Digests, desc.Digest.String())
+		}
+	}
+	return nil
+}
+
+// mirrorPath returns the path of the file of the URL in the mirror directory.
+func mirrorPath(dir string, u *url.URL) string {
+	return path.Join(dir, u.Host, path.Clean("/"+u.Path))
+}
+
+// offlineTransport serves requests from the offline directory, it never touches the network.
+type offlineTransport struct {
+	filesPath  string
+	ollamaPath string
+}
+
+func (t *offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return offlineResponse(req, http.StatusMethodNotAllowed, nil, 0, nil), nil
+	}
+
+	p := mirrorPath(t.filesPath, req.URL)
+	if info, err := os.Stat(p); err == nil && info.Mode().IsRegular() {
+		return serveOfflineFile(req, p, "")
+	}
+
+	if strings.HasPrefix(req.URL.Path, "/v2/") {
+		return t.serveOllama(req)
+	}
+	return offlineResponse(req, http.StatusNotFound, nil, 0, nil), nil
+}
+
+func (t *offlineTransport) serveOllama(req *http.Request) (*http.Response, error) {
+	if req.URL.Path == "/v2/" {
+		return offlineResponse(req, http.StatusOK, nil, 2, io.NopCloser(strings.NewReader("{}"))), nil
+	}
+
+	parts := strings.Split(req.URL.Path, "/")
+	if len(parts) < 5 {
+		return offlineResponse(req, http.StatusNotFound, nil, 0, nil), nil
+	}
+	repo := strings.Join(parts[2:len(parts)-2], "/")
+	id := parts[len(parts)-1]
+
+	switch parts[len(parts)-2] {
+	case "manifests":
+		ref, err := name.ParseReference(req.URL.Host + "/" + repo + ":" + id)
+		if err != nil {
+			return offlineResponse(req, http.StatusNotFound, nil, 0, nil), nil
+		}
+		p := ollama.ManifestPath(t.ollamaPath, ref)
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return offlineResponse(req, http.StatusNotFound, nil, 0, nil), nil
+		}
+		var manifest struct {
+			MediaType string `json:"mediaType"`
+		}
+		_ = json.Unmarshal(data, &manifest)
+		return serveOfflineFile(req, p, manifest.MediaType)
+	case "blobs":
+		digest, err := v1.NewHash(id)
+		if err != nil {
+			return offlineResponse(req, http.StatusNotFound, nil, 0, nil), nil
+		}
+		return serveOfflineFile(req, ollama.BlobPath(t.ollamaPath, digest), "application/octet-stream")
+	}
+	return offlineResponse(req, http.StatusNotFound, nil, 0, nil), nil
+}
+
+// serveOfflineFile serves the file, with the open ended range request of httpseek.
+func serveOfflineFile(req *http.Request, p string, contentType string) (*http.Response, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return offlineResponse(req, http.StatusNotFound, nil, 0, nil), nil
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	size := info.Size()
+
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	if strings.HasSuffix(contentType, "+json") || strings.HasSuffix(contentType, ".json") {
+		hash := sha256.New()
+		_, err = io.Copy(hash, f)
+		if err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		header.Set("Docker-Content-Digest", "sha256:"+hex.EncodeToString(hash.Sum(nil)))
+	}
+
+	status := http.StatusOK
+	var offset int64
+	if r := req.Header.Get("Range"); r != "" {
+		start, ok := strings.CutPrefix(r, "bytes=")
+		start, ok2 := strings.CutSuffix(start, "-")
+		offset, err = strconv.ParseInt(start, 10, 64)
+		if !ok || !ok2 || err != nil || offset < 0 || offset >= size {
+			_ = f.Close()
+			return offlineResponse(req, http.StatusRequestedRangeNotSatisfiable, nil, 0, nil), nil
+		}
+		status = http.StatusPartialContent
+		header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, size-1, size))
+	}
+
+	if req.Method == http.MethodHead {
+		_ = f.Close()
+		return offlineResponse(req, status, header, size-offset, nil), nil
+	}
+
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return offlineResponse(req, status, header, size-offset, f), nil
+}
+
+func offlineResponse(req *http.Request, status int, header http.Header, size int64, body io.ReadCloser) *http.Response {
+	if header == nil {
+		header = http.Header{}
+	}
+	if body == nil {
+		body = http.NoBody
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          body,
+		ContentLength: size,
+		Request:       req,
+	}
+}
+
+// Import seeds the offline images with the images in the docker or OCI tarball,
+// it returns the names of the imported images.
+func (h *Handler) Import(ctx context.Context, file string) ([]string, error) {
+	s, err := h.offlineImages()
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := tarEntries(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := names["manifest.json"]; ok {
+		return importDockerArchive(ctx, s, file)
+	}
+	if _, ok := names["oci-layout"]; ok {
+		return importOCIArchive(ctx, s, file)
+	}
+	return nil, fmt.Errorf("%s is neither a docker nor an OCI tarball", file)
+}
+
+func tarEntries(file string) (map[string]struct{}, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	names := map[string]struct{}{}
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return names, nil
+			}
+			return nil, fmt.Errorf("reading %s: %w", file, err)
+		}
+		names[path.Clean(header.Name)] = struct{}{}
+	}
+}
+
+func importDockerArchive(ctx context.Context, s storage.Storage, file string) ([]string, error) {
+	opener := func() (io.ReadCloser, error) {
+		return os.Open(file)
+	}
+	manifest, err := tarball.LoadManifest(opener)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, m := range manifest {
+		for _, repoTag := range m.RepoTags {
+			tag, err := name.NewTag(repoTag)
+			if err != nil {
+				return nil, err
+			}
+			image, err := tarball.Image(opener, &tag)
+			if err != nil {
+				return nil, err
+			}
+			err = storage.Write(ctx, s, offlineName(tag), tag.Identifier(), image)
+			if err != nil {
+				return nil, fmt.Errorf("importing %s: %w", repoTag, err)
+			}
+			names = append(names, tag.Name())
+		}
+	}
+	return names, nil
+}
+
+func importOCIArchive(ctx context.Context, s storage.Storage, file string) ([]string, error) {
+	dir, err := os.MkdirTemp("", "jitdi-import-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	err = untar(file, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := layout.ImageIndexFromPath(dir)
+	if err != nil {
+		return nil, err
+	}
+	indexManifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, desc := range indexManifest.Manifests {
+		refName := desc.Annotations[storage.AnnotationContainerdImageName]
+		if refName == "" {
+			refName = desc.Annotations[storage.AnnotationRefName]
+		}
+		if refName == "" {
+			continue
+		}
+		ref, err := name.ParseReference(refName)
+		if err != nil {
+			return nil, err
+		}
+
+		var t partial.Describable
+		if desc.MediaType.IsIndex() {
+			t, err = index.ImageIndex(desc.Digest)
+		} else {
+			t, err = index.Image(desc.Digest)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		err = storage.Write(ctx, s, offlineName(ref), ref.Identifier(), t)
+		if err != nil {
+			return nil, fmt.Errorf("importing %s: %w", refName, err)
+		}
+		names = append(names, ref.Name())
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no named image in %s", file)
+	}
+	return names, nil
+}
+
+func untar(file, dir string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("reading %s: %w", file, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		p := filepath.Join(dir, filepath.FromSlash(path.Clean("/"+header.Name)))
+		err = os.MkdirAll(filepath.Dir(p), 0755)
+		if err != nil {
+			return err
+		}
+		out, err := os.Create(p)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, tr)
+		_ = out.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
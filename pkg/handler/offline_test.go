@@ -0,0 +1,277 @@
+package handler
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+
+	"github.com/wzshiming/jitdi/pkg/apis/v1alpha1"
+	"github.com/wzshiming/jitdi/pkg/storage"
+)
+
+const (
+	testOfflineBase   = "registry.example.com/library/alpine:3.19"
+	testOfflineSource = "https://dl.example.com/bin/kubectl"
+)
+
+func TestOffline(t *testing.T) {
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		// tarball writes the tarball of the image into the directory.
+		tarball     func(t *testing.T, dir string, img v1.Image) string
+		mirror      bool
+		wantMissing *MissingError
+	}{
+		{
+			name:    "docker tarball",
+			tarball: writeDockerTarball,
+			mirror:  true,
+		},
+		{
+			name:    "oci tarball",
+			tarball: writeOCITarball,
+			mirror:  true,
+		},
+		{
+			name:        "missing base",
+			mirror:      true,
+			wantMissing: &MissingError{Images: []string{testOfflineBase}},
+		},
+		{
+			name:        "missing source",
+			tarball:     writeDockerTarball,
+			wantMissing: &MissingError{URLs: []string{testOfflineSource}},
+		},
+		{
+			name:        "missing all",
+			wantMissing: &MissingError{Images: []string{testOfflineBase}, URLs: []string{testOfflineSource}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+			offline := filepath.Join(dir, "offline")
+
+			h, err := NewHandler(
+				WithCache(filepath.Join(dir, "cache")),
+				WithOffline(true),
+				WithOfflinePath(offline),
+				WithImageConfig([]*v1alpha1.Image{
+					{
+						Spec: v1alpha1.ImageSpec{
+							Match:     "k8s/{name}:{tag}",
+							BaseImage: testOfflineBase,
+							Mutates: []v1alpha1.Mutate{
+								{File: &v1alpha1.File{Source: "https://dl.example.com/bin/{name}", Destination: "/usr/local/bin/{name}", Mode: "0755"}},
+							},
+						},
+					},
+				}),
+			)
+			if err != nil {
+				t.Fatalf("NewHandler() error = %v", err)
+			}
+
+			if tt.tarball != nil {
+				names, err := h.Import(ctx, tt.tarball(t, dir, img))
+				if err != nil {
+					t.Fatalf("Import() error = %v", err)
+				}
+				if want := []string{testOfflineBase}; !reflect.DeepEqual(names, want) {
+					t.Errorf("Import() = %v, want %v", names, want)
+				}
+			}
+			if tt.mirror {
+				p := filepath.Join(offline, offlineFilesDir, "dl.example.com", "bin", "kubectl")
+				err = os.MkdirAll(filepath.Dir(p), 0755)
+				if err != nil {
+					t.Fatal(err)
+				}
+				writeTestFile(t, p, "kubectl v1.29.3")
+			}
+
+			action, ok := h.match(ctx, "k8s/kubectl", "v1.29.3")
+			if !ok {
+				t.Fatal("match() not matched")
+			}
+			_, err = h.getOrBuildManifest(ctx, "k8s/kubectl", "v1.29.3", action)
+			if tt.wantMissing != nil {
+				var missing *MissingError
+				if !errors.As(err, &missing) {
+					t.Fatalf("build error = %v, want a MissingError", err)
+				}
+				if !reflect.DeepEqual(missing, tt.wantMissing) {
+					t.Errorf("build error = %#v, want %#v", missing, tt.wantMissing)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("build error = %v", err)
+			}
+
+			m, err := h.storage.GetManifest(ctx, "k8s/kubectl", "v1.29.3")
+			if err != nil {
+				t.Fatalf("GetManifest() error = %v", err)
+			}
+			built, err := v1.ParseManifest(bytes.NewReader(m.Data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			layers, err := img.Layers()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := len(built.Layers), len(layers)+1; got != want {
+				t.Errorf("built %d layers, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestOfflinePath(t *testing.T) {
+	cache := t.TempDir()
+	offline := t.TempDir()
+	provenance := t.TempDir()
+
+	tests := []struct {
+		name    string
+		opts    []option
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "cache",
+			opts: []option{WithCache(cache), WithOffline(true)},
+			want: filepath.Join(cache, "offline"),
+		},
+		{
+			name: "offline path",
+			opts: []option{WithCache(cache), WithOffline(true), WithOfflinePath(offline)},
+			want: offline,
+		},
+		{
+			name: "offline path without the cache",
+			opts: []option{WithStorage("file://" + cache), WithProvenancePath(provenance), WithOffline(true), WithOfflinePath(offline)},
+			want: offline,
+		},
+		{
+			name:    "without the cache",
+			opts:    []option{WithStorage("file://" + cache), WithProvenancePath(provenance), WithOffline(true)},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := NewHandler(tt.opts...)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("NewHandler() offline path = %q, want an error", h.offlinePath)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewHandler() error = %v", err)
+			}
+			if h.offlinePath != tt.want {
+				t.Errorf("NewHandler() offline path = %q, want %q", h.offlinePath, tt.want)
+			}
+		})
+	}
+}
+
+func writeDockerTarball(t *testing.T, dir string, img v1.Image) string {
+	t.Helper()
+	tag, err := name.NewTag(testOfflineBase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "docker.tar")
+	err = tarball.WriteToFile(file, tag, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func writeOCITarball(t *testing.T, dir string, img v1.Image) string {
+	t.Helper()
+	layoutDir := filepath.Join(dir, "layout")
+	p, err := layout.Write(layoutDir, empty.Index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.AppendImage(img, layout.WithAnnotations(map[string]string{
+		storage.AnnotationRefName: testOfflineBase,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(dir, "oci.tar")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	err = filepath.Walk(layoutDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(layoutDir, p)
+		if err != nil {
+			return err
+		}
+		err = tw.WriteHeader(&tar.Header{
+			Name:     filepath.ToSlash(rel),
+			Mode:     0644,
+			Size:     info.Size(),
+			Typeflag: tar.TypeReg,
+		})
+		if err != nil {
+			return err
+		}
+		src, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func writeTestFile(t *testing.T, name, content string) {
+	t.Helper()
+	err := os.WriteFile(name, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
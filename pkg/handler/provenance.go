@@ -0,0 +1,254 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+
+	"github.com/wzshiming/jitdi/pkg/atomic"
+	"github.com/wzshiming/jitdi/pkg/builder"
+	"github.com/wzshiming/jitdi/pkg/pattern"
+	"github.com/wzshiming/jitdi/pkg/storage"
+)
+
+// ErrNotReproducible is returned when a rebuild does not produce the recorded digest.
+var ErrNotReproducible = errors.New("build is not reproducible")
+
+// Provenance is how a manifest was built,
+// it is recorded for the digests of the build to rebuild them when they are missing from the storage.
+type Provenance struct {
+	// Repository and Tag is the reference pulled.
+	Repository string `json:"repository"`
+	Tag        string `json:"tag"`
+	// Rule is the match of the rule.
+	Rule string `json:"rule"`
+	// Base is the base image pinned by digest.
+	Base string `json:"base"`
+	// Inputs is the files added to the images.
+	Inputs []Input `json:"inputs,omitempty"`
+	// Digest is the digest of the built image or image index.
+	Digest v1.Hash `json:"digest"`
+	// Manifests is the digests of the images of the built image index.
+	Manifests []v1.Hash `json:"manifests,omitempty"`
+}
+
+// Input is a file added to an image, locked by the digest of its layer.
+type Input struct {
+	// Platform is the platform of the image in the image index.
+	Platform string `json:"platform,omitempty"`
+	// Source is the source of the mutate.
+	Source string `json:"source"`
+	// Path is the path of the file in the image.
+	Path string `json:"path"`
+	// DiffID is the sha256 of the uncompressed layer of the file.
+	DiffID v1.Hash `json:"diffID"`
+}
+
+// key returns the platform and the path of the input.
+func (i Input) key() string {
+	if i.Platform == "" {
+		return i.Path
+	}
+	return i.Platform + ":" + i.Path
+}
+
+// WithProvenancePath sets the directory of the provenance records, defaults to the "provenance" in the cache directory,
+// it is required without the cache directory.
+// It should outlive the storage, so the pinned digests can be rebuilt after the storage is wiped.
+func WithProvenancePath(provenancePath string) option {
+	return func(h *Handler) {
+		h.provenancePath = provenancePath
+	}
+}
+
+func (h *Handler) provenanceFile(digest v1.Hash) string {
+	return path.Join(h.provenancePath, digest.Algorithm+"-"+digest.Hex+".json")
+}
+
+// saveProvenance records the provenance for the digest of the build and the digests of its images.
+func (h *Handler) saveProvenance(p *Provenance) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	for _, digest := range append([]v1.Hash{p.Digest}, p.Manifests...) {
+		err = atomic.WriteFile(h.provenanceFile(digest), data, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetProvenance returns the provenance of the digest.
+func (h *Handler) GetProvenance(digest v1.Hash) (*Provenance, error) {
+	data, err := os.ReadFile(h.provenanceFile(digest))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+	p := &Provenance{}
+	err = json.Unmarshal(data, p)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// getOrRebuildManifest returns the manifest of the digest from the storage,
+// rebuilding it from the provenance if it does not exist.
+func (h *Handler) getOrRebuildManifest(ctx context.Context, image, reference string) (*storage.Manifest, error) {
+	digest, err := v1.NewHash(reference)
+	if err != nil {
+		return nil, storage.ErrNotFound
+	}
+
+	if h.verifyOnServe {
+		err = h.verifyBlob(ctx, digest)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) && !errors.Is(err, storage.ErrBlobCorrupted) {
+			return nil, err
+		}
+	}
+
+	manifest, err := h.storage.GetManifest(ctx, image, reference)
+	if err == nil {
+		return manifest, nil
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+
+	p, err := h.GetProvenance(digest)
+	if err != nil {
+		return nil, err
+	}
+	if p.Repository != image {
+		return nil, storage.ErrNotFound
+	}
+
+	action, ok := h.matchRule(p.Rule, p.Repository, p.Tag)
+	if !ok {
+		return nil, fmt.Errorf("rule %q of %s to rebuild %s: %w", p.Rule, p.Repository+":"+p.Tag, digest, storage.ErrNotFound)
+	}
+
+	slog.Info("Rebuilding", "image", image, "digest", digest, "tag", p.Tag, "base", p.Base)
+	err = h.buildAndSave(context.WithoutCancel(ctx), image, digest.String(), action, p)
+	if err != nil {
+		return nil, err
+	}
+	return h.storage.GetManifest(ctx, image, reference)
+}
+
+// matchRule returns the action of the rule with the match, instead of the most specific rule.
+func (h *Handler) matchRule(rule, image, tag string) (*pattern.Action, bool) {
+	for _, r := range h.getImageRules() {
+		action, ok := r.Match(image + ":" + tag)
+		if ok && action.GetRuleMatch() == rule {
+			return action, true
+		}
+	}
+	return nil, false
+}
+
+// checkRebuild returns an error if the rebuild does not produce the digest of the provenance.
+func checkRebuild(pinned, built *Provenance) error {
+	if built.Digest == pinned.Digest {
+		return nil
+	}
+	return fmt.Errorf("%w: rebuilt %s as %s", ErrNotReproducible, pinned.Digest, built.Digest)
+}
+
+// lockInputs sets the diffIDs of the layers of the inputs appended to the image,
+// it is called after the image is pushed and the layers are computed.
+func lockInputs(image v1.Image, inputs []Input, platform *v1.Platform) ([]Input, error) {
+	configFile, err := image.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	diffIDs := configFile.RootFS.DiffIDs
+	offset := len(diffIDs) - len(inputs)
+	if offset < 0 {
+		return nil, fmt.Errorf("image has %d layers, less than %d inputs", len(diffIDs), len(inputs))
+	}
+	for i := range inputs {
+		if platform != nil {
+			inputs[i].Platform = platform.String()
+		}
+		inputs[i].DiffID = diffIDs[offset+i]
+	}
+	return inputs, nil
+}
+
+// lockedInputs returns the inputs of the provenance of the platform by path, nil if the build is not pinned.
+func lockedInputs(pinned *Provenance, platform *v1.Platform) map[string]Input {
+	if pinned == nil {
+		return nil
+	}
+	p := ""
+	if platform != nil {
+		p = platform.String()
+	}
+	locked := map[string]Input{}
+	for _, i := range pinned.Inputs {
+		if i.Platform == p {
+			locked[i.Path] = i
+		}
+	}
+	return locked
+}
+
+// checkSource returns an error if the source is not of the locked inputs, before the files of the source are fetched.
+func checkSource(locked map[string]Input, source string) error {
+	if locked == nil {
+		return nil
+	}
+	for _, i := range locked {
+		if i.Source == source {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: source %s is not of the inputs", ErrNotReproducible, source)
+}
+
+// appendLockedFile appends the file as a new layer checked against the locked input of its path when it is read,
+// the reading fails at the end of the layer if its diffID is not the locked one.
+func appendLockedFile(img *builder.Image, f *builder.File, source string, locked map[string]Input) error {
+	input, ok := locked[f.Path]
+	if !ok {
+		return fmt.Errorf("%w: input %s added from %s", ErrNotReproducible, f.Path, source)
+	}
+	if input.Source != source {
+		return fmt.Errorf("%w: input %s from %s, locked from %s", ErrNotReproducible, input.key(), source, input.Source)
+	}
+	return img.AppendFileAsNewLayerWithCheck(f, func(diffID v1.Hash) error {
+		if diffID != input.DiffID {
+			return fmt.Errorf("%w: input %s from %s changed %s to %s", ErrNotReproducible, input.key(), input.Source, input.DiffID, diffID)
+		}
+		return nil
+	})
+}
+
+// checkRemoved returns an error if any of the locked inputs is not in the inputs.
+func checkRemoved(locked map[string]Input, inputs []Input) error {
+	if len(locked) == len(inputs) {
+		return nil
+	}
+	paths := map[string]bool{}
+	for _, i := range inputs {
+		paths[i.Path] = true
+	}
+	for _, i := range locked {
+		if !paths[i.Path] {
+			return fmt.Errorf("%w: input %s removed from %s", ErrNotReproducible, i.key(), i.Source)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"github.com/wzshiming/jitdi/pkg/apis/v1alpha1"
+	"github.com/wzshiming/jitdi/pkg/storage"
+)
+
+func TestGetOrRebuildManifest(t *testing.T) {
+	ctx := context.Background()
+	base := pushTestBase(t)
+
+	tests := []struct {
+		name string
+		// update changes the handler or the sources after the image is built.
+		update  func(t *testing.T, h *Handler, src string)
+		wantErr error
+	}{
+		{
+			name:   "hit",
+			update: func(t *testing.T, h *Handler, src string) {},
+		},
+		{
+			name: "rebuild",
+			update: func(t *testing.T, h *Handler, src string) {
+				deleteTestManifest(t, h)
+			},
+		},
+		{
+			name: "mismatch",
+			update: func(t *testing.T, h *Handler, src string) {
+				deleteTestManifest(t, h)
+				// The file changed upstream and its layer is not linked, so the rebuild has another digest.
+				writeTestFile(t, filepath.Join(src, "kubectl"), "kubectl v1.30.0")
+				h.linkPath = ""
+			},
+			wantErr: ErrNotReproducible,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := t.TempDir()
+			writeTestFile(t, filepath.Join(src, "kubectl"), "kubectl v1.29.3")
+
+			cache := t.TempDir()
+			h := newTestHandler(t, cache, base, src)
+			pullTestImage(t, h, "test/kubectl", "v1.29.3")
+
+			m, err := h.storage.GetManifest(ctx, "test/kubectl", "v1.29.3")
+			if err != nil {
+				t.Fatalf("GetManifest() error = %v", err)
+			}
+			digest := m.Digest()
+
+			tt.update(t, h, src)
+			before := countTestFiles(t, cache)
+
+			got, err := h.getOrRebuildManifest(ctx, "test/kubectl", digest.String())
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("getOrRebuildManifest() error = %v, want %v", err, tt.wantErr)
+				}
+				if after := countTestFiles(t, cache); after != before {
+					t.Errorf("getOrRebuildManifest() wrote %d files to the storage, want none", after-before)
+				}
+				_, err = h.storage.GetManifest(ctx, "test/kubectl", digest.String())
+				if !errors.Is(err, storage.ErrNotFound) {
+					t.Errorf("GetManifest() error = %v, want %v", err, storage.ErrNotFound)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getOrRebuildManifest() error = %v", err)
+			}
+			if got.Digest() != digest {
+				t.Errorf("getOrRebuildManifest() digest = %s, want %s", got.Digest(), digest)
+			}
+			referrers, err := storage.Referrers(ctx, h.storage, "test/kubectl", digest)
+			if err != nil {
+				t.Fatalf("Referrers() error = %v", err)
+			}
+			if len(referrers.Manifests) == 0 {
+				t.Errorf("Referrers() is empty, want the attestations")
+			}
+		})
+	}
+}
+
+func TestProvenancePath(t *testing.T) {
+	cache := t.TempDir()
+	provenance := t.TempDir()
+
+	tests := []struct {
+		name    string
+		opts    []option
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "cache",
+			opts: []option{WithCache(cache)},
+			want: filepath.Join(cache, "provenance"),
+		},
+		{
+			name: "provenance path",
+			opts: []option{WithCache(cache), WithProvenancePath(provenance)},
+			want: provenance,
+		},
+		{
+			name: "provenance path without the cache",
+			opts: []option{WithStorage("file://" + cache), WithProvenancePath(provenance)},
+			want: provenance,
+		},
+		{
+			name:    "without the cache",
+			opts:    []option{WithStorage("file://" + cache)},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := NewHandler(tt.opts...)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("NewHandler() provenance path = %q, want an error", h.provenancePath)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewHandler() error = %v", err)
+			}
+			if h.provenancePath != tt.want {
+				t.Errorf("NewHandler() provenance path = %q, want %q", h.provenancePath, tt.want)
+			}
+		})
+	}
+}
+
+// deleteTestManifest deletes the manifest of the built image from the storage, keeping its provenance.
+func deleteTestManifest(t *testing.T, h *Handler) {
+	t.Helper()
+	ctx := context.Background()
+	m, err := h.storage.GetManifest(ctx, "test/kubectl", "v1.29.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = h.storage.Delete(ctx, "test/kubectl", m.Digest().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// countTestFiles returns the number of the files in the directory.
+func countTestFiles(t *testing.T, dir string) int {
+	t.Helper()
+	n := 0
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// pushTestBase returns the reference of a random image pushed to an in-process registry.
+func pushTestBase(t *testing.T) string {
+	t.Helper()
+	reg := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(reg.Close)
+
+	u, err := url.Parse(reg.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := u.Host + "/base:latest"
+	err = crane.Push(img, base, crane.WithTransport(remote.DefaultTransport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base
+}
+
+// newTestHandler returns the handler of the cache with a rule adding the files of the src to the base.
+func newTestHandler(t *testing.T, cache, base, src string, opts ...option) *Handler {
+	t.Helper()
+	rules := []*v1alpha1.Image{
+		{
+			Spec: v1alpha1.ImageSpec{
+				Match:     "test/{name}:{tag}",
+				BaseImage: base,
+				Mutates: []v1alpha1.Mutate{
+					{File: &v1alpha1.File{Source: filepath.Join(src, "{name}"), Destination: "/usr/local/bin/{name}", Mode: "0755"}},
+				},
+			},
+		},
+	}
+	h, err := NewHandler(append([]option{WithCache(cache), WithImageConfig(rules)}, opts...)...)
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+	return h
+}
+
+// pullTestImage builds the image by pulling its manifest.
+func pullTestImage(t *testing.T, h *Handler, image, tag string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/"+image+"/manifests/"+tag, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s:%s = %d, %s", image, tag, rec.Code, rec.Body)
+	}
+}
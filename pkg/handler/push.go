@@ -0,0 +1,253 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+
+	"github.com/wzshiming/jitdi/pkg/storage"
+)
+
+// maxManifestSize is the maximum size of the pushed manifests.
+const maxManifestSize = 4 << 20
+
+// artifactTag matches the tags of the artifacts written by jitdi, the referrers tag schema and the cosign tags, e.g. sha256-<hex>.sig,
+// they are served from the storage without building.
+var artifactTag = regexp.MustCompile(`^sha256-[a-f0-9]{64}(\.[a-z]+)?$`)
+
+var uploadID = regexp.MustCompile(`^[a-f0-9]{32}$`)
+
+// WithArtifactPush allows pushing the artifacts referring to the manifests, such as signatures, SBOMs and attestations.
+// The images can not be pushed, they are only built by the rules.
+func WithArtifactPush(artifactPush bool) option {
+	return func(h *Handler) {
+		h.artifactPush = artifactPush
+	}
+}
+
+func isArtifactTag(tag string) bool {
+	return artifactTag.MatchString(tag)
+}
+
+func (h *Handler) uploadFile(id string) string {
+	return path.Join(h.cachePath, "uploads", id)
+}
+
+// startUpload starts a blob upload, or finishes it with the body if the digest is given.
+//
+//	POST /v2/<name>/blobs/uploads/?digest=<digest>
+func (h *Handler) startUpload(w http.ResponseWriter, r *http.Request, image string) {
+	query := r.URL.Query()
+	// The blob is mounted only if the request can pull the repository it is mounted from,
+	// otherwise it falls through to an upload, so the blobs of the other repositories are not revealed.
+	if mount, from := query.Get("mount"), query.Get("from"); mount != "" && h.canPull(r, from) {
+		digest, err := v1.NewHash(mount)
+		if err == nil {
+			_, err = h.storage.StatBlob(r.Context(), from, digest)
+			if err == nil {
+				writeBlobCreated(w, image, digest)
+				return
+			}
+		}
+	}
+
+	if digest := query.Get("digest"); digest != "" {
+		h.putBlob(w, r, image, r.Body, digest)
+		return
+	}
+
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		_ = regErrInternal(err).Write(w)
+		return
+	}
+	id := hex.EncodeToString(b)
+
+	p := h.uploadFile(id)
+	err = os.MkdirAll(path.Dir(p), 0755)
+	if err != nil {
+		_ = regErrInternal(err).Write(w)
+		return
+	}
+	err = os.WriteFile(p, nil, 0644)
+	if err != nil {
+		_ = regErrInternal(err).Write(w)
+		return
+	}
+	writeUploadAccepted(w, image, id, 0)
+}
+
+// patchUpload appends the body to the upload, or finishes it if the method is PUT.
+//
+//	PATCH /v2/<name>/blobs/uploads/<id>
+//	PUT /v2/<name>/blobs/uploads/<id>?digest=<digest>
+func (h *Handler) patchUpload(w http.ResponseWriter, r *http.Request, image, id string) {
+	if !uploadID.MatchString(id) {
+		_ = regErrBlobUploadUnknown.Write(w)
+		return
+	}
+	p := h.uploadFile(id)
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			_ = regErrBlobUploadUnknown.Write(w)
+			return
+		}
+		_ = regErrInternal(err).Write(w)
+		return
+	}
+	_, err = io.Copy(f, r.Body)
+	if err != nil {
+		_ = f.Close()
+		_ = regErrInternal(err).Write(w)
+		return
+	}
+	info, err := f.Stat()
+	_ = f.Close()
+	if err != nil {
+		_ = regErrInternal(err).Write(w)
+		return
+	}
+
+	if r.Method == http.MethodPatch {
+		writeUploadAccepted(w, image, id, info.Size())
+		return
+	}
+
+	f, err = os.Open(p)
+	if err != nil {
+		_ = regErrInternal(err).Write(w)
+		return
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(p)
+	}()
+	h.putBlob(w, r, image, f, r.URL.Query().Get("digest"))
+}
+
+func (h *Handler) putBlob(w http.ResponseWriter, r *http.Request, image string, body io.Reader, digest string) {
+	expected, err := v1.NewHash(digest)
+	if err != nil {
+		_ = regErrDigestInvalid(err).Write(w)
+		return
+	}
+	desc, err := h.storage.PutBlob(r.Context(), image, body)
+	if err != nil {
+		_ = regErrInternal(err).Write(w)
+		return
+	}
+	if desc.Digest != expected {
+		_ = regErrDigestInvalid(fmt.Errorf("digest %s does not match the content %s", expected, desc.Digest)).Write(w)
+		return
+	}
+	writeBlobCreated(w, image, desc.Digest)
+}
+
+func writeBlobCreated(w http.ResponseWriter, image string, digest v1.Hash) {
+	w.Header().Set("Location", "/v2/"+image+"/blobs/"+digest.String())
+	w.Header().Set("Docker-Content-Digest", digest.String())
+	w.WriteHeader(http.StatusCreated)
+}
+
+func writeUploadAccepted(w http.ResponseWriter, image, id string, size int64) {
+	w.Header().Set("Location", "/v2/"+image+"/blobs/uploads/"+id)
+	w.Header().Set("Docker-Upload-UUID", id)
+	w.Header().Set("Range", "0-"+strconv.FormatInt(max(size-1, 0), 10))
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// putManifest stores the manifest of an artifact, the manifest must refer to a subject and be pushed by digest.
+//
+//	PUT /v2/<name>/manifests/<reference>
+func (h *Handler) putManifest(w http.ResponseWriter, r *http.Request, image, reference string) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxManifestSize+1))
+	if err != nil {
+		_ = regErrInternal(err).Write(w)
+		return
+	}
+	if len(data) > maxManifestSize {
+		_ = regErrManifestInvalid(fmt.Errorf("manifest is larger than %d", maxManifestSize)).Write(w)
+		return
+	}
+
+	manifest, err := storage.NewManifest(data)
+	if err != nil {
+		_ = regErrManifestInvalid(err).Write(w)
+		return
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		manifest.MediaType = types.MediaType(ct)
+	}
+	digest := manifest.Digest()
+
+	if storage.IsDigest(reference) && reference != digest.String() {
+		_ = regErrDigestInvalid(fmt.Errorf("digest %s does not match the content %s", reference, digest)).Write(w)
+		return
+	}
+
+	var subject *v1.Descriptor
+	if manifest.MediaType.IsImage() {
+		m, err := v1.ParseManifest(bytes.NewReader(manifest.Data))
+		if err != nil {
+			_ = regErrManifestInvalid(err).Write(w)
+			return
+		}
+		err = h.checkManifestBlobs(r, image, m)
+		if err != nil {
+			_ = regErrManifestBlobUnknown(err).Write(w)
+			return
+		}
+		subject = m.Subject
+	}
+	// The tags are built by the rules or written by jitdi, such as the referrers indexes and the signatures,
+	// so only the artifacts referring to a manifest can be pushed, and only by digest.
+	if subject == nil || !storage.IsDigest(reference) {
+		_ = regErrDeniedWith(fmt.Errorf("only the artifacts referring to a manifest can be pushed, by digest")).Write(w)
+		return
+	}
+
+	err = h.storage.PutManifest(r.Context(), image, reference, manifest)
+	if err != nil {
+		_ = regErrInternal(err).Write(w)
+		return
+	}
+
+	if subject != nil {
+		_, err = storage.AddReferrer(r.Context(), h.storage, image, manifest)
+		if err != nil {
+			_ = regErrInternal(err).Write(w)
+			return
+		}
+		w.Header().Set("OCI-Subject", subject.Digest.String())
+	}
+
+	slog.Info("pushed artifact", "image", image, "reference", reference, "digest", digest)
+	w.Header().Set("Location", "/v2/"+image+"/manifests/"+digest.String())
+	w.Header().Set("Docker-Content-Digest", digest.String())
+	w.WriteHeader(http.StatusCreated)
+}
+
+// checkManifestBlobs returns an error if a blob referenced by the image manifest does not exist.
+func (h *Handler) checkManifestBlobs(r *http.Request, image string, m *v1.Manifest) error {
+	for _, desc := range append([]v1.Descriptor{m.Config}, m.Layers...) {
+		_, err := h.storage.StatBlob(r.Context(), image, desc.Digest)
+		if err != nil {
+			return fmt.Errorf("blob %s: %w", desc.Digest, err)
+		}
+	}
+	return nil
+}
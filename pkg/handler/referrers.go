@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+
+	"github.com/wzshiming/jitdi/pkg/storage"
+)
+
+// referrers serves the manifests referring to the digest, filtered by the artifactType.
+//
+//	GET /v2/<name>/referrers/<digest>?artifactType=<type>
+func (h *Handler) referrers(w http.ResponseWriter, r *http.Request, image, hash string) {
+	digest, err := v1.NewHash(hash)
+	if err != nil {
+		_ = regErrDigestInvalid(err).Write(w)
+		return
+	}
+
+	index, err := storage.Referrers(r.Context(), h.storage, image, digest)
+	if err != nil {
+		_ = regErrInternal(err).Write(w)
+		return
+	}
+
+	if artifactType := r.URL.Query().Get("artifactType"); artifactType != "" {
+		index.Manifests = slices.DeleteFunc(index.Manifests, func(desc v1.Descriptor) bool {
+			return desc.ArtifactType != artifactType
+		})
+		w.Header().Set("OCI-Filters-Applied", "artifactType")
+	}
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		_ = regErrInternal(err).Write(w)
+		return
+	}
+	w.Header().Set("Content-Type", string(types.OCIImageIndex))
+	if r.Method == http.MethodHead {
+		return
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		slog.Error("w.Write", "err", err)
+	}
+}
@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+
+	"github.com/wzshiming/jitdi/pkg/storage"
+)
+
+// Reproduce rebuilds the image of the reference from its provenance, without the storage and the cached layers,
+// and returns an error if the rebuild is not byte-identical.
+// The rebuild is of the recorded base and inputs, each input is fetched from its recorded source and checked
+// against its recorded diffID as it is read, the first input that differs fails the rebuild.
+// The reference is <repo>:<tag> of an image in the storage, or <repo>@<digest>.
+func (h *Handler) Reproduce(ctx context.Context, ref string) (*Provenance, error) {
+	image, digest, err := h.resolveDigest(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	pinned, err := h.GetProvenance(digest)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, fmt.Errorf("no provenance of %s: %w", digest, err)
+		}
+		return nil, err
+	}
+	if pinned.Repository != image {
+		return nil, fmt.Errorf("provenance of %s is of %s: %w", digest, pinned.Repository, storage.ErrNotFound)
+	}
+
+	action, ok := h.matchRule(pinned.Rule, pinned.Repository, pinned.Tag)
+	if !ok {
+		return nil, fmt.Errorf("%w: rule %q of %s", ErrNoMatch, pinned.Rule, pinned.Repository+":"+pinned.Tag)
+	}
+
+	dir, err := os.MkdirTemp("", "jitdi-reproduce-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	s := storage.NewLocalStorage(path.Join(dir, "blobs"), path.Join(dir, "manifests"))
+	built, err := h.build(ctx, s, "", image, action, pinned)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkRebuild(pinned, built)
+	if err != nil {
+		diff := diffInputs(pinned.Inputs, built.Inputs)
+		if len(diff) != 0 {
+			return built, fmt.Errorf("%w, changed inputs: %s", err, strings.Join(diff, ", "))
+		}
+		return built, err
+	}
+	return built, nil
+}
+
+// resolveDigest returns the repository and the digest of the reference,
+// the tag is resolved from the storage.
+func (h *Handler) resolveDigest(ctx context.Context, ref string) (string, v1.Hash, error) {
+	if image, digest, ok := strings.Cut(ref, "@"); ok {
+		d, err := v1.NewHash(digest)
+		if err != nil {
+			return "", v1.Hash{}, err
+		}
+		return image, d, nil
+	}
+
+	image, tag := splitReference(ref)
+	manifest, err := h.storage.GetManifest(ctx, image, tag)
+	if err != nil {
+		return "", v1.Hash{}, fmt.Errorf("resolve %s: %w", ref, err)
+	}
+	return image, manifest.Digest(), nil
+}
+
+// diffInputs returns the descriptions of the inputs added, removed or changed.
+func diffInputs(pinned, built []Input) []string {
+	pinnedInputs := map[string]Input{}
+	for _, i := range pinned {
+		pinnedInputs[i.key()] = i
+	}
+
+	diff := []string{}
+	for _, i := range built {
+		k := i.key()
+		p, ok := pinnedInputs[k]
+		switch {
+		case !ok:
+			diff = append(diff, fmt.Sprintf("%s added from %s", k, i.Source))
+		case p.DiffID != i.DiffID:
+			diff = append(diff, fmt.Sprintf("%s from %s changed %s to %s", k, i.Source, p.DiffID, i.DiffID))
+		}
+		delete(pinnedInputs, k)
+	}
+	for _, i := range pinned {
+		if _, ok := pinnedInputs[i.key()]; ok {
+			diff = append(diff, fmt.Sprintf("%s removed from %s", i.key(), i.Source))
+		}
+	}
+	return diff
+}
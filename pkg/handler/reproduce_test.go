@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+)
+
+func TestDiffInputs(t *testing.T) {
+	a := v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("a", 64)}
+	b := v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("b", 64)}
+
+	tests := []struct {
+		name   string
+		pinned []Input
+		built  []Input
+		want   []string
+	}{
+		{
+			name:   "same",
+			pinned: []Input{{Source: "https://example.com/a", Path: "/a", DiffID: a}},
+			built:  []Input{{Source: "https://example.com/a", Path: "/a", DiffID: a}},
+			want:   []string{},
+		},
+		{
+			name:   "changed",
+			pinned: []Input{{Source: "https://example.com/a", Path: "/a", DiffID: a}},
+			built:  []Input{{Source: "https://example.com/a", Path: "/a", DiffID: b}},
+			want:   []string{"/a from https://example.com/a changed " + a.String() + " to " + b.String()},
+		},
+		{
+			name:   "added",
+			pinned: []Input{},
+			built:  []Input{{Source: "https://example.com/a", Path: "/a", DiffID: a}},
+			want:   []string{"/a added from https://example.com/a"},
+		},
+		{
+			name:   "removed",
+			pinned: []Input{{Source: "https://example.com/a", Path: "/a", DiffID: a}},
+			built:  []Input{},
+			want:   []string{"/a removed from https://example.com/a"},
+		},
+		{
+			name: "platforms",
+			pinned: []Input{
+				{Platform: "linux/amd64", Source: "https://example.com/amd64/a", Path: "/a", DiffID: a},
+				{Platform: "linux/arm64", Source: "https://example.com/arm64/a", Path: "/a", DiffID: a},
+			},
+			built: []Input{
+				{Platform: "linux/amd64", Source: "https://example.com/amd64/a", Path: "/a", DiffID: a},
+				{Platform: "linux/arm64", Source: "https://example.com/arm64/a", Path: "/a", DiffID: b},
+			},
+			want: []string{"linux/arm64:/a from https://example.com/arm64/a changed " + a.String() + " to " + b.String()},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diffInputs(tt.pinned, tt.built)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffInputs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReproduce(t *testing.T) {
+	base := pushTestBase(t)
+
+	src := t.TempDir()
+	writeTestFile(t, filepath.Join(src, "kubectl"), "kubectl v1.29.3")
+
+	cache := t.TempDir()
+	h := newTestHandler(t, cache, base, src)
+	pullTestImage(t, h, "test/kubectl", "v1.29.3")
+
+	tests := []struct {
+		name    string
+		update  func(t *testing.T) *Handler
+		wantErr string
+	}{
+		{
+			name: "unchanged",
+			update: func(t *testing.T) *Handler {
+				return h
+			},
+		},
+		{
+			name: "source changed in the config",
+			update: func(t *testing.T) *Handler {
+				other := t.TempDir()
+				writeTestFile(t, filepath.Join(other, "kubectl"), "kubectl v1.29.3")
+				return newTestHandler(t, cache, base, other)
+			},
+			wantErr: "is not of the inputs",
+		},
+		{
+			name: "input changed upstream",
+			update: func(t *testing.T) *Handler {
+				writeTestFile(t, filepath.Join(src, "kubectl"), "kubectl v1.29.4")
+				return h
+			},
+			wantErr: "input /usr/local/bin/kubectl from " + filepath.Join(src, "kubectl") + " changed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := tt.update(t).Reproduce(context.Background(), "test/kubectl:v1.29.3")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Reproduce() error = %v", err)
+				}
+				if len(p.Inputs) != 1 || p.Inputs[0].Path != "/usr/local/bin/kubectl" {
+					t.Errorf("Reproduce() inputs = %v", p.Inputs)
+				}
+				return
+			}
+			if !errors.Is(err, ErrNotReproducible) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Reproduce() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
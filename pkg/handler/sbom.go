@@ -0,0 +1,348 @@
+package handler
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+
+	"github.com/wzshiming/jitdi/pkg/pattern"
+	"github.com/wzshiming/jitdi/pkg/storage"
+)
+
+// MediaTypeCycloneDX is the media type and the artifact type of the CycloneDX SBOMs.
+const MediaTypeCycloneDX types.MediaType = "application/vnd.cyclonedx+json"
+
+// The properties of the components of the SBOMs.
+const (
+	propertyPlatform = "io.zsm.jitdi:platform"
+	propertyDiffID   = "io.zsm.jitdi:diffID"
+	propertySize     = "io.zsm.jitdi:size"
+	propertyModel    = "io.zsm.jitdi:model"
+)
+
+// cyclonedx is a CycloneDX SBOM.
+type cyclonedx struct {
+	BOMFormat    string                `json:"bomFormat"`
+	SpecVersion  string                `json:"specVersion"`
+	Version      int                   `json:"version"`
+	Metadata     cyclonedxMetadata     `json:"metadata"`
+	Components   []cyclonedxComponent  `json:"components"`
+	Dependencies []cyclonedxDependency `json:"dependencies"`
+}
+
+type cyclonedxMetadata struct {
+	Tools struct {
+		Components []cyclonedxComponent `json:"components"`
+	} `json:"tools"`
+	Component cyclonedxComponent `json:"component"`
+}
+
+type cyclonedxComponent struct {
+	BOMRef             string                       `json:"bom-ref,omitempty"`
+	Type               string                       `json:"type"`
+	Name               string                       `json:"name"`
+	Version            string                       `json:"version,omitempty"`
+	Hashes             []cyclonedxHash              `json:"hashes,omitempty"`
+	ExternalReferences []cyclonedxExternalReference `json:"externalReferences,omitempty"`
+	Properties         []cyclonedxProperty          `json:"properties,omitempty"`
+}
+
+type cyclonedxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cyclonedxExternalReference struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type cyclonedxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cyclonedxDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn"`
+}
+
+func cyclonedxHashes(h v1.Hash) []cyclonedxHash {
+	if h.Algorithm != "sha256" {
+		return nil
+	}
+	return []cyclonedxHash{{Alg: "SHA-256", Content: h.Hex}}
+}
+
+// newSBOM returns the CycloneDX SBOM of the build, the base image and the files added,
+// sizes is the sizes of the files by the diffIDs of their layers.
+func newSBOM(action *pattern.Action, p *Provenance, sizes map[v1.Hash]int64) (*cyclonedx, error) {
+	base, err := v1.NewHash(p.Base[strings.LastIndex(p.Base, "@")+1:])
+	if err != nil {
+		return nil, fmt.Errorf("base %q: %w", p.Base, err)
+	}
+
+	// The models are the sources of the ollama mutates, the others are the files.
+	models := map[string]bool{}
+	for _, m := range action.GetMutates(nil) {
+		if m.Ollama != nil {
+			models[m.Ollama.Model] = true
+		}
+	}
+
+	bom := &cyclonedx{
+		BOMFormat:   "CycloneDX",
+		SpecVersion: "1.5",
+		Version:     1,
+	}
+	bom.Metadata.Tools.Components = []cyclonedxComponent{
+		{
+			Type: "application",
+			Name: "jitdi",
+		},
+	}
+	bom.Metadata.Component = cyclonedxComponent{
+		BOMRef:  p.Repository + "@" + p.Digest.String(),
+		Type:    "container",
+		Name:    p.Repository,
+		Version: p.Tag,
+		Hashes:  cyclonedxHashes(p.Digest),
+	}
+
+	bom.Components = append(bom.Components, cyclonedxComponent{
+		BOMRef:  p.Base,
+		Type:    "container",
+		Name:    action.GetBaseImage(),
+		Version: base.String(),
+		Hashes:  cyclonedxHashes(base),
+	})
+
+	for _, input := range p.Inputs {
+		c := cyclonedxComponent{
+			BOMRef: input.Path,
+			Type:   "file",
+			Name:   input.Path,
+		}
+		if input.Platform != "" {
+			c.BOMRef = input.Platform + ":" + input.Path
+			c.Properties = append(c.Properties, cyclonedxProperty{Name: propertyPlatform, Value: input.Platform})
+		}
+		if models[input.Source] {
+			c.Type = "machine-learning-model"
+			c.Properties = append(c.Properties, cyclonedxProperty{Name: propertyModel, Value: input.Source})
+		} else {
+			c.ExternalReferences = []cyclonedxExternalReference{
+				{Type: "distribution", URL: input.Source},
+			}
+		}
+		c.Properties = append(c.Properties, cyclonedxProperty{Name: propertyDiffID, Value: input.DiffID.String()})
+		if size, ok := sizes[input.DiffID]; ok {
+			c.Properties = append(c.Properties, cyclonedxProperty{Name: propertySize, Value: strconv.FormatInt(size, 10)})
+		}
+		bom.Components = append(bom.Components, c)
+	}
+
+	dependsOn := make([]string, 0, len(bom.Components))
+	for _, c := range bom.Components {
+		dependsOn = append(dependsOn, c.BOMRef)
+	}
+	bom.Dependencies = []cyclonedxDependency{
+		{
+			Ref:       bom.Metadata.Component.BOMRef,
+			DependsOn: dependsOn,
+		},
+	}
+	return bom, nil
+}
+
+// inputSizes returns the sizes of the files added by the build by the diffIDs of their layers,
+// they are read from the tar headers of the layers in the storage.
+func inputSizes(ctx context.Context, s storage.Storage, p *Provenance) (map[v1.Hash]int64, error) {
+	digests := p.Manifests
+	if len(digests) == 0 {
+		digests = []v1.Hash{p.Digest}
+	}
+
+	sizes := map[v1.Hash]int64{}
+	for _, digest := range digests {
+		manifest, err := s.GetManifest(ctx, p.Repository, digest.String())
+		if err != nil {
+			return nil, err
+		}
+		image, err := storage.Image(ctx, s, p.Repository, manifest)
+		if err != nil {
+			return nil, err
+		}
+		for _, input := range p.Inputs {
+			if _, ok := sizes[input.DiffID]; ok {
+				continue
+			}
+			layer, err := image.LayerByDiffID(input.DiffID)
+			if err != nil {
+				// The input is of another platform.
+				continue
+			}
+			size, err := tarFileSize(layer)
+			if err != nil {
+				return nil, fmt.Errorf("layer %s: %w", input.DiffID, err)
+			}
+			sizes[input.DiffID] = size
+		}
+	}
+	return sizes, nil
+}
+
+// tarFileSize returns the size of the first file in the layer.
+func tarFileSize(layer v1.Layer) (int64, error) {
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	hdr, err := tar.NewReader(rc).Next()
+	if err != nil {
+		return 0, err
+	}
+	return hdr.Size, nil
+}
+
+// pushSBOM pushes the SBOM of the build as a referrer of the subject.
+func pushSBOM(ctx context.Context, s storage.Storage, action *pattern.Action, p *Provenance, subject v1.Descriptor) error {
+	sizes, err := inputSizes(ctx, s, p)
+	if err != nil {
+		return err
+	}
+	bom, err := newSBOM(action, p, sizes)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(bom)
+	if err != nil {
+		return err
+	}
+	return pushArtifact(ctx, s, p.Repository, MediaTypeCycloneDX, data, nil, subject)
+}
+
+// SBOM builds the image if needed and writes its SBOM,
+// the reference is <image>:<tag> or <image>@<digest>.
+func (h *Handler) SBOM(ctx context.Context, w io.Writer, ref string) error {
+	image, manifest, err := h.resolveSBOM(ctx, ref)
+	if err != nil {
+		return err
+	}
+	index, err := storage.Referrers(ctx, h.storage, image, manifest.Digest())
+	if err != nil {
+		return err
+	}
+
+	for _, desc := range index.Manifests {
+		if desc.ArtifactType != string(MediaTypeCycloneDX) {
+			continue
+		}
+		m, err := h.storage.GetManifest(ctx, image, desc.Digest.String())
+		if err != nil {
+			return err
+		}
+		artifact, err := v1.ParseManifest(bytes.NewReader(m.Data))
+		if err != nil {
+			return err
+		}
+		if len(artifact.Layers) != 1 {
+			return fmt.Errorf("unexpected %d layers of the SBOM %s", len(artifact.Layers), desc.Digest)
+		}
+		rc, _, err := h.storage.GetBlob(ctx, image, artifact.Layers[0].Digest)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		_, err = io.Copy(w, rc)
+		return err
+	}
+	return fmt.Errorf("no SBOM of %s: %w", ref, storage.ErrNotFound)
+}
+
+func (h *Handler) resolveSBOM(ctx context.Context, ref string) (string, *storage.Manifest, error) {
+	if image, digest, ok := strings.Cut(ref, "@"); ok {
+		manifest, err := h.getOrRebuildManifest(ctx, image, digest)
+		if err != nil {
+			return "", nil, err
+		}
+		return image, manifest, nil
+	}
+
+	image, tag := splitReference(ref)
+	action, ok := h.match(ctx, image, tag)
+	if !ok {
+		return "", nil, fmt.Errorf("%w: %s", ErrNoMatch, ref)
+	}
+	manifest, err := h.getOrBuildManifest(ctx, image, tag, action)
+	if err != nil {
+		return "", nil, err
+	}
+	return image, manifest, nil
+}
+
+// sbom serves the SBOM of the image.
+//
+//	GET /jitdi/sbom/<image>:<tag>
+//	GET /jitdi/sbom/<image>@<digest>
+func (h *Handler) sbom(w http.ResponseWriter, r *http.Request) {
+	ref := strings.TrimPrefix(r.URL.Path, "/jitdi/sbom/")
+
+	if r.Method == http.MethodHead {
+		_, _, err := h.resolveSBOM(r.Context(), ref)
+		if err != nil {
+			writeSBOMError(w, ref, err)
+			return
+		}
+		w.Header().Set("Content-Type", string(MediaTypeCycloneDX))
+		return
+	}
+
+	pw := &sbomWriter{w: w}
+	err := h.SBOM(r.Context(), pw, ref)
+	if err != nil {
+		if !pw.written {
+			writeSBOMError(w, ref, err)
+			return
+		}
+		slog.Error("sbom", "err", err, "ref", ref)
+	}
+}
+
+func writeSBOMError(w http.ResponseWriter, ref string, err error) {
+	if errors.Is(err, ErrNoMatch) || errors.Is(err, storage.ErrNotFound) {
+		_ = regErrNotFound.Write(w)
+		return
+	}
+	slog.Error("sbom", "err", err, "ref", ref)
+	if writeBuildError(w, err) {
+		return
+	}
+	_ = regErrInternal(err).Write(w)
+}
+
+// sbomWriter sets the content type when the SBOM is written, so the errors can be written before it.
+type sbomWriter struct {
+	w       http.ResponseWriter
+	written bool
+}
+
+func (p *sbomWriter) Write(b []byte) (int, error) {
+	if !p.written {
+		p.written = true
+		p.w.Header().Set("Content-Type", string(MediaTypeCycloneDX))
+	}
+	return p.w.Write(b)
+}
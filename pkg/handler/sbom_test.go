@@ -0,0 +1,197 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+
+	"github.com/wzshiming/jitdi/pkg/apis/v1alpha1"
+	"github.com/wzshiming/jitdi/pkg/pattern"
+)
+
+func TestNewSBOM(t *testing.T) {
+	rule, err := pattern.NewRule(&v1alpha1.ImageSpec{
+		Match:     "ollama/{model}:{tag}",
+		BaseImage: "docker.io/library/alpine",
+		Mutates: []v1alpha1.Mutate{
+			{Ollama: &v1alpha1.Ollama{Model: "registry.ollama.ai/library/{model}:{tag}", ModelName: "{model}:{tag}", WorkDir: "/root/.ollama/models"}},
+			{File: &v1alpha1.File{Source: "https://dl.example.com/ollama", Destination: "/usr/local/bin/ollama"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewRule() error = %v", err)
+	}
+	action, ok := rule.Match("ollama/llama2:7b")
+	if !ok {
+		t.Fatal("Match() not matched")
+	}
+
+	hash := func(c string) v1.Hash {
+		return v1.Hash{Algorithm: "sha256", Hex: strings.Repeat(c, 64)}
+	}
+	p := &Provenance{
+		Repository: "ollama/llama2",
+		Tag:        "7b",
+		Base:       "docker.io/library/alpine@" + hash("0").String(),
+		Digest:     hash("1"),
+		Inputs: []Input{
+			{Platform: "linux/amd64", Source: "registry.ollama.ai/library/llama2:7b", Path: "/root/.ollama/models/blobs/sha256-a", DiffID: hash("2")},
+			{Platform: "linux/amd64", Source: "https://dl.example.com/ollama", Path: "/usr/local/bin/ollama", DiffID: hash("3")},
+		},
+	}
+	bom, err := newSBOM(action, p, map[v1.Hash]int64{hash("2"): 3_800_000_000})
+	if err != nil {
+		t.Fatalf("newSBOM() error = %v", err)
+	}
+
+	wantMetadata := cyclonedxComponent{
+		BOMRef:  "ollama/llama2@" + hash("1").String(),
+		Type:    "container",
+		Name:    "ollama/llama2",
+		Version: "7b",
+		Hashes:  []cyclonedxHash{{Alg: "SHA-256", Content: hash("1").Hex}},
+	}
+	if !reflect.DeepEqual(bom.Metadata.Component, wantMetadata) {
+		t.Errorf("metadata.component = %+v, want %+v", bom.Metadata.Component, wantMetadata)
+	}
+
+	want := []cyclonedxComponent{
+		{
+			BOMRef:  p.Base,
+			Type:    "container",
+			Name:    "docker.io/library/alpine",
+			Version: hash("0").String(),
+			Hashes:  []cyclonedxHash{{Alg: "SHA-256", Content: hash("0").Hex}},
+		},
+		{
+			BOMRef: "linux/amd64:/root/.ollama/models/blobs/sha256-a",
+			Type:   "machine-learning-model",
+			Name:   "/root/.ollama/models/blobs/sha256-a",
+			Properties: []cyclonedxProperty{
+				{Name: propertyPlatform, Value: "linux/amd64"},
+				{Name: propertyModel, Value: "registry.ollama.ai/library/llama2:7b"},
+				{Name: propertyDiffID, Value: hash("2").String()},
+				{Name: propertySize, Value: "3800000000"},
+			},
+		},
+		{
+			BOMRef: "linux/amd64:/usr/local/bin/ollama",
+			Type:   "file",
+			Name:   "/usr/local/bin/ollama",
+			ExternalReferences: []cyclonedxExternalReference{
+				{Type: "distribution", URL: "https://dl.example.com/ollama"},
+			},
+			Properties: []cyclonedxProperty{
+				{Name: propertyPlatform, Value: "linux/amd64"},
+				{Name: propertyDiffID, Value: hash("3").String()},
+			},
+		},
+	}
+	if !reflect.DeepEqual(bom.Components, want) {
+		t.Errorf("components = %+v, want %+v", bom.Components, want)
+	}
+
+	wantDependencies := []cyclonedxDependency{
+		{
+			Ref:       wantMetadata.BOMRef,
+			DependsOn: []string{want[0].BOMRef, want[1].BOMRef, want[2].BOMRef},
+		},
+	}
+	if !reflect.DeepEqual(bom.Dependencies, wantDependencies) {
+		t.Errorf("dependencies = %+v, want %+v", bom.Dependencies, wantDependencies)
+	}
+}
+
+func TestSBOMEndpoint(t *testing.T) {
+	src := t.TempDir()
+	writeTestFile(t, filepath.Join(src, "kubectl"), "kubectl v1.29.3")
+	h := newTestHandler(t, t.TempDir(), pushTestBase(t), src)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jitdi/sbom/test/kubectl:v1.29.3", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /jitdi/sbom = %d, %s", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != string(MediaTypeCycloneDX) {
+		t.Errorf("Content-Type = %q, want %q", ct, MediaTypeCycloneDX)
+	}
+	data := rec.Body.Bytes()
+
+	m, err := h.storage.GetManifest(context.Background(), "test/kubectl", "v1.29.3")
+	if err != nil {
+		t.Fatalf("GetManifest() error = %v", err)
+	}
+	// The SBOM is served from the referrer of the built image.
+	_, referrer := getTestReferrer(t, h, "test/kubectl", m.Digest(), MediaTypeCycloneDX)
+	if string(referrer) != string(data) {
+		t.Errorf("SBOM = %s, want the referrer %s", data, referrer)
+	}
+
+	var bom cyclonedx
+	err = json.Unmarshal(data, &bom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bom.BOMFormat != "CycloneDX" || bom.Metadata.Component.Name != "test/kubectl" {
+		t.Errorf("SBOM = %s %s, want CycloneDX of test/kubectl", bom.BOMFormat, bom.Metadata.Component.Name)
+	}
+	if hashes := bom.Metadata.Component.Hashes; len(hashes) != 1 || hashes[0].Content != m.Digest().Hex {
+		t.Errorf("metadata.component.hashes = %+v, want %s", hashes, m.Digest())
+	}
+	if len(bom.Components) != 2 {
+		t.Fatalf("components = %+v, want the base and the file", bom.Components)
+	}
+	file := bom.Components[1]
+	if file.Type != "file" || file.Name != "/usr/local/bin/kubectl" {
+		t.Errorf("component = %s %s, want the file /usr/local/bin/kubectl", file.Type, file.Name)
+	}
+	wantRefs := []cyclonedxExternalReference{{Type: "distribution", URL: filepath.Join(src, "kubectl")}}
+	if !reflect.DeepEqual(file.ExternalReferences, wantRefs) {
+		t.Errorf("externalReferences = %+v, want %+v", file.ExternalReferences, wantRefs)
+	}
+	if !strings.Contains(string(data), `{"name":"`+propertySize+`","value":"15"}`) {
+		t.Errorf("SBOM = %s, want the size 15 of the file", data)
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		wantCode int
+	}{
+		{
+			name:     "head",
+			method:   http.MethodHead,
+			path:     "/jitdi/sbom/test/kubectl:v1.29.3",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "digest",
+			method:   http.MethodGet,
+			path:     "/jitdi/sbom/test/kubectl@" + m.Digest().String(),
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "no match",
+			method:   http.MethodGet,
+			path:     "/jitdi/sbom/unknown/kubectl:v1.29.3",
+			wantCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if rec.Code != tt.wantCode {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, rec.Code, tt.wantCode)
+			}
+		})
+	}
+}
@@ -2,241 +2,122 @@ package handler
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"io"
 	"log/slog"
 	"net/http"
-	"os"
-	"path"
 	"strconv"
-	"strings"
+	"time"
 
-	"github.com/google/go-containerregistry/pkg/name"
-	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/go-containerregistry/pkg/v1"
 
 	"github.com/wzshiming/jitdi/pkg/pattern"
 	"github.com/wzshiming/jitdi/pkg/storage"
 )
 
 func (h *Handler) blobs(w http.ResponseWriter, r *http.Request, image, hash string) {
-	if h.storageRegistry != "" {
-		h.forwardBlob(w, r, image, hash)
-		return
-	}
-
-	blobPath := storage.LocalBlobPath(h.blobPath, hash)
-	f, err := os.Open(blobPath)
+	digest, err := v1.NewHash(hash)
 	if err != nil {
-		if os.IsNotExist(err) {
-			_ = regErrBlobUnknown.Write(w)
-			return
-		}
-		_ = regErrInternal(err).Write(w)
+		_ = regErrBlobUnknown.Write(w)
 		return
 	}
-	defer f.Close()
 
-	stat, err := f.Stat()
-	if err != nil {
-		_ = regErrInternal(err).Write(w)
-		return
-	}
-	http.ServeContent(w, r, path.Base(r.URL.Path), stat.ModTime(), f)
-}
-
-func (h *Handler) localManifests(w http.ResponseWriter, r *http.Request, image, tag string, action *pattern.Action) {
-	if h.storageRegistry != "" {
-		h.forwardManifest(w, r, image, tag, action)
-		return
-	}
-
-	manifestPath := storage.LocalManifestPath(h.manifestPath, image, tag)
-	_, err := os.Stat(manifestPath)
-	if err != nil {
-		err := h.buildAndSave(context.Background(), image, tag, action)
+	if h.verifyOnServe {
+		err = h.verifyBlob(r.Context(), digest)
 		if err != nil {
-			slog.Error("image.Build", "err", err)
+			if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrBlobCorrupted) {
+				_ = regErrBlobUnknown.Write(w)
+				return
+			}
 			_ = regErrInternal(err).Write(w)
 			return
 		}
 	}
 
-	serveManifest(w, r, manifestPath)
-}
-
-func (h *Handler) forwardBlob(w http.ResponseWriter, r *http.Request, image, hash string) {
-	refDestination, err := name.NewDigest(h.storageRegistry + "/" + image + "@" + hash)
-	if err != nil {
-		_ = regErrInternal(err).Write(w)
-		return
-	}
-
-	puller, err := h.getPuller(refDestination)
-	if err != nil {
-		_ = regErrInternal(err).Write(w)
-		return
-	}
-
-	layer, err := puller.Layer(r.Context(), refDestination)
-	if err != nil {
-		_ = regErrInternal(err).Write(w)
-		return
-	}
-
-	mediaType, err := layer.MediaType()
-	if err != nil {
-		_ = regErrInternal(err).Write(w)
-		return
-	}
-	size, err := layer.Size()
-	if err != nil {
-		_ = regErrInternal(err).Write(w)
-		return
-	}
-
-	w.Header().Set("Content-Type", string(mediaType))
-	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
 	if r.Method == http.MethodHead {
-		return
-	}
-
-	if strings.HasSuffix(string(mediaType), "gzip") {
-		r, err := layer.Compressed()
-		if err != nil {
-			_ = regErrInternal(err).Write(w)
-			return
-		}
-
-		_, err = io.Copy(w, r)
-		if err != nil {
-			slog.Error("io.Copy", "err", err)
-		}
-	} else {
-		r, err := layer.Uncompressed()
+		desc, err := h.storage.StatBlob(r.Context(), image, digest)
 		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				_ = regErrBlobUnknown.Write(w)
+				return
+			}
 			_ = regErrInternal(err).Write(w)
 			return
 		}
-
-		_, err = io.Copy(w, r)
-		if err != nil {
-			slog.Error("io.Copy", "err", err)
-		}
-	}
-}
-
-func (h *Handler) forwardManifestBlob(w http.ResponseWriter, r *http.Request, image, hash string) {
-	refDestination, err := name.NewDigest(h.storageRegistry + "/" + image + "@" + hash)
-	if err != nil {
-		_ = regErrInternal(err).Write(w)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.FormatInt(desc.Size, 10))
+		w.Header().Set("Docker-Content-Digest", digest.String())
 		return
 	}
 
-	puller, err := h.getPuller(refDestination)
+	rc, desc, err := h.storage.GetBlob(r.Context(), image, digest)
 	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			_ = regErrBlobUnknown.Write(w)
+			return
+		}
 		_ = regErrInternal(err).Write(w)
 		return
 	}
+	defer rc.Close()
 
-	desc, err := puller.Get(r.Context(), refDestination)
-	if err != nil {
-		_ = regErrInternal(err).Write(w)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", digest.String())
+	if rs, ok := rc.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", time.Time{}, rs)
 		return
 	}
 
-	w.Header().Set("Content-Type", string(desc.MediaType))
 	w.Header().Set("Content-Length", strconv.FormatInt(desc.Size, 10))
-	if r.Method == http.MethodHead {
-		return
+	_, err = io.Copy(w, rc)
+	if err != nil {
+		slog.Error("io.Copy", "err", err)
 	}
+}
 
-	manifest, err := desc.RawManifest()
+func (h *Handler) buildManifests(w http.ResponseWriter, r *http.Request, image, tag string, action *pattern.Action) {
+	manifest, err := h.getOrBuildManifest(r.Context(), image, tag, action)
 	if err != nil {
+		slog.Error("image.Build", "err", err)
+		if writeBuildError(w, err) {
+			return
+		}
 		_ = regErrInternal(err).Write(w)
 		return
 	}
 
-	_, err = w.Write(manifest)
-	if err != nil {
-		slog.Error("w.Write", "err", err)
-	}
+	serveManifest(w, r, manifest)
 }
 
-func (h *Handler) forwardManifest(w http.ResponseWriter, r *http.Request, image, tag string, action *pattern.Action) {
-	refDestination, err := name.ParseReference(h.storageRegistry + "/" + action.GetMatchImage())
-	if err != nil {
-		_ = regErrInternal(err).Write(w)
-		return
+// getOrBuildManifest returns the manifest from the storage, building it first if it does not exist.
+func (h *Handler) getOrBuildManifest(ctx context.Context, image, tag string, action *pattern.Action) (*storage.Manifest, error) {
+	manifest, err := h.storage.GetManifest(ctx, image, tag)
+	if err == nil {
+		return manifest, nil
 	}
-
-	puller, err := h.getPuller(refDestination)
-	if err != nil {
-		_ = regErrInternal(err).Write(w)
-		return
+	if !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
 	}
 
-	desc, err := puller.Get(r.Context(), refDestination)
+	// The build is not canceled with the request, it is shared by the requests of the same image.
+	err = h.buildAndSave(context.WithoutCancel(ctx), image, tag, action, nil)
 	if err != nil {
-		err := h.buildAndSave(context.Background(), image, tag, action)
-		if err != nil {
-			slog.Error("image.Build", "err", err)
-			_ = regErrInternal(err).Write(w)
-			return
-		}
-
-		desc, err = puller.Get(r.Context(), refDestination)
-		if err != nil {
-			_ = regErrInternal(err).Write(w)
-			return
-		}
+		return nil, err
 	}
 
-	manifest, err := desc.RawManifest()
-	if err != nil {
-		_ = regErrInternal(err).Write(w)
-		return
-	}
+	return h.storage.GetManifest(ctx, image, tag)
+}
 
-	w.Header().Set("Content-Type", string(desc.MediaType))
-	w.Header().Set("Content-Length", strconv.FormatInt(desc.Size, 10))
+func serveManifest(w http.ResponseWriter, r *http.Request, manifest *storage.Manifest) {
+	w.Header().Set("Content-Type", string(manifest.MediaType))
+	w.Header().Set("Content-Length", strconv.Itoa(len(manifest.Data)))
+	w.Header().Set("Docker-Content-Digest", manifest.Digest().String())
 	if r.Method == http.MethodHead {
 		return
 	}
 
-	_, err = w.Write(manifest)
+	_, err := w.Write(manifest.Data)
 	if err != nil {
 		slog.Error("w.Write", "err", err)
 	}
 }
-
-func serveManifest(w http.ResponseWriter, r *http.Request, manifestPath string) {
-	f, err := os.Open(manifestPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			_ = regErrNotFound.Write(w)
-			return
-		}
-		_ = regErrInternal(err).Write(w)
-		return
-	}
-	defer f.Close()
-
-	mediaType := struct {
-		MediaType types.MediaType `json:"mediaType,omitempty"`
-	}{}
-
-	err = json.NewDecoder(f).Decode(&mediaType)
-	if err != nil {
-		_ = regErrInternal(err).Write(w)
-		return
-	}
-	stat, err := f.Stat()
-	if err != nil {
-		_ = regErrInternal(err).Write(w)
-		return
-	}
-
-	w.Header().Set("Content-Type", string(mediaType.MediaType))
-	_, _ = f.Seek(0, 0)
-	http.ServeContent(w, r, path.Base(r.URL.Path), stat.ModTime(), f)
-}
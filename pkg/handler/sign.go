@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/wzshiming/jitdi/pkg/signer"
+)
+
+// WithSigner signs each manifest of the builds, the signatures are cosign compatible.
+func WithSigner(s *signer.Signer) option {
+	return func(h *Handler) {
+		h.signer = s
+	}
+}
+
+// publicKey serves the public key of the signer, to verify the signatures with cosign verify --key.
+//
+//	GET /jitdi/cosign.pub
+func (h *Handler) publicKey(w http.ResponseWriter, r *http.Request) {
+	if h.signer == nil {
+		_ = regErrNotFound.Write(w)
+		return
+	}
+	data, err := h.signer.PublicKey()
+	if err != nil {
+		_ = regErrInternal(err).Write(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	if r.Method == http.MethodHead {
+		return
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		slog.Error("w.Write", "err", err)
+	}
+}
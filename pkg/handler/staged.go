@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+
+	"github.com/wzshiming/jitdi/pkg/storage"
+)
+
+// stagedStorage writes into a scratch directory, the writes are copied to the storage only when they are committed,
+// so a rebuild that does not reproduce its digest leaves nothing in the storage.
+// The blobs existing in the storage are read from it and not copied.
+type stagedStorage struct {
+	storage.Storage
+	target storage.Storage
+	dir    string
+
+	mut       sync.Mutex
+	blobs     []stagedBlob
+	manifests []stagedManifest
+}
+
+type stagedBlob struct {
+	repo   string
+	digest v1.Hash
+}
+
+type stagedManifest struct {
+	repo      string
+	reference string
+	manifest  *storage.Manifest
+}
+
+func newStagedStorage(target storage.Storage) (*stagedStorage, error) {
+	dir, err := os.MkdirTemp("", "jitdi-rebuild-")
+	if err != nil {
+		return nil, err
+	}
+	return &stagedStorage{
+		Storage: storage.NewLocalStorage(path.Join(dir, "blobs"), path.Join(dir, "manifests")),
+		target:  target,
+		dir:     dir,
+	}, nil
+}
+
+func (s *stagedStorage) StatBlob(ctx context.Context, repo string, digest v1.Hash) (*v1.Descriptor, error) {
+	desc, err := s.Storage.StatBlob(ctx, repo, digest)
+	if errors.Is(err, storage.ErrNotFound) {
+		return s.target.StatBlob(ctx, repo, digest)
+	}
+	return desc, err
+}
+
+func (s *stagedStorage) GetBlob(ctx context.Context, repo string, digest v1.Hash) (io.ReadCloser, *v1.Descriptor, error) {
+	rc, desc, err := s.Storage.GetBlob(ctx, repo, digest)
+	if errors.Is(err, storage.ErrNotFound) {
+		return s.target.GetBlob(ctx, repo, digest)
+	}
+	return rc, desc, err
+}
+
+func (s *stagedStorage) PutBlob(ctx context.Context, repo string, r io.Reader) (*v1.Descriptor, error) {
+	desc, err := s.Storage.PutBlob(ctx, repo, r)
+	if err != nil {
+		return nil, err
+	}
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.blobs = append(s.blobs, stagedBlob{repo: repo, digest: desc.Digest})
+	return desc, nil
+}
+
+func (s *stagedStorage) PutManifest(ctx context.Context, repo, reference string, manifest *storage.Manifest) error {
+	err := s.Storage.PutManifest(ctx, repo, reference, manifest)
+	if err != nil {
+		return err
+	}
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.manifests = append(s.manifests, stagedManifest{repo: repo, reference: reference, manifest: manifest})
+	return nil
+}
+
+// commit copies the blobs and then the manifests in the order they are written to the storage,
+// the referrers are added to the referrers indexes of the storage instead of replacing them.
+func (s *stagedStorage) commit(ctx context.Context) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	for _, b := range s.blobs {
+		err := s.commitBlob(ctx, b)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, m := range s.manifests {
+		if storage.IsReferrersTag(m.reference) {
+			continue
+		}
+		err := s.target.PutManifest(ctx, m.repo, m.reference, m.manifest)
+		if err != nil {
+			return err
+		}
+		if storage.IsDigest(m.reference) {
+			_, err = storage.AddReferrer(ctx, s.target, m.repo, m.manifest)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *stagedStorage) commitBlob(ctx context.Context, b stagedBlob) error {
+	_, err := s.target.StatBlob(ctx, b.repo, b.digest)
+	if err == nil {
+		return nil
+	}
+	rc, _, err := s.Storage.GetBlob(ctx, b.repo, b.digest)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	_, err = s.target.PutBlob(ctx, b.repo, rc)
+	return err
+}
+
+// Close removes the scratch directory.
+func (s *stagedStorage) Close() error {
+	return os.RemoveAll(s.dir)
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+
+	"github.com/wzshiming/jitdi/pkg/apis/v1alpha1"
+	"github.com/wzshiming/jitdi/pkg/pattern"
+	"github.com/wzshiming/jitdi/pkg/signer"
+)
+
+// ErrBaseNotVerified is returned when the base image is not verified by the verification of the rule or the registry.
+var ErrBaseNotVerified = errors.New("base image is not verified")
+
+// ConditionBaseImageVerified is the condition of the Image, whether the base image of the last build is verified.
+const ConditionBaseImageVerified = "BaseImageVerified"
+
+// verifyBase returns an error if the base image of the digest is not verified
+// by the verification of the rule and the verification of its registry.
+func (h *Handler) verifyBase(ctx context.Context, action *pattern.Action, ref name.Reference, digest v1.Hash) error {
+	var verifications []*v1alpha1.Verification
+	if v := action.GetVerification(); v != nil {
+		verifications = append(verifications, v)
+	}
+	if r, ok := h.getRegistryRules()[ref.Context().RegistryStr()]; ok && r.Verification != nil {
+		verifications = append(verifications, r.Verification)
+	}
+	if len(verifications) == 0 {
+		return nil
+	}
+
+	base := ref.Context().Digest(digest.String())
+	var err error
+	for _, v := range verifications {
+		err = h.checkVerification(ctx, v, base, digest)
+		if err != nil {
+			err = fmt.Errorf("%w: %s: %w", ErrBaseNotVerified, base, err)
+			break
+		}
+	}
+
+	h.setBaseVerifiedCondition(action, err)
+	return err
+}
+
+func (h *Handler) checkVerification(ctx context.Context, v *v1alpha1.Verification, base name.Digest, digest v1.Hash) error {
+	if slices.Contains(v.Digests, digest.String()) {
+		return nil
+	}
+	if len(v.PublicKeys) == 0 {
+		return fmt.Errorf("digest is not allowed")
+	}
+
+	keys := make([][]byte, 0, len(v.PublicKeys))
+	for _, key := range v.PublicKeys {
+		keys = append(keys, []byte(key))
+	}
+	verifier, err := signer.NewVerifier(keys...)
+	if err != nil {
+		return err
+	}
+
+	sig, err := h.getBase(ctx, base.Context().Tag(signer.SignatureTag(digest)))
+	if err != nil {
+		return fmt.Errorf("get signature: %w", err)
+	}
+	image, ok := sig.(v1.Image)
+	if !ok {
+		return fmt.Errorf("signature is not an image")
+	}
+	return verifier.Verify(image, digest)
+}
+
+// setBaseVerifiedCondition sets the ConditionBaseImageVerified of the Image of the rule.
+func (h *Handler) setBaseVerifiedCondition(action *pattern.Action, verifyErr error) {
+	condition := v1alpha1.Condition{
+		Type:    ConditionBaseImageVerified,
+		Status:  v1alpha1.ConditionTrue,
+		Reason:  "Verified",
+		Message: "base image " + action.GetBaseImage() + " is verified",
+	}
+	if verifyErr != nil {
+		condition.Status = v1alpha1.ConditionFalse
+		condition.Reason = "VerificationFailed"
+		condition.Message = verifyErr.Error()
+	}
+	h.setImageCondition(action, condition)
+}
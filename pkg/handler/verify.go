@@ -0,0 +1,186 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+
+	"github.com/wzshiming/jitdi/pkg/builder"
+	"github.com/wzshiming/jitdi/pkg/storage"
+)
+
+// startVerifyBlobs re-hashes all blobs of the storage on the interval.
+func (h *Handler) startVerifyBlobs(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := h.verifyBlobs(ctx)
+			if err != nil {
+				slog.Error("verifyBlobs", "err", err)
+			}
+		}
+	}
+}
+
+// verifyBlobs re-hashes all blobs of the storage, and invalidates the corrupted ones.
+func (h *Handler) verifyBlobs(ctx context.Context) error {
+	v, ok := h.storage.(storage.Verifiable)
+	if !ok {
+		return nil
+	}
+
+	corrupted := map[v1.Hash]struct{}{}
+	err := v.WalkBlobs(ctx, func(digest v1.Hash) error {
+		err := v.VerifyBlob(ctx, digest)
+		if err != nil {
+			if errors.Is(err, storage.ErrBlobCorrupted) {
+				slog.Warn("quarantine blob", "err", err)
+				corrupted[digest] = struct{}{}
+				return nil
+			}
+			if errors.Is(err, storage.ErrNotFound) {
+				return nil
+			}
+			return err
+		}
+		h.verified.Store(digest, struct{}{})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return h.invalidate(ctx, v, corrupted)
+}
+
+// verifyBlob re-hashes the blob the first time it is served after restart.
+func (h *Handler) verifyBlob(ctx context.Context, digest v1.Hash) error {
+	v, ok := h.storage.(storage.Verifiable)
+	if !ok {
+		return nil
+	}
+	if _, ok := h.verified.Load(digest); ok {
+		return nil
+	}
+
+	err := v.VerifyBlob(ctx, digest)
+	if err != nil {
+		if errors.Is(err, storage.ErrBlobCorrupted) {
+			slog.Warn("quarantine blob", "err", err)
+			ierr := h.invalidate(ctx, v, map[v1.Hash]struct{}{digest: {}})
+			if ierr != nil {
+				slog.Error("invalidate", "err", ierr)
+			}
+		}
+		return err
+	}
+	h.verified.Store(digest, struct{}{})
+	return nil
+}
+
+// invalidate removes the links and the manifests referencing the corrupted or missing blobs,
+// so the next pull rebuilds them.
+func (h *Handler) invalidate(ctx context.Context, v storage.Verifiable, corrupted map[v1.Hash]struct{}) error {
+	for digest := range corrupted {
+		h.verified.Delete(digest)
+	}
+
+	if h.linkPath != "" && len(corrupted) != 0 {
+		err := filepath.WalkDir(h.linkPath, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || d.Name() != "link" {
+				return nil
+			}
+			digest, _, _, err := builder.DecodeLinkInfo(p)
+			if err == nil {
+				if _, ok := corrupted[digest]; !ok {
+					return nil
+				}
+			}
+			slog.Warn("invalidate link", "path", p, "digest", digest)
+			err = os.Remove(p)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return v.WalkManifests(ctx, func(repo, tag string, manifest *storage.Manifest) error {
+		if manifest != nil && !h.isBroken(ctx, repo, manifest, corrupted) {
+			return nil
+		}
+		slog.Warn("invalidate manifest", "repo", repo, "tag", tag)
+		err := h.storage.Delete(ctx, repo, tag)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+		return nil
+	})
+}
+
+// isBroken reports whether the manifest references a corrupted or missing blob,
+// the broken child manifests of an index are removed as well.
+func (h *Handler) isBroken(ctx context.Context, repo string, manifest *storage.Manifest, corrupted map[v1.Hash]struct{}) bool {
+	if _, ok := corrupted[manifest.Digest()]; ok {
+		return true
+	}
+
+	if manifest.MediaType.IsIndex() {
+		index, err := v1.ParseIndexManifest(bytes.NewReader(manifest.Data))
+		if err != nil {
+			return true
+		}
+		broken := false
+		for _, desc := range index.Manifests {
+			if _, ok := corrupted[desc.Digest]; ok {
+				broken = true
+				continue
+			}
+			child, err := h.storage.GetManifest(ctx, repo, desc.Digest.String())
+			if err != nil {
+				broken = true
+				continue
+			}
+			if h.isBroken(ctx, repo, child, corrupted) {
+				broken = true
+				err = h.storage.Delete(ctx, repo, desc.Digest.String())
+				if err != nil && !errors.Is(err, storage.ErrNotFound) {
+					slog.Error("delete manifest", "err", err, "repo", repo, "digest", desc.Digest)
+				}
+			}
+		}
+		return broken
+	}
+
+	m, err := v1.ParseManifest(bytes.NewReader(manifest.Data))
+	if err != nil {
+		return true
+	}
+	for _, desc := range append([]v1.Descriptor{m.Config}, m.Layers...) {
+		if _, ok := corrupted[desc.Digest]; ok {
+			return true
+		}
+		_, err := h.storage.StatBlob(ctx, repo, desc.Digest)
+		if errors.Is(err, storage.ErrNotFound) {
+			return true
+		}
+	}
+	return false
+}
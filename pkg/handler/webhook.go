@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/wzshiming/jitdi/pkg/apis/v1alpha1"
+	"github.com/wzshiming/jitdi/pkg/validation"
+)
+
+// maxReviewSize is the maximum size of an admission review,
+// the kube-apiserver limits the objects to 1.5MiB, and the review of an update has the old object as well.
+const maxReviewSize = 3 << 20
+
+// WebhookHandler returns the handler of the validating admission webhook,
+// it is served on its own listener requiring the client certificate of the kube-apiserver,
+// as the reviews have the matches of the other Images.
+func (h *Handler) WebhookHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/jitdi/validate" || r.Method != http.MethodPost {
+			_ = regErrNotFound.Write(w)
+			return
+		}
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			_ = regErrUnauthorized.Write(w)
+			return
+		}
+		h.validate(w, r)
+	})
+}
+
+// validate serves the validating admission webhook of the Images and the Registries.
+//
+//	POST /jitdi/validate
+func (h *Handler) validate(w http.ResponseWriter, r *http.Request) {
+	var review admissionv1.AdmissionReview
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxReviewSize)).Decode(&review)
+	if err != nil {
+		_ = regErrBadRequest(err).Write(w)
+		return
+	}
+	if review.Request == nil {
+		_ = regErrBadRequest(fmt.Errorf("no request of the admission review")).Write(w)
+		return
+	}
+
+	resp := &admissionv1.AdmissionResponse{
+		UID:     review.Request.UID,
+		Allowed: true,
+	}
+	warnings, err := h.review(review.Request)
+	if err != nil {
+		slog.Info("denied", "kind", review.Request.Kind.Kind, "name", review.Request.Name, "err", err)
+		resp.Allowed = false
+		resp.Result = &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    http.StatusUnprocessableEntity,
+			Reason:  metav1.StatusReasonInvalid,
+			Message: err.Error(),
+		}
+	}
+	resp.Warnings = warnings
+
+	review.Request = nil
+	review.Response = resp
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(review)
+}
+
+// review returns the warnings and the errors of the object of the request.
+func (h *Handler) review(req *admissionv1.AdmissionRequest) ([]string, error) {
+	if req.Operation == admissionv1.Delete {
+		return nil, nil
+	}
+
+	switch req.Kind.Kind {
+	case v1alpha1.ImageKind:
+		var image v1alpha1.Image
+		err := json.Unmarshal(req.Object.Raw, &image)
+		if err != nil {
+			return nil, err
+		}
+		warnings, err := validation.Image(&image.Spec)
+		if err != nil {
+			return warnings, err
+		}
+		return append(warnings, validation.Ambiguities(&image, h.listImages())...), nil
+	case v1alpha1.RegistryKind:
+		var registry v1alpha1.Registry
+		err := json.Unmarshal(req.Object.Raw, &registry)
+		if err != nil {
+			return nil, err
+		}
+		return nil, validation.Registry(registry.Name, &registry.Spec)
+	}
+	return nil, fmt.Errorf("unexpected Kind %q", req.Kind.Kind)
+}
+
+// listImages returns the Images of the config files and the Image resources.
+func (h *Handler) listImages() []*v1alpha1.Image {
+	images := append([]*v1alpha1.Image{}, h.imageConfig...)
+	if h.imageStore == nil {
+		return images
+	}
+	for _, item := range h.imageStore.List() {
+		images = append(images, item.(*v1alpha1.Image))
+	}
+	return images
+}
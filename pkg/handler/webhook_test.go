@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/wzshiming/jitdi/pkg/apis/v1alpha1"
+)
+
+func TestWebhookHandler(t *testing.T) {
+	h, err := NewHandler(WithCache(t.TempDir()), WithImageConfig([]*v1alpha1.Image{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "k8s"},
+			Spec:       v1alpha1.ImageSpec{Match: "k8s/{image}:{tag}", BaseImage: "docker.io/library/alpine"},
+		},
+	}))
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+
+	image, err := json.Marshal(&v1alpha1.Image{
+		ObjectMeta: metav1.ObjectMeta{Name: "other"},
+		Spec:       v1alpha1.ImageSpec{Match: "k8s/{name}:{version}", BaseImage: "docker.io/library/alpine"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	review, err := json.Marshal(&admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "1",
+			Kind:      metav1.GroupVersionKind{Kind: v1alpha1.ImageKind},
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: image},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     []byte
+		tls      *tls.ConnectionState
+		wantCode int
+	}{
+		{
+			name:     "client certificate",
+			method:   http.MethodPost,
+			path:     "/jitdi/validate",
+			body:     review,
+			tls:      verified,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "without TLS",
+			method:   http.MethodPost,
+			path:     "/jitdi/validate",
+			body:     review,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "without client certificate",
+			method:   http.MethodPost,
+			path:     "/jitdi/validate",
+			body:     review,
+			tls:      &tls.ConnectionState{},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "too large",
+			method:   http.MethodPost,
+			path:     "/jitdi/validate",
+			body:     []byte(`{"request":{"name":"` + strings.Repeat("a", maxReviewSize) + `"}}`),
+			tls:      verified,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "other path",
+			method:   http.MethodGet,
+			path:     "/v2/",
+			tls:      verified,
+			wantCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, bytes.NewReader(tt.body))
+			r.TLS = tt.tls
+			rec := httptest.NewRecorder()
+			h.WebhookHandler().ServeHTTP(rec, r)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d, %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var got admissionv1.AdmissionReview
+			err := json.NewDecoder(rec.Body).Decode(&got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Response == nil || !got.Response.Allowed || len(got.Response.Warnings) != 1 {
+				t.Errorf("response = %+v, want allowed with the ambiguity warned", got.Response)
+			}
+		})
+	}
+
+	// The registry listener does not serve the reviews.
+	r := httptest.NewRequest(http.MethodPost, "/jitdi/validate", bytes.NewReader(review))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if rec.Code == http.StatusOK {
+		t.Errorf("ServeHTTP() code = %d, want the review not served", rec.Code)
+	}
+}
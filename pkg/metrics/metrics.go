@@ -0,0 +1,245 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "jitdi"
+
+// The types of the sources of the fetched bytes.
+const (
+	SourceHTTP     = "http"
+	SourceLocal    = "local"
+	SourceOllama   = "ollama"
+	SourceRegistry = "registry"
+)
+
+// The caches of the layers.
+const (
+	// CacheBlob is the blobs of the storage.
+	CacheBlob = "blob"
+	// CacheLink is the links of the file layers to their blobs.
+	CacheLink = "link"
+)
+
+// Registry is the registry of the metrics of jitdi.
+var Registry = prometheus.NewRegistry()
+
+var (
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of the HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of the HTTP requests by route and method.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 4, 10),
+	}, []string{"route", "method"})
+
+	builds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "builds_total",
+		Help:      "Number of the builds by rule and result, success or failure.",
+	}, []string{"rule", "result"})
+
+	buildsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "builds_in_flight",
+		Help:      "Number of the builds in progress.",
+	})
+
+	buildDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "build_duration_seconds",
+		Help:      "Duration of the builds by rule.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 4, 10),
+	}, []string{"rule"})
+
+	sourceBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "source_bytes_total",
+		Help:      "Bytes fetched by the builds by source type, http, local, ollama or registry.",
+	}, []string{"source"})
+
+	cacheLayers = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_layers_total",
+		Help:      "Number of the layers looked up in the caches by cache and result, hit or miss.",
+	}, []string{"cache", "result"})
+
+	cacheUsageFunc atomic.Pointer[func() float64]
+
+	cacheUsage = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cache_disk_usage_bytes",
+		Help:      "Bytes used by the cache directory on disk.",
+	}, func() float64 {
+		f := cacheUsageFunc.Load()
+		if f == nil {
+			return 0
+		}
+		return (*f)()
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requests,
+		requestDuration,
+		builds,
+		buildsInFlight,
+		buildDuration,
+		sourceBytes,
+		cacheLayers,
+		cacheUsage,
+	)
+}
+
+// Handler returns the handler serving the metrics.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// StartBuild records a build of the rule is started, the returned func records it is finished with the error.
+func StartBuild(rule string) func(err error) {
+	start := time.Now()
+	buildsInFlight.Inc()
+	return func(err error) {
+		buildsInFlight.Dec()
+		result := "success"
+		if err != nil {
+			result = "failure"
+		}
+		builds.WithLabelValues(rule, result).Inc()
+		buildDuration.WithLabelValues(rule).Observe(time.Since(start).Seconds())
+	}
+}
+
+// ObserveCache records a lookup of a layer in the cache.
+func ObserveCache(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheLayers.WithLabelValues(cache, result).Inc()
+}
+
+// AddSourceBytes adds the bytes fetched from the source type.
+func AddSourceBytes(source string, n int) {
+	sourceBytes.WithLabelValues(source).Add(float64(n))
+}
+
+// SetCacheUsage sets the func returning the bytes used by the cache directory, it is called on each scrape.
+func SetCacheUsage(f func() float64) {
+	cacheUsageFunc.Store(&f)
+}
+
+// InstrumentHandler records the requests of the handler by the route of the requests,
+// the route should be a template of the path to keep the cardinality low.
+func InstrumentHandler(route func(r *http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(rw, r)
+
+		rt := route(r)
+		method := normalizeMethod(r.Method)
+		requests.WithLabelValues(rt, method, strconv.Itoa(rw.code)).Inc()
+		requestDuration.WithLabelValues(rt, method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// normalizeMethod returns the method for the label, the non-standard methods are "other" to keep the cardinality low.
+func normalizeMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions, http.MethodConnect, http.MethodTrace:
+		return method
+	}
+	return "other"
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	code        int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.code = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// CountReader returns the reader counting the bytes read as fetched from the source type.
+func CountReader(source string, rc io.ReadCloser) io.ReadCloser {
+	return &countReader{
+		ReadCloser: rc,
+		source:     source,
+	}
+}
+
+type countReader struct {
+	io.ReadCloser
+	source string
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if n > 0 {
+		AddSourceBytes(c.source, n)
+	}
+	return n, err
+}
+
+// CountTransport returns the transport counting the bytes of the response bodies as fetched from the source type.
+func CountTransport(source string, rt http.RoundTripper) http.RoundTripper {
+	return &countTransport{
+		source: source,
+		rt:     rt,
+	}
+}
+
+type countTransport struct {
+	source string
+	rt     http.RoundTripper
+}
+
+func (c *countTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := c.rt.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = CountReader(c.source, resp.Body)
+	return resp, nil
+}
@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInstrumentHandler(t *testing.T) {
+	handler := InstrumentHandler(func(r *http.Request) string {
+		return "/test"
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test/foo", nil))
+
+	got := testutil.ToFloat64(requests.WithLabelValues("/test", http.MethodGet, "404"))
+	if got != 1 {
+		t.Fatalf("requests got %v, want 1", got)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("FOO", "/test/foo", nil))
+
+	got = testutil.ToFloat64(requests.WithLabelValues("/test", "other", "404"))
+	if got != 1 {
+		t.Fatalf("other requests got %v, want 1", got)
+	}
+	if got := testutil.CollectAndCount(requests); got != 2 {
+		t.Fatalf("request series got %v, want 2", got)
+	}
+}
+
+func TestStartBuild(t *testing.T) {
+	StartBuild("test")(nil)
+	done := StartBuild("test")
+	if got := testutil.ToFloat64(buildsInFlight); got != 1 {
+		t.Fatalf("builds in flight got %v, want 1", got)
+	}
+	done(errors.New("failed"))
+
+	if got := testutil.ToFloat64(buildsInFlight); got != 0 {
+		t.Fatalf("builds in flight got %v, want 0", got)
+	}
+	if got := testutil.ToFloat64(builds.WithLabelValues("test", "success")); got != 1 {
+		t.Fatalf("success builds got %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(builds.WithLabelValues("test", "failure")); got != 1 {
+		t.Fatalf("failure builds got %v, want 1", got)
+	}
+}
+
+func TestCountReader(t *testing.T) {
+	rc := CountReader("test", io.NopCloser(strings.NewReader("hello")))
+	_, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+
+	if got := testutil.ToFloat64(sourceBytes.WithLabelValues("test")); got != 5 {
+		t.Fatalf("source bytes got %v, want 5", got)
+	}
+}
+
+func TestCacheUsage(t *testing.T) {
+	SetCacheUsage(func() float64 {
+		return 42
+	})
+	if got := testutil.ToFloat64(cacheUsage); got != 42 {
+		t.Fatalf("cache usage got %v, want 42", got)
+	}
+}
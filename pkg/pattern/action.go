@@ -1,7 +1,7 @@
 package pattern
 
 import (
-	"strings"
+	"maps"
 
 	"github.com/google/go-containerregistry/pkg/v1"
 
@@ -18,6 +18,26 @@ func (r *Action) GetMatchImage() string {
 	return r.match
 }
 
+// GetRuleMatch returns the match of the rule, it identifies the rule.
+func (r *Action) GetRuleMatch() string {
+	return r.rule.raw
+}
+
+// GetLimits returns the limits of the rule, it is nil if the rule has no limits.
+func (r *Action) GetLimits() *v1alpha1.Limits {
+	return r.rule.limits
+}
+
+// GetVerification returns the verification of the base images of the rule, it is nil if the rule has no verification.
+func (r *Action) GetVerification() *v1alpha1.Verification {
+	return r.rule.verification
+}
+
+// GetParams returns the parameters matched from the image.
+func (r *Action) GetParams() map[string]string {
+	return maps.Clone(r.params)
+}
+
 func (r *Action) GetBaseImage() string {
 	return replaceWithParams(r.rule.baseImage, r.params)
 }
@@ -31,7 +51,7 @@ func (r *Action) GetPlatforms() []v1alpha1.Platform {
 
 func (r *Action) GetMutates(p *v1.Platform) []v1alpha1.Mutate {
 	mutates := r.rule.mutates
-	params := r.params
+	params := maps.Clone(r.params)
 	if p == nil {
 		params["GOOS"] = "linux"
 		params["GOARCH"] = "amd64"
@@ -39,14 +59,7 @@ func (r *Action) GetMutates(p *v1.Platform) []v1alpha1.Mutate {
 		params["GOOS"] = p.OS
 		params["GOARCH"] = p.Architecture
 	}
-	return replaceMutateWithParams(mutates, r.params)
-}
-
-func replaceWithParams(s string, params map[string]string) string {
-	for k, v := range params {
-		s = strings.ReplaceAll(s, "{"+k+"}", v)
-	}
-	return s
+	return replaceMutateWithParams(mutates, params)
 }
 
 func replaceMutateWithParams(m []v1alpha1.Mutate, params map[string]string) []v1alpha1.Mutate {
@@ -57,7 +70,7 @@ func replaceMutateWithParams(m []v1alpha1.Mutate, params map[string]string) []v1
 				File: &v1alpha1.File{
 					Source:      replaceWithParams(v.File.Source, params),
 					Destination: replaceWithParams(v.File.Destination, params),
-					Mode:        v.File.Mode,
+					Mode:        replaceWithParams(v.File.Mode, params),
 				},
 			})
 		} else if v.Ollama != nil {
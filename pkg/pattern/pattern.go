@@ -2,6 +2,8 @@ package pattern
 
 import (
 	"fmt"
+	"regexp"
+	"slices"
 	"strings"
 )
 
@@ -9,6 +11,33 @@ type segment struct {
 	s string // literal or parameter name
 
 	wildcard bool
+
+	// constraint of the parameter, "" and "**" are any string,
+	// "*" is any string without "/", otherwise it is a regular expression.
+	constraint string
+	re         *regexp.Regexp
+}
+
+// accept reports whether the value satisfies the constraint of the parameter.
+func (seg *segment) accept(v string) bool {
+	switch seg.constraint {
+	case "", "**":
+		return true
+	case "*":
+		return !strings.Contains(v, "/")
+	}
+	return seg.re.MatchString(v)
+}
+
+// specificity is the order of the constraints in sorting, the higher is the more specific.
+func (seg *segment) specificity() int {
+	switch seg.constraint {
+	case "", "**":
+		return 0
+	case "*":
+		return 1
+	}
+	return 2
 }
 
 type pattern struct {
@@ -16,15 +45,22 @@ type pattern struct {
 }
 
 func parsePattern(s string) (p *pattern, err error) {
-	if strings.Index(s, ":") == -1 {
-		s += ":latest"
-	}
-
 	segs, err := parseSegments(s)
 	if err != nil {
 		return nil, err
 	}
 
+	hasTag := slices.ContainsFunc(segs, func(seg segment) bool {
+		return !seg.wildcard && strings.Contains(seg.s, ":")
+	})
+	if !hasTag {
+		if n := len(segs); n != 0 && !segs[n-1].wildcard {
+			segs[n-1].s += ":latest"
+		} else {
+			segs = append(segs, segment{s: ":latest"})
+		}
+	}
+
 	return &pattern{segs}, nil
 }
 
@@ -47,48 +83,111 @@ func parseSegments(s string) ([]segment, error) {
 		if off == len(s) {
 			break
 		}
-		// Find the next '}'.
+		// Find the matching '}', the constraint may contain braces such as "[0-9]{2}".
 		start = off
-		for off < len(s) && s[off] != '}' {
-			off++
+		depth := 0
+		for ; off < len(s); off++ {
+			if s[off] == '{' {
+				depth++
+			} else if s[off] == '}' {
+				depth--
+				if depth == 0 {
+					break
+				}
+			}
 		}
 		if off == len(s) {
 			return nil, fmt.Errorf("unmatched '{' in %q", s)
 		}
-		if off == start+1 {
+		name, constraint, _ := strings.Cut(s[start+1:off], ":")
+		if name == "" {
 			return nil, fmt.Errorf("empty '{}' in %q", s)
 		}
-		segs = append(segs, segment{s: s[start+1 : off], wildcard: true})
+		seg := segment{s: name, wildcard: true, constraint: constraint}
+		if seg.specificity() == 2 {
+			re, err := regexp.Compile(`^(?:` + constraint + `)$`)
+			if err != nil {
+				return nil, fmt.Errorf("invalid constraint of %q in %q: %w", name, s, err)
+			}
+			seg.re = re
+		}
+		segs = append(segs, seg)
 		off++
-
 	}
 	return segs, nil
 }
 
+// maxMatchLength is the maximum length of the strings matched with the patterns,
+// the references are limited to 255 bytes of the name and 128 bytes of the tag.
+const maxMatchLength = 512
+
 func matchSegments(segs []segment, s string) (map[string]string, bool) {
+	if len(s) > maxMatchLength {
+		return nil, false
+	}
 	params := map[string]string{}
-	off := 0
-	for i, seg := range segs {
-		if !seg.wildcard {
-			if !strings.HasPrefix(s[off:], seg.s) {
-				return nil, false
-			}
-			off += len(seg.s)
-			continue
+	m := &matcher{
+		failed: map[[2]int]bool{},
+		params: params,
+	}
+	return params, m.matchFrom(segs, s)
+}
+
+// matcher matches a string with the segments.
+type matcher struct {
+	// failed is the rest of the segments and the string not matched by their lengths,
+	// so each of them is tried once instead of exponential times by the backtracking.
+	failed map[[2]int]bool
+	params map[string]string
+}
+
+// matchFrom matches the rest of the string with the rest of the segments,
+// each parameter tries the shortest value first and backtracks when the rest does not match.
+func (m *matcher) matchFrom(segs []segment, s string) bool {
+	if len(segs) == 0 {
+		return s == ""
+	}
+	key := [2]int{len(segs), len(s)}
+	if m.failed[key] {
+		return false
+	}
+	if m.matchSegment(segs, s) {
+		return true
+	}
+	m.failed[key] = true
+	return false
+}
+
+func (m *matcher) matchSegment(segs []segment, s string) bool {
+	seg := &segs[0]
+	if !seg.wildcard {
+		rest, ok := strings.CutPrefix(s, seg.s)
+		return ok && m.matchFrom(segs[1:], rest)
+	}
+
+	if len(segs) == 1 {
+		if !seg.accept(s) {
+			return false
+		}
+		m.params[seg.s] = s
+		return true
+	}
+
+	for end := 0; end <= len(s); end++ {
+		if end > 0 && seg.constraint == "*" && s[end-1] == '/' {
+			break
 		}
-		if i == len(segs)-1 {
-			params[seg.s] = s[off:]
-			return params, true
+		// The rest is checked first, it is memoized and cheaper than the regular expression.
+		if !m.matchFrom(segs[1:], s[end:]) {
+			continue
 		}
-		end := off
-		nextSeg := segs[i+1]
-		for end < len(s) && !strings.HasPrefix(s[end:], nextSeg.s) {
-			end++
+		v := s[:end]
+		if seg.accept(v) {
+			m.params[seg.s] = v
+			return true
 		}
-		params[seg.s] = s[off:end]
-		off = end
 	}
-	return params, off == len(s)
+	return false
 }
 
 func patternLess(p1, p2 *pattern) bool {
@@ -110,7 +209,108 @@ func patternLess(p1, p2 *pattern) bool {
 				return len(p1.segments[i].s) > len(p2.segments[i].s)
 			}
 		}
+		if p1.segments[i].wildcard && p2.segments[i].wildcard {
+			if a, b := p1.segments[i].specificity(), p2.segments[i].specificity(); a != b {
+				return a > b
+			}
+		}
 	}
 
 	return len(p1.segments) > len(p2.segments)
 }
+
+// patternOverlap reports whether a string may match both patterns,
+// the parameters constrained by the regular expressions are taken as any string.
+func patternOverlap(p1, p2 *pattern) bool {
+	t1, t2 := p1.tokens(), p2.tokens()
+	seen := map[[2]int]bool{}
+	var walk func(i, j int) bool
+	walk = func(i, j int) bool {
+		if i == len(t1) && j == len(t2) {
+			return true
+		}
+		k := [2]int{i, j}
+		if seen[k] {
+			return false
+		}
+		seen[k] = true
+
+		// A parameter matches the empty string, or the next byte of the other pattern.
+		if i < len(t1) && t1[i].wildcard && walk(i+1, j) {
+			return true
+		}
+		if j < len(t2) && t2[j].wildcard && walk(i, j+1) {
+			return true
+		}
+		if i == len(t1) || j == len(t2) {
+			return false
+		}
+		a, b := t1[i], t2[j]
+		switch {
+		case !a.wildcard && !b.wildcard:
+			return a.c == b.c && walk(i+1, j+1)
+		case a.wildcard && !b.wildcard:
+			return a.accept(b.c) && walk(i, j+1)
+		case !a.wildcard && b.wildcard:
+			return b.accept(a.c) && walk(i+1, j)
+		}
+		return false
+	}
+	return walk(0, 0)
+}
+
+// token is a byte of the literals or a parameter of a pattern.
+type token struct {
+	c        byte
+	wildcard bool
+	noSlash  bool
+}
+
+func (t token) accept(c byte) bool {
+	return !t.noSlash || c != '/'
+}
+
+func (p *pattern) tokens() []token {
+	var ts []token
+	for _, seg := range p.segments {
+		if seg.wildcard {
+			ts = append(ts, token{wildcard: true, noSlash: seg.constraint == "*"})
+			continue
+		}
+		for i := 0; i < len(seg.s); i++ {
+			ts = append(ts, token{c: seg.s[i]})
+		}
+	}
+	return ts
+}
+
+// params returns the names of the parameters of the pattern.
+func (p *pattern) params() map[string]bool {
+	params := map[string]bool{}
+	for _, seg := range p.segments {
+		if seg.wildcard {
+			params[seg.s] = true
+		}
+	}
+	return params
+}
+
+// Matcher matches the whole string with a pattern such as "k8s/{name}", without the default tag of the match of rules.
+type Matcher struct {
+	segments []segment
+}
+
+// NewMatcher returns the Matcher of the pattern.
+func NewMatcher(s string) (*Matcher, error) {
+	segs, err := parseSegments(s)
+	if err != nil {
+		return nil, err
+	}
+	return &Matcher{segs}, nil
+}
+
+// Match reports whether the string matches the pattern.
+func (m *Matcher) Match(s string) bool {
+	_, ok := matchSegments(m.segments, s)
+	return ok
+}
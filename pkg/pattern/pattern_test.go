@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
+	"time"
 )
 
 func Test_parseSegments(t *testing.T) {
@@ -191,6 +193,149 @@ func Test_patternLess(t *testing.T) {
 	}
 }
 
-func Test_patternSort(t *testing.T) {
+func Test_patternMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    map[string]string
+		want1   bool
+	}{
+		{
+			pattern: "llama-cpp/{model}:{size:[0-9]+}b-{quant:Q2_K|Q4_0|Q8_0}",
+			s:       "llama-cpp/llama-2:13b-Q4_0",
+			want: map[string]string{
+				"model": "llama-2",
+				"size":  "13",
+				"quant": "Q4_0",
+			},
+			want1: true,
+		},
+		{
+			pattern: "llama-cpp/{model}:{size:[0-9]+}b-{quant:Q2_K|Q4_0|Q8_0}",
+			s:       "llama-cpp/llama-2:13b-Q5_1",
+			want1:   false,
+		},
+		{
+			pattern: "llama-cpp/{model}:{size:[0-9]+}b",
+			s:       "llama-cpp/llama-2:xb",
+			want1:   false,
+		},
+		{
+			// Backtracks the first "-" of the name.
+			pattern: "{name}-{variant:full|light}:{tag}",
+			s:       "llama-cpp-full:v1",
+			want: map[string]string{
+				"name":    "llama-cpp",
+				"variant": "full",
+				"tag":     "v1",
+			},
+			want1: true,
+		},
+		{
+			pattern: "files/{path:**}/{file:*}:{tag}",
+			s:       "files/a/b/c/d.txt:v1",
+			want: map[string]string{
+				"path": "a/b/c",
+				"file": "d.txt",
+				"tag":  "v1",
+			},
+			want1: true,
+		},
+		{
+			pattern: "files/{file:*}:{tag}",
+			s:       "files/a/b:v1",
+			want1:   false,
+		},
+		{
+			pattern: "k8s/{version:v[0-9]{1,2}\\.[0-9]+}",
+			s:       "k8s/v1.29:latest",
+			want: map[string]string{
+				"version": "v1.29",
+			},
+			want1: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			p, err := parsePattern(tt.pattern)
+			if err != nil {
+				t.Fatalf("parsePattern() error = %v", err)
+			}
+			got, got1 := p.Match(tt.s)
+			if got1 != tt.want1 {
+				t.Fatalf("Match() got1 = %v, want %v", got1, tt.want1)
+			}
+			if got1 && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Match() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
 
+func Test_patternMatchWorstCase(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+	}{
+		{
+			pattern: "{a}-{b}-{c}-{d}-{e}-{f}-{g}-{h}-x:{tag}",
+			s:       strings.Repeat("-", 500) + ":v1",
+		},
+		{
+			pattern: "{a}/{b}/{c}/{d}/{e}/{f}/{g}/{h}/x:{tag}",
+			s:       strings.Repeat("/", 500) + ":v1",
+		},
+		{
+			pattern: "{a:[a-]+}-{b:[a-]+}-{c:[a-]+}-{d:[a-]+}-x:{tag}",
+			s:       strings.Repeat("a-", 250) + ":v1",
+		},
+		{
+			pattern: "{a:[0-9]+}{b}-{c}:{tag}",
+			s:       strings.Repeat("-", 500) + ":v1",
+		},
+		{
+			pattern: "{a}-{b}-x:{tag}",
+			s:       strings.Repeat("-", 100000),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			p, err := parsePattern(tt.pattern)
+			if err != nil {
+				t.Fatalf("parsePattern() error = %v", err)
+			}
+			start := time.Now()
+			_, ok := p.Match(tt.s)
+			if ok {
+				t.Fatalf("Match() matched")
+			}
+			if d := time.Since(start); d > time.Second {
+				t.Errorf("Match() took %s", d)
+			}
+		})
+	}
+}
+
+func Test_patternLessConstraints(t *testing.T) {
+	list := []string{
+		"llama-cpp/{model}:{tag}",
+		"llama-cpp/{model}:{tag:*}",
+		"llama-cpp/{model}:{quant:Q2_K|Q4_0}",
+		"llama-cpp/{model:**}:{tag}",
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		a, _ := parsePattern(list[i])
+		b, _ := parsePattern(list[j])
+		return patternLess(a, b)
+	})
+
+	want := []string{
+		"llama-cpp/{model}:{quant:Q2_K|Q4_0}",
+		"llama-cpp/{model}:{tag:*}",
+		"llama-cpp/{model}:{tag}",
+		"llama-cpp/{model:**}:{tag}",
+	}
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("patternLess() got = %#v, want %#v", list, want)
+	}
 }
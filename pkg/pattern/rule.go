@@ -1,14 +1,28 @@
 package pattern
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/wzshiming/jitdi/pkg/apis/v1alpha1"
 )
 
+// ErrNotMatch is returned when the image does not match the pattern of the rule.
+var ErrNotMatch = errors.New("pattern does not match")
+
+// ErrUndefinedParam is returned when a template references a parameter not in the match.
+var ErrUndefinedParam = errors.New("undefined param")
+
 type Rule struct {
+	raw       string
 	match     *pattern
 	baseImage string
 	mutates   []v1alpha1.Mutate
 	platforms []v1alpha1.Platform
+	limits    *v1alpha1.Limits
+	sources   *sources
+
+	verification *v1alpha1.Verification
 }
 
 func NewRule(conf *v1alpha1.ImageSpec) (*Rule, error) {
@@ -16,27 +30,118 @@ func NewRule(conf *v1alpha1.ImageSpec) (*Rule, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = checkTemplates(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	var srcs *sources
+	if conf.Sources != nil {
+		srcs, err = newSources(conf.Sources)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return &Rule{
+		raw:       conf.Match,
 		match:     pat,
 		baseImage: conf.BaseImage,
 		mutates:   conf.Mutates,
 		platforms: conf.Platforms,
+		limits:    conf.Limits,
+		sources:   srcs,
+
+		verification: conf.Verification,
 	}, nil
 }
 
 func (r *Rule) Match(image string) (*Action, bool) {
+	action, err := r.Explain(image)
+	return action, err == nil
+}
+
+// Explain is Match returning the reason why the rule does not match the image,
+// it is ErrNotMatch or ErrParamNotAllowed.
+func (r *Rule) Explain(image string) (*Action, error) {
 	params, ok := r.match.Match(image)
 	if !ok {
-		return nil, false
+		return nil, ErrNotMatch
+	}
+	if r.sources != nil {
+		err := r.sources.checkParams(params)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return &Action{
 		params: params,
 		match:  image,
 		rule:   r,
-	}, true
+	}, nil
+}
+
+// String returns the match of the rule.
+func (r *Rule) String() string {
+	return r.raw
 }
 
 func (r *Rule) LessThan(o *Rule) bool {
 	return patternLess(r.match, o.match)
 }
+
+// Ambiguous reports whether the rules may match the same image and neither is more specific than the other,
+// so which one is used is undefined.
+func (r *Rule) Ambiguous(o *Rule) bool {
+	return !r.LessThan(o) && !o.LessThan(r) && patternOverlap(r.match, o.match)
+}
+
+// CheckParams returns an error if a template references a parameter without a default,
+// which is neither in the match nor GOOS and GOARCH.
+func (r *Rule) CheckParams() error {
+	params := r.match.params()
+	params["GOOS"] = true
+	params["GOARCH"] = true
+
+	templates := []string{r.baseImage}
+	for _, m := range r.mutates {
+		if m.File != nil {
+			templates = append(templates, m.File.Source, m.File.Destination, m.File.Mode)
+		}
+		if m.Ollama != nil {
+			templates = append(templates, m.Ollama.Model, m.Ollama.ModelName, m.Ollama.WorkDir)
+		}
+	}
+
+	var errs []error
+	for _, t := range templates {
+		walkExpressions(t, func(expr string) (string, bool) {
+			e, err := parseExpression(expr)
+			if err == nil && !e.hasDefault && !params[e.name] {
+				errs = append(errs, fmt.Errorf("%w: %q in %q", ErrUndefinedParam, e.name, t))
+			}
+			return "", false
+		})
+	}
+	return errors.Join(errs...)
+}
+
+// checkTemplates returns an error if a template of the spec is invalid.
+func checkTemplates(conf *v1alpha1.ImageSpec) error {
+	templates := []string{conf.BaseImage}
+	for _, m := range conf.Mutates {
+		if m.File != nil {
+			templates = append(templates, m.File.Source, m.File.Destination, m.File.Mode)
+		}
+		if m.Ollama != nil {
+			templates = append(templates, m.Ollama.Model, m.Ollama.ModelName, m.Ollama.WorkDir)
+		}
+	}
+	for _, t := range templates {
+		err := checkTemplate(t)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package pattern
+
+import (
+	"errors"
+	"maps"
+	"testing"
+
+	"github.com/wzshiming/jitdi/pkg/apis/v1alpha1"
+)
+
+func TestRuleExplain(t *testing.T) {
+	rule, err := NewRule(&v1alpha1.ImageSpec{
+		Match:     "k8s/{image}:{tag}",
+		BaseImage: "docker.io/library/alpine",
+		Sources: &v1alpha1.Sources{
+			Params: map[string]string{"tag": `v[0-9]+\.[0-9]+\.[0-9]+`},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewRule() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		image      string
+		wantParams map[string]string
+		wantErr    error
+	}{
+		{
+			name:       "matched",
+			image:      "k8s/kubectl:v1.29.3",
+			wantParams: map[string]string{"image": "kubectl", "tag": "v1.29.3"},
+		},
+		{
+			name:    "pattern not matched",
+			image:   "ollama/llama2:7b",
+			wantErr: ErrNotMatch,
+		},
+		{
+			name:    "param not allowed",
+			image:   "k8s/kubectl:latest",
+			wantErr: ErrParamNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action, err := rule.Explain(tt.image)
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Fatalf("Explain() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := action.GetParams(); !maps.Equal(got, tt.wantParams) {
+				t.Errorf("Explain() params = %v, want %v", got, tt.wantParams)
+			}
+		})
+	}
+}
+
+func TestRuleAmbiguous(t *testing.T) {
+	tests := []struct {
+		name   string
+		match1 string
+		match2 string
+		want   bool
+	}{
+		{
+			name:   "same",
+			match1: "k8s/{image}:{tag}",
+			match2: "k8s/{name}:{version}",
+			want:   true,
+		},
+		{
+			name:   "different prefixes",
+			match1: "a/{image}:{tag}",
+			match2: "b/{image}:{tag}",
+		},
+		{
+			name:   "more specific",
+			match1: "k8s/{image}:{tag}",
+			match2: "k8s/{image:*}:{tag}",
+		},
+		{
+			name:   "overlapped but ordered",
+			match1: "mirror/{image}:{tag}",
+			match2: "{registry}/alpine:{tag}",
+		},
+		{
+			name:   "slash not matched",
+			match1: "files/{name:*}/{file:*}:{tag}",
+			match2: "files/{file:*}:{tag}-x/y",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r1, err := NewRule(&v1alpha1.ImageSpec{Match: tt.match1})
+			if err != nil {
+				t.Fatalf("NewRule() error = %v", err)
+			}
+			r2, err := NewRule(&v1alpha1.ImageSpec{Match: tt.match2})
+			if err != nil {
+				t.Fatalf("NewRule() error = %v", err)
+			}
+			if got := r1.Ambiguous(r2); got != tt.want {
+				t.Errorf("Ambiguous() = %v, want %v", got, tt.want)
+			}
+			if got := r2.Ambiguous(r1); got != tt.want {
+				t.Errorf("Ambiguous() reversed = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuleCheckParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    v1alpha1.ImageSpec
+		wantErr error
+	}{
+		{
+			name: "defined",
+			spec: v1alpha1.ImageSpec{
+				Match:     "k8s/{image}:{tag}",
+				BaseImage: "docker.io/library/alpine:{variant=latest}",
+				Mutates: []v1alpha1.Mutate{
+					{File: &v1alpha1.File{Source: "https://dl.k8s.io/{tag}/bin/{GOOS}/{GOARCH}/{image|lower}"}},
+				},
+			},
+		},
+		{
+			name: "undefined",
+			spec: v1alpha1.ImageSpec{
+				Match:     "k8s/{image}:{tag}",
+				BaseImage: "docker.io/library/alpine",
+				Mutates: []v1alpha1.Mutate{
+					{File: &v1alpha1.File{Source: "https://dl.k8s.io/{version}/bin/{image}"}},
+				},
+			},
+			wantErr: ErrUndefinedParam,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := NewRule(&tt.spec)
+			if err != nil {
+				t.Fatalf("NewRule() error = %v", err)
+			}
+			err = rule.CheckParams()
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("CheckParams() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
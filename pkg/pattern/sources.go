@@ -0,0 +1,209 @@
+package pattern
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+
+	"github.com/wzshiming/jitdi/pkg/apis/v1alpha1"
+)
+
+// ErrSourceNotAllowed is returned when a source is not allowed by the rule.
+var ErrSourceNotAllowed = errors.New("source not allowed")
+
+// ErrParamNotAllowed is returned when a parameter matched from the image is not allowed by the sources of the rule.
+var ErrParamNotAllowed = errors.New("param not allowed")
+
+// sources is the compiled constraints of the parameters and the sources.
+type sources struct {
+	params     map[string]*regexp.Regexp
+	schemes    []string
+	hosts      []*Matcher
+	localRoots []string
+}
+
+func newSources(conf *v1alpha1.Sources) (*sources, error) {
+	s := &sources{
+		schemes: conf.Schemes,
+	}
+
+	if len(conf.Params) != 0 {
+		s.params = map[string]*regexp.Regexp{}
+		for k, v := range conf.Params {
+			re, err := regexp.Compile(`^(?:` + v + `)$`)
+			if err != nil {
+				return nil, fmt.Errorf("param %q: %w", k, err)
+			}
+			s.params[k] = re
+		}
+	}
+
+	for _, host := range conf.Hosts {
+		m, err := NewMatcher(host)
+		if err != nil {
+			return nil, fmt.Errorf("host %q: %w", host, err)
+		}
+		s.hosts = append(s.hosts, m)
+	}
+
+	for _, root := range conf.LocalRoots {
+		p, err := resolvePath(root)
+		if err != nil {
+			return nil, fmt.Errorf("local root %q: %w", root, err)
+		}
+		s.localRoots = append(s.localRoots, p)
+	}
+	return s, nil
+}
+
+// checkParams returns an error if a parameter does not match its regular expression.
+func (s *sources) checkParams(params map[string]string) error {
+	keys := make([]string, 0, len(s.params))
+	for k := range s.params {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		if !s.params[k].MatchString(params[k]) {
+			return fmt.Errorf("%w: %s=%q does not match %s", ErrParamNotAllowed, k, params[k], s.params[k])
+		}
+	}
+	return nil
+}
+
+func (s *sources) allowHost(host, hostname string) bool {
+	if len(s.hosts) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(s.hosts, func(m *Matcher) bool {
+		return m.Match(host) || m.Match(hostname)
+	})
+}
+
+func (s *sources) allowScheme(scheme string) bool {
+	return len(s.schemes) == 0 || slices.Contains(s.schemes, scheme)
+}
+
+func (s *sources) checkImage(image string) error {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return err
+	}
+	registry := ref.Context().RegistryStr()
+	hostname := strings.Split(registry, ":")[0]
+	if registry == name.DefaultRegistry {
+		// The docker.io is normalized to index.docker.io.
+		hostname = "docker.io"
+	}
+	if !s.allowHost(registry, hostname) {
+		return fmt.Errorf("%w: registry %q of %q", ErrSourceNotAllowed, registry, image)
+	}
+	return nil
+}
+
+func (s *sources) checkFile(source string) error {
+	u, err := url.Parse(source)
+	if err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		if !s.allowScheme(u.Scheme) {
+			return fmt.Errorf("%w: scheme of %q", ErrSourceNotAllowed, source)
+		}
+		if !s.allowHost(u.Host, u.Hostname()) {
+			return fmt.Errorf("%w: host of %q", ErrSourceNotAllowed, source)
+		}
+		return nil
+	}
+
+	if !s.allowScheme("file") {
+		return fmt.Errorf("%w: local path %q", ErrSourceNotAllowed, source)
+	}
+	if len(s.localRoots) == 0 {
+		return nil
+	}
+	p, err := resolvePath(source)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(s.localRoots, func(root string) bool {
+		return p == root || strings.HasPrefix(p, root+string(filepath.Separator))
+	}) {
+		return fmt.Errorf("%w: %q is not in the local roots", ErrSourceNotAllowed, source)
+	}
+	return nil
+}
+
+// resolvePath returns the absolute path with the symlinks resolved, the path not existing is only cleaned.
+func resolvePath(p string) (string, error) {
+	p, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	r, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return p, nil
+		}
+		return "", err
+	}
+	return r, nil
+}
+
+// hasDotDot reports whether the path of the source contains a ".." element.
+func hasDotDot(source string) bool {
+	if u, err := url.Parse(source); err == nil && u.Scheme != "" {
+		source = u.Path
+	}
+	return slices.Contains(strings.Split(filepath.ToSlash(source), "/"), "..")
+}
+
+// CheckBaseImage returns an error if the base image is not allowed by the rule.
+func (r *Action) CheckBaseImage() error {
+	if r.rule.sources == nil {
+		return nil
+	}
+	return r.rule.sources.checkImage(r.GetBaseImage())
+}
+
+// CheckMutates returns an error if a source of the mutates is not allowed by the rule,
+// the path traversal of the sources is always rejected.
+func (r *Action) CheckMutates(mutates []v1alpha1.Mutate) error {
+	for _, m := range mutates {
+		if m.Ollama != nil && r.rule.sources != nil {
+			err := r.rule.sources.checkImage(m.Ollama.Model)
+			if err != nil {
+				return err
+			}
+		}
+		if m.File == nil {
+			continue
+		}
+		if hasDotDot(m.File.Source) {
+			return fmt.Errorf("%w: path traversal in %q", ErrSourceNotAllowed, m.File.Source)
+		}
+		if r.rule.sources == nil {
+			continue
+		}
+		err := r.rule.sources.checkFile(m.File.Source)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckRedirect returns an error if the redirect of a remote file source is not allowed by the rule.
+func (r *Action) CheckRedirect(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: scheme of %q", ErrSourceNotAllowed, u)
+	}
+	if r.rule.sources == nil {
+		return nil
+	}
+	return r.rule.sources.checkFile(u.String())
+}
@@ -0,0 +1,272 @@
+package pattern
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/wzshiming/jitdi/pkg/apis/v1alpha1"
+)
+
+func TestSources(t *testing.T) {
+	root := t.TempDir()
+	err := os.MkdirAll(filepath.Join(root, "models", "llama"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		spec      v1alpha1.ImageSpec
+		image     string
+		wantMatch bool
+		wantErr   error
+	}{
+		{
+			name: "param matched",
+			spec: v1alpha1.ImageSpec{
+				Match:     "k8s/{image}:{tag}",
+				BaseImage: "docker.io/library/alpine",
+				Mutates: []v1alpha1.Mutate{
+					{File: &v1alpha1.File{Source: "https://dl.k8s.io/{tag}/bin/linux/{GOARCH}/{image}"}},
+				},
+				Sources: &v1alpha1.Sources{
+					Params: map[string]string{"tag": `v[0-9]+\.[0-9]+\.[0-9]+`},
+				},
+			},
+			image:     "k8s/kubectl:v1.29.3",
+			wantMatch: true,
+		},
+		{
+			name: "param not matched",
+			spec: v1alpha1.ImageSpec{
+				Match: "k8s/{image}:{tag}",
+				Sources: &v1alpha1.Sources{
+					Params: map[string]string{"tag": `v[0-9]+\.[0-9]+\.[0-9]+`},
+				},
+			},
+			image: "k8s/kubectl:latest",
+		},
+		{
+			name: "path traversal without sources",
+			spec: v1alpha1.ImageSpec{
+				Match:     "files/{name}:{tag}",
+				BaseImage: "docker.io/library/alpine",
+				Mutates: []v1alpha1.Mutate{
+					{File: &v1alpha1.File{Source: root + "/models/{tag}"}},
+				},
+			},
+			image:     "files/x:..",
+			wantMatch: true,
+			wantErr:   ErrSourceNotAllowed,
+		},
+		{
+			name: "host allowed",
+			spec: v1alpha1.ImageSpec{
+				Match:     "files/{host}:{tag}",
+				BaseImage: "docker.io/library/alpine",
+				Mutates: []v1alpha1.Mutate{
+					{File: &v1alpha1.File{Source: "https://{host}/{tag}"}},
+				},
+				Sources: &v1alpha1.Sources{
+					Schemes: []string{"https"},
+					Hosts:   []string{"docker.io", "{name}.example.com"},
+				},
+			},
+			image:     "files/dl.example.com:file",
+			wantMatch: true,
+		},
+		{
+			name: "host not allowed",
+			spec: v1alpha1.ImageSpec{
+				Match:     "files/{host}:{tag}",
+				BaseImage: "docker.io/library/alpine",
+				Mutates: []v1alpha1.Mutate{
+					{File: &v1alpha1.File{Source: "https://{host}/{tag}"}},
+				},
+				Sources: &v1alpha1.Sources{
+					Hosts: []string{"docker.io", "{name}.example.com"},
+				},
+			},
+			image:     "files/internal.local:file",
+			wantMatch: true,
+			wantErr:   ErrSourceNotAllowed,
+		},
+		{
+			name: "registry not allowed",
+			spec: v1alpha1.ImageSpec{
+				Match:     "mirror/{registry}/{image}:{tag}",
+				BaseImage: "{registry}/{image}:{tag}",
+				Sources: &v1alpha1.Sources{
+					Hosts: []string{"docker.io", "index.docker.io"},
+				},
+			},
+			image:     "mirror/10.0.0.1:5000/alpine:latest",
+			wantMatch: true,
+			wantErr:   ErrSourceNotAllowed,
+		},
+		{
+			name: "model registry allowed",
+			spec: v1alpha1.ImageSpec{
+				Match:     "ollama/{model}:{tag}",
+				BaseImage: "docker.io/library/alpine",
+				Mutates: []v1alpha1.Mutate{
+					{Ollama: &v1alpha1.Ollama{Model: "registry.ollama.ai/library/{model}:{tag}"}},
+				},
+				Sources: &v1alpha1.Sources{
+					Hosts: []string{"docker.io", "registry.ollama.ai"},
+				},
+			},
+			image:     "ollama/llama3:8b",
+			wantMatch: true,
+		},
+		{
+			name: "model registry not allowed",
+			spec: v1alpha1.ImageSpec{
+				Match:     "ollama/{registry}/{model}:{tag}",
+				BaseImage: "docker.io/library/alpine",
+				Mutates: []v1alpha1.Mutate{
+					{Ollama: &v1alpha1.Ollama{Model: "{registry}/library/{model}:{tag}"}},
+				},
+				Sources: &v1alpha1.Sources{
+					Hosts: []string{"docker.io", "registry.ollama.ai"},
+				},
+			},
+			image:     "ollama/10.0.0.1:5000/llama3:8b",
+			wantMatch: true,
+			wantErr:   ErrSourceNotAllowed,
+		},
+		{
+			name: "scheme not allowed",
+			spec: v1alpha1.ImageSpec{
+				Match:     "files/{name}:{tag}",
+				BaseImage: "docker.io/library/alpine",
+				Mutates: []v1alpha1.Mutate{
+					{File: &v1alpha1.File{Source: "http://example.com/{tag}"}},
+				},
+				Sources: &v1alpha1.Sources{
+					Schemes: []string{"https"},
+				},
+			},
+			image:     "files/x:y",
+			wantMatch: true,
+			wantErr:   ErrSourceNotAllowed,
+		},
+		{
+			name: "local root allowed",
+			spec: v1alpha1.ImageSpec{
+				Match:     "models/{name}:{tag}",
+				BaseImage: "docker.io/library/alpine",
+				Mutates: []v1alpha1.Mutate{
+					{File: &v1alpha1.File{Source: root + "/models/{name}"}},
+				},
+				Sources: &v1alpha1.Sources{
+					Schemes:    []string{"file"},
+					LocalRoots: []string{root + "/models"},
+				},
+			},
+			image:     "models/llama:latest",
+			wantMatch: true,
+		},
+		{
+			name: "local root not allowed",
+			spec: v1alpha1.ImageSpec{
+				Match:     "models/{name}:{tag}",
+				BaseImage: "docker.io/library/alpine",
+				Mutates: []v1alpha1.Mutate{
+					{File: &v1alpha1.File{Source: "/etc/{name}"}},
+				},
+				Sources: &v1alpha1.Sources{
+					LocalRoots: []string{root + "/models"},
+				},
+			},
+			image:     "models/passwd:latest",
+			wantMatch: true,
+			wantErr:   ErrSourceNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := NewRule(&tt.spec)
+			if err != nil {
+				t.Fatalf("NewRule() error = %v", err)
+			}
+			action, ok := rule.Match(tt.image)
+			if ok != tt.wantMatch {
+				t.Fatalf("Match() ok = %v, want %v", ok, tt.wantMatch)
+			}
+			if !ok {
+				return
+			}
+
+			err = action.CheckBaseImage()
+			if err == nil {
+				err = action.CheckMutates(action.GetMutates(nil))
+			}
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("Check() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckRedirect(t *testing.T) {
+	rule, err := NewRule(&v1alpha1.ImageSpec{
+		Match:     "files/{name}:{tag}",
+		BaseImage: "docker.io/library/alpine",
+		Mutates: []v1alpha1.Mutate{
+			{File: &v1alpha1.File{Source: "https://dl.example.com/{tag}"}},
+		},
+		Sources: &v1alpha1.Sources{
+			Schemes: []string{"https"},
+			Hosts:   []string{"docker.io", "{name}.example.com"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewRule() error = %v", err)
+	}
+	action, ok := rule.Match("files/x:y")
+	if !ok {
+		t.Fatal("Match() not matched")
+	}
+
+	tests := []struct {
+		name    string
+		url     string
+		wantErr error
+	}{
+		{
+			name: "allowed",
+			url:  "https://cdn.example.com/y",
+		},
+		{
+			name:    "host not allowed",
+			url:     "https://169.254.169.254/latest/meta-data",
+			wantErr: ErrSourceNotAllowed,
+		},
+		{
+			name:    "scheme not allowed",
+			url:     "http://cdn.example.com/y",
+			wantErr: ErrSourceNotAllowed,
+		},
+		{
+			name:    "local file",
+			url:     "file:///etc/passwd",
+			wantErr: ErrSourceNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = action.CheckRedirect(u)
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("CheckRedirect() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
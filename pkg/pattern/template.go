@@ -0,0 +1,128 @@
+package pattern
+
+import (
+	"fmt"
+	"strings"
+)
+
+// filter transforms the value of a parameter with the argument.
+type filter func(v, arg string) string
+
+var filters = map[string]filter{
+	"upper": func(v, _ string) string {
+		return strings.ToUpper(v)
+	},
+	"lower": func(v, _ string) string {
+		return strings.ToLower(v)
+	},
+	"trimprefix": strings.TrimPrefix,
+	"trimsuffix": strings.TrimSuffix,
+	// replace:old=new replaces all old with new.
+	"replace": func(v, arg string) string {
+		old, new, _ := strings.Cut(arg, "=")
+		return strings.ReplaceAll(v, old, new)
+	},
+	// map:k1=v1,k2=v2 maps the value, the value not in the map is kept.
+	"map": func(v, arg string) string {
+		for _, kv := range strings.Split(arg, ",") {
+			k, mapped, _ := strings.Cut(kv, "=")
+			if k == v {
+				return mapped
+			}
+		}
+		return v
+	},
+}
+
+// expression is a parameter in a template, "{name=default|filter:arg|filter}".
+type expression struct {
+	name       string
+	def        string
+	hasDefault bool
+	filters    []filterCall
+}
+
+type filterCall struct {
+	name string
+	arg  string
+}
+
+func parseExpression(s string) (*expression, error) {
+	parts := strings.Split(s, "|")
+	e := &expression{}
+	e.name, e.def, e.hasDefault = strings.Cut(parts[0], "=")
+	if e.name == "" {
+		return nil, fmt.Errorf("empty parameter in %q", s)
+	}
+	for _, part := range parts[1:] {
+		name, arg, _ := strings.Cut(part, ":")
+		if _, ok := filters[name]; !ok {
+			return nil, fmt.Errorf("unknown filter %q in %q", name, s)
+		}
+		e.filters = append(e.filters, filterCall{name: name, arg: arg})
+	}
+	return e, nil
+}
+
+func (e *expression) eval(params map[string]string) (string, bool) {
+	v, ok := params[e.name]
+	if !ok || v == "" {
+		if !e.hasDefault {
+			return "", ok
+		}
+		v = e.def
+	}
+	for _, f := range e.filters {
+		v = filters[f.name](v, f.arg)
+	}
+	return v, true
+}
+
+// walkExpressions calls fn with the content of each "{...}" in s, and replaces it with the result if ok.
+func walkExpressions(s string, fn func(expr string) (string, bool)) string {
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(s, '{')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 {
+			break
+		}
+		end += start
+		b.WriteString(s[:start])
+		if v, ok := fn(s[start+1 : end]); ok {
+			b.WriteString(v)
+		} else {
+			b.WriteString(s[start : end+1])
+		}
+		s = s[end+1:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+// checkTemplate returns an error if an expression of the template is invalid.
+func checkTemplate(s string) error {
+	var err error
+	walkExpressions(s, func(expr string) (string, bool) {
+		if err == nil {
+			_, err = parseExpression(expr)
+		}
+		return "", false
+	})
+	return err
+}
+
+// replaceWithParams replaces the expressions of the parameters in s,
+// the expressions of unknown parameters without default are kept.
+func replaceWithParams(s string, params map[string]string) string {
+	return walkExpressions(s, func(expr string) (string, bool) {
+		e, err := parseExpression(expr)
+		if err != nil {
+			return "", false
+		}
+		return e.eval(params)
+	})
+}
@@ -0,0 +1,73 @@
+package pattern
+
+import (
+	"testing"
+)
+
+func Test_replaceWithParams(t *testing.T) {
+	params := map[string]string{
+		"size":   "7b",
+		"tag":    "v1.29.3",
+		"GOARCH": "arm64",
+		"empty":  "",
+	}
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{
+			s:    "Llama-2-{size|upper}-Chat-GGUF/llama-2-{size}-chat",
+			want: "Llama-2-7B-Chat-GGUF/llama-2-7b-chat",
+		},
+		{
+			s:    "release-{GOARCH|map:amd64=x86_64,arm64=aarch64}.tar.gz",
+			want: "release-aarch64.tar.gz",
+		},
+		{
+			s:    "{GOARCH|map:amd64=x86_64}",
+			want: "arm64",
+		},
+		{
+			s:    "{tag|trimprefix:v}",
+			want: "1.29.3",
+		},
+		{
+			s:    "{tag|trimprefix:v|replace:.=_}",
+			want: "1_29_3",
+		},
+		{
+			s:    "{variant=full}-{empty=default|upper}",
+			want: "full-DEFAULT",
+		},
+		{
+			s:    "{unknown}-{empty}",
+			want: "{unknown}-",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			if got := replaceWithParams(tt.s, params); got != tt.want {
+				t.Errorf("replaceWithParams() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_checkTemplate(t *testing.T) {
+	tests := []struct {
+		s       string
+		wantErr bool
+	}{
+		{s: "{size|upper}"},
+		{s: "{variant=full|lower}"},
+		{s: "{size|unknown}", wantErr: true},
+		{s: "{|upper}", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			if err := checkTemplate(tt.s); (err != nil) != tt.wantErr {
+				t.Errorf("checkTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
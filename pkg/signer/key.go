@@ -0,0 +1,110 @@
+package signer
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// The PEM types of the private keys generated by cosign.
+const (
+	pemTypeEncryptedSigstore = "ENCRYPTED SIGSTORE PRIVATE KEY"
+	pemTypeEncryptedCosign   = "ENCRYPTED COSIGN PRIVATE KEY"
+)
+
+var ErrWrongPassword = errors.New("wrong password of the private key")
+
+// envelope is the encrypted private key of cosign, the key is derived by scrypt and sealed by nacl/secretbox.
+type envelope struct {
+	KDF struct {
+		Name   string `json:"name"`
+		Params struct {
+			N int `json:"N"`
+			R int `json:"r"`
+			P int `json:"p"`
+		} `json:"params"`
+		Salt []byte `json:"salt"`
+	} `json:"kdf"`
+	Cipher struct {
+		Name  string `json:"name"`
+		Nonce []byte `json:"nonce"`
+	} `json:"cipher"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// LoadKey returns the signer of the PEM private key,
+// the encrypted keys generated by cosign are decrypted with the password.
+func LoadKey(data []byte, password []byte) (*Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block of the private key")
+	}
+
+	var der []byte
+	switch block.Type {
+	case pemTypeEncryptedSigstore, pemTypeEncryptedCosign:
+		d, err := decrypt(block.Bytes, password)
+		if err != nil {
+			return nil, err
+		}
+		der = d
+	case "PRIVATE KEY":
+		der = block.Bytes
+	case "EC PRIVATE KEY":
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return NewSigner(key)
+	default:
+		return nil, fmt.Errorf("unsupported PEM type %q", block.Type)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key %T", key)
+	}
+	return NewSigner(signer)
+}
+
+func decrypt(data []byte, password []byte) ([]byte, error) {
+	var e envelope
+	err := json.Unmarshal(data, &e)
+	if err != nil {
+		return nil, fmt.Errorf("decode encrypted private key: %w", err)
+	}
+	if e.KDF.Name != "scrypt" {
+		return nil, fmt.Errorf("unsupported kdf %q", e.KDF.Name)
+	}
+	if e.Cipher.Name != "nacl/secretbox" {
+		return nil, fmt.Errorf("unsupported cipher %q", e.Cipher.Name)
+	}
+	if len(e.Cipher.Nonce) != 24 {
+		return nil, fmt.Errorf("invalid nonce of the cipher")
+	}
+
+	k, err := scrypt.Key(password, e.KDF.Salt, e.KDF.Params.N, e.KDF.Params.R, e.KDF.Params.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	var key [32]byte
+	var nonce [24]byte
+	copy(key[:], k)
+	copy(nonce[:], e.Cipher.Nonce)
+
+	der, ok := secretbox.Open(nil, e.Ciphertext, &nonce, &key)
+	if !ok {
+		return nil, ErrWrongPassword
+	}
+	return der, nil
+}
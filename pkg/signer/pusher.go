@@ -0,0 +1,67 @@
+package signer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+
+	"github.com/wzshiming/jitdi/pkg/storage"
+)
+
+type pusher struct {
+	storage.Pusher
+	signer *Signer
+}
+
+// NewPusher returns the pusher signing each manifest it pushes,
+// the signature is pushed with the tag of SignatureTag in the same repository.
+func NewPusher(p storage.Pusher, s *Signer) storage.Pusher {
+	return &pusher{
+		Pusher: p,
+		signer: s,
+	}
+}
+
+func (p *pusher) PushImage(ctx context.Context, ref name.Reference, image v1.Image) error {
+	err := p.Pusher.PushImage(ctx, ref, image)
+	if err != nil {
+		return err
+	}
+	return p.sign(ctx, ref.Context(), image)
+}
+
+func (p *pusher) PushImageWithIndex(ctx context.Context, repo name.Repository, image v1.Image) error {
+	err := p.Pusher.PushImageWithIndex(ctx, repo, image)
+	if err != nil {
+		return err
+	}
+	return p.sign(ctx, repo, image)
+}
+
+func (p *pusher) PushImageIndex(ctx context.Context, ref name.Reference, imageIndex v1.ImageIndex) error {
+	err := p.Pusher.PushImageIndex(ctx, ref, imageIndex)
+	if err != nil {
+		return err
+	}
+	return p.sign(ctx, ref.Context(), imageIndex)
+}
+
+// sign pushes the signature of the manifest, the digest of the streamed layers is known after it is pushed.
+func (p *pusher) sign(ctx context.Context, repo name.Repository, t partial.Describable) error {
+	digest, err := t.Digest()
+	if err != nil {
+		return err
+	}
+	sig, err := p.signer.SignatureImage(repo.RepositoryStr(), digest)
+	if err != nil {
+		return fmt.Errorf("sign %s: %w", digest, err)
+	}
+	err = p.Pusher.PushImage(ctx, repo.Tag(SignatureTag(digest)), sig)
+	if err != nil {
+		return fmt.Errorf("push signature of %s: %w", digest, err)
+	}
+	return nil
+}
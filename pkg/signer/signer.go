@@ -0,0 +1,121 @@
+package signer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+const (
+	// MediaTypeSimpleSigning is the media type of the payload layer of the cosign signatures.
+	MediaTypeSimpleSigning types.MediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+
+	// AnnotationSignature is the annotation of the base64 signature of the payload layer.
+	AnnotationSignature = "dev.cosignproject.cosign/signature"
+
+	signatureType = "cosign container image signature"
+)
+
+// Signer signs the manifests with cosign compatible signatures.
+type Signer struct {
+	key crypto.Signer
+}
+
+// NewSigner returns the signer of the ECDSA, RSA or Ed25519 private key.
+func NewSigner(key crypto.Signer) (*Signer, error) {
+	switch key.(type) {
+	case *ecdsa.PrivateKey, *rsa.PrivateKey, ed25519.PrivateKey:
+	default:
+		return nil, fmt.Errorf("unsupported private key %T", key)
+	}
+	return &Signer{
+		key: key,
+	}, nil
+}
+
+// PublicKey returns the PEM public key, to verify the signatures with cosign verify --key.
+func (s *Signer) PublicKey() ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(s.key.Public())
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: der,
+	}), nil
+}
+
+// SignatureTag returns the tag of the signature of the digest, e.g. sha256-<hex>.sig.
+func SignatureTag(digest v1.Hash) string {
+	return digest.Algorithm + "-" + digest.Hex + ".sig"
+}
+
+// payload is the simple signing payload of cosign.
+type payload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]any `json:"optional"`
+}
+
+// Sign returns the payload of the manifest digest in the repository and its signature.
+func (s *Signer) Sign(repo string, digest v1.Hash) ([]byte, []byte, error) {
+	var p payload
+	p.Critical.Identity.DockerReference = repo
+	p.Critical.Image.DockerManifestDigest = digest.String()
+	p.Critical.Type = signatureType
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var sig []byte
+	if _, ok := s.key.(ed25519.PrivateKey); ok {
+		sig, err = s.key.Sign(rand.Reader, data, crypto.Hash(0))
+	} else {
+		sum := sha256.Sum256(data)
+		sig, err = s.key.Sign(rand.Reader, sum[:], crypto.SHA256)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, sig, nil
+}
+
+// SignatureImage returns the signature image of the manifest digest in the repository,
+// it is the same as the images pushed by cosign sign.
+func (s *Signer) SignatureImage(repo string, digest v1.Hash) (v1.Image, error) {
+	data, sig, err := s.Sign(repo, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	image := mutate.MediaType(empty.Image, types.OCIManifestSchema1)
+	image = mutate.ConfigMediaType(image, types.OCIConfigJSON)
+	return mutate.Append(image, mutate.Addendum{
+		Layer: static.NewLayer(data, MediaTypeSimpleSigning),
+		Annotations: map[string]string{
+			AnnotationSignature: base64.StdEncoding.EncodeToString(sig),
+		},
+	})
+}
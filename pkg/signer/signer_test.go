@@ -0,0 +1,230 @@
+package signer
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/wzshiming/jitdi/pkg/storage"
+)
+
+// encryptKey encrypts the key as cosign generate-key-pair.
+func encryptKey(t *testing.T, key *ecdsa.PrivateKey, password []byte) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey() error = %v", err)
+	}
+
+	var e envelope
+	e.KDF.Name = "scrypt"
+	e.KDF.Params.N = 1 << 10
+	e.KDF.Params.R = 8
+	e.KDF.Params.P = 1
+	e.KDF.Salt = make([]byte, 32)
+	e.Cipher.Name = "nacl/secretbox"
+	e.Cipher.Nonce = make([]byte, 24)
+	_, _ = rand.Read(e.KDF.Salt)
+	_, _ = rand.Read(e.Cipher.Nonce)
+
+	k, err := scrypt.Key(password, e.KDF.Salt, e.KDF.Params.N, e.KDF.Params.R, e.KDF.Params.P, 32)
+	if err != nil {
+		t.Fatalf("scrypt.Key() error = %v", err)
+	}
+	var secret [32]byte
+	var nonce [24]byte
+	copy(secret[:], k)
+	copy(nonce[:], e.Cipher.Nonce)
+	e.Ciphertext = secretbox.Seal(nil, der, &nonce, &secret)
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: pemTypeEncryptedSigstore, Bytes: data})
+}
+
+func TestLoadKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey() error = %v", err)
+	}
+	ecDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		password string
+		wantErr  error
+	}{
+		{
+			name:     "encrypted",
+			data:     encryptKey(t, key, []byte("password")),
+			password: "password",
+		},
+		{
+			name:     "wrong password",
+			data:     encryptKey(t, key, []byte("password")),
+			password: "wrong",
+			wantErr:  ErrWrongPassword,
+		},
+		{
+			name: "pkcs8",
+			data: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}),
+		},
+		{
+			name: "ec",
+			data: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := LoadKey(tt.data, []byte(tt.password))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("LoadKey() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadKey() error = %v", err)
+			}
+			if !key.Equal(s.key) {
+				t.Fatalf("LoadKey() got another key")
+			}
+		})
+	}
+}
+
+func TestPusher(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(server.Close)
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	s, err := NewSigner(key)
+	if err != nil {
+		t.Fatalf("NewSigner() error = %v", err)
+	}
+
+	p, err := storage.NewPusher()
+	if err != nil {
+		t.Fatalf("NewPusher() error = %v", err)
+	}
+	p = NewPusher(p, s)
+
+	image, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatalf("random.Image() error = %v", err)
+	}
+	ref, err := name.ParseReference(host + "/foo/bar:v1")
+	if err != nil {
+		t.Fatalf("ParseReference() error = %v", err)
+	}
+	ctx := context.Background()
+	err = p.PushImage(ctx, ref, image)
+	if err != nil {
+		t.Fatalf("PushImage() error = %v", err)
+	}
+
+	digest, err := image.Digest()
+	if err != nil {
+		t.Fatalf("Digest() error = %v", err)
+	}
+	sig, err := remote.Image(ref.Context().Tag(SignatureTag(digest)))
+	if err != nil {
+		t.Fatalf("remote.Image() error = %v", err)
+	}
+	manifest, err := sig.Manifest()
+	if err != nil {
+		t.Fatalf("Manifest() error = %v", err)
+	}
+	if len(manifest.Layers) != 1 || manifest.Layers[0].MediaType != MediaTypeSimpleSigning {
+		t.Fatalf("Manifest() got layers %v", manifest.Layers)
+	}
+	signature, err := base64.StdEncoding.DecodeString(manifest.Layers[0].Annotations[AnnotationSignature])
+	if err != nil {
+		t.Fatalf("DecodeString() error = %v", err)
+	}
+
+	layer, err := sig.LayerByDigest(manifest.Layers[0].Digest)
+	if err != nil {
+		t.Fatalf("LayerByDigest() error = %v", err)
+	}
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		t.Fatalf("Uncompressed() error = %v", err)
+	}
+	data, err := io.ReadAll(rc)
+	_ = rc.Close()
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+
+	pub, err := s.PublicKey()
+	if err != nil {
+		t.Fatalf("PublicKey() error = %v", err)
+	}
+	v, err := NewVerifier(pub)
+	if err != nil {
+		t.Fatalf("NewVerifier() error = %v", err)
+	}
+	err = v.Verify(sig, digest)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	other, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatalf("random.Image() error = %v", err)
+	}
+	otherDigest, err := other.Digest()
+	if err != nil {
+		t.Fatalf("Digest() error = %v", err)
+	}
+	err = v.Verify(sig, otherDigest)
+	if !errors.Is(err, ErrNoValidSignature) {
+		t.Fatalf("Verify() error = %v, want %v", err, ErrNoValidSignature)
+	}
+
+	sum := sha256.Sum256(data)
+	if !ecdsa.VerifyASN1(&key.PublicKey, sum[:], signature) {
+		t.Fatalf("VerifyASN1() failed")
+	}
+
+	var got payload
+	err = json.Unmarshal(data, &got)
+	if err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if got.Critical.Image.DockerManifestDigest != digest.String() || got.Critical.Identity.DockerReference != "foo/bar" {
+		t.Fatalf("payload got %+v", got.Critical)
+	}
+}
@@ -0,0 +1,117 @@
+package signer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+)
+
+// ErrNoValidSignature is returned when no signature of the image is valid for the public keys.
+var ErrNoValidSignature = errors.New("no valid signature")
+
+// Verifier verifies the cosign signatures with the public keys.
+type Verifier struct {
+	keys []crypto.PublicKey
+}
+
+// NewVerifier returns the verifier of the PEM public keys.
+func NewVerifier(publicKeys ...[]byte) (*Verifier, error) {
+	v := &Verifier{}
+	for _, data := range publicKeys {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no PEM block of the public key")
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch key.(type) {
+		case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		default:
+			return nil, fmt.Errorf("unsupported public key %T", key)
+		}
+		v.keys = append(v.keys, key)
+	}
+	return v, nil
+}
+
+func (v *Verifier) verify(data, sig []byte) bool {
+	sum := sha256.Sum256(data)
+	for _, key := range v.keys {
+		switch key := key.(type) {
+		case *ecdsa.PublicKey:
+			if ecdsa.VerifyASN1(key, sum[:], sig) {
+				return true
+			}
+		case *rsa.PublicKey:
+			if rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig) == nil {
+				return true
+			}
+		case ed25519.PublicKey:
+			if ed25519.Verify(key, data, sig) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Verify returns nil if a signature in the signature image is valid for the manifest digest,
+// the signature image is the one tagged with SignatureTag.
+func (v *Verifier) Verify(sig v1.Image, digest v1.Hash) error {
+	manifest, err := sig.Manifest()
+	if err != nil {
+		return err
+	}
+	for _, desc := range manifest.Layers {
+		if desc.MediaType != MediaTypeSimpleSigning {
+			continue
+		}
+		signature, err := base64.StdEncoding.DecodeString(desc.Annotations[AnnotationSignature])
+		if err != nil {
+			continue
+		}
+		layer, err := sig.LayerByDigest(desc.Digest)
+		if err != nil {
+			return err
+		}
+		data, err := readLayer(layer)
+		if err != nil {
+			return err
+		}
+		if !v.verify(data, signature) {
+			continue
+		}
+
+		var p payload
+		err = json.Unmarshal(data, &p)
+		if err != nil {
+			continue
+		}
+		if p.Critical.Image.DockerManifestDigest == digest.String() {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w of %s", ErrNoValidSignature, digest)
+}
+
+func readLayer(layer v1.Layer) ([]byte, error) {
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// WriteOCIArchive writes the image or image index as a tarball of an OCI image layout,
+// the refName is the annotation of the entry in the index.json.
+func WriteOCIArchive(w io.Writer, refName string, t partial.Describable) error {
+	a := &ociArchive{
+		tw:      tar.NewWriter(w),
+		written: map[v1.Hash]struct{}{},
+	}
+
+	err := a.writeFile(ociLayoutFile, []byte(ociLayoutVersion))
+	if err != nil {
+		return err
+	}
+
+	var desc *v1.Descriptor
+	switch t := t.(type) {
+	case v1.ImageIndex:
+		desc, err = a.writeIndex(t)
+	case v1.Image:
+		desc, err = a.writeImage(t)
+	default:
+		err = fmt.Errorf("unsupported type %T", t)
+	}
+	if err != nil {
+		return err
+	}
+
+	desc.Annotations = map[string]string{
+		AnnotationRefName:             refName,
+		AnnotationContainerdImageName: refName,
+	}
+	index, err := json.Marshal(v1.IndexManifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIImageIndex,
+		Manifests:     []v1.Descriptor{*desc},
+	})
+	if err != nil {
+		return err
+	}
+	err = a.writeFile(ociIndexFile, index)
+	if err != nil {
+		return err
+	}
+	return a.tw.Close()
+}
+
+type ociArchive struct {
+	tw      *tar.Writer
+	written map[v1.Hash]struct{}
+}
+
+func (a *ociArchive) writeFile(name string, data []byte) error {
+	return a.writeFileWithReader(name, int64(len(data)), bytes.NewReader(data))
+}
+
+func (a *ociArchive) writeFileWithReader(name string, size int64, r io.Reader) error {
+	err := a.tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Size:     size,
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		ModTime:  time.Time{},
+	})
+	if err != nil {
+		return fmt.Errorf("tar.Writer.WriteHeader(%q): %w", name, err)
+	}
+	n, err := io.Copy(a.tw, r)
+	if err != nil {
+		return fmt.Errorf("io.Copy(%q): %w", name, err)
+	}
+	if n != size {
+		return fmt.Errorf("io.Copy(%q): short write: %d != %d", name, n, size)
+	}
+	return nil
+}
+
+func (a *ociArchive) blobName(digest v1.Hash) string {
+	return path.Join("blobs", digest.Algorithm, digest.Hex)
+}
+
+func (a *ociArchive) writeBlob(digest v1.Hash, data []byte) error {
+	if _, ok := a.written[digest]; ok {
+		return nil
+	}
+	a.written[digest] = struct{}{}
+	return a.writeFile(a.blobName(digest), data)
+}
+
+func (a *ociArchive) writeLayer(layer v1.Layer) error {
+	digest, err := layer.Digest()
+	if err != nil {
+		return err
+	}
+	if _, ok := a.written[digest]; ok {
+		return nil
+	}
+	a.written[digest] = struct{}{}
+
+	size, err := layer.Size()
+	if err != nil {
+		return err
+	}
+	rc, err := layer.Compressed()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return a.writeFileWithReader(a.blobName(digest), size, rc)
+}
+
+func (a *ociArchive) writeManifest(t partial.Describable, raw []byte) (*v1.Descriptor, error) {
+	mediaType, err := t.MediaType()
+	if err != nil {
+		return nil, err
+	}
+	digest, err := t.Digest()
+	if err != nil {
+		return nil, err
+	}
+	err = a.writeBlob(digest, raw)
+	if err != nil {
+		return nil, err
+	}
+	return &v1.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest,
+		Size:      int64(len(raw)),
+	}, nil
+}
+
+func (a *ociArchive) writeImage(image v1.Image) (*v1.Descriptor, error) {
+	layers, err := image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	for _, layer := range layers {
+		err = a.writeLayer(layer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	configName, err := image.ConfigName()
+	if err != nil {
+		return nil, err
+	}
+	configBlob, err := image.RawConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	err = a.writeBlob(configName, configBlob)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := image.RawManifest()
+	if err != nil {
+		return nil, err
+	}
+	return a.writeManifest(image, raw)
+}
+
+func (a *ociArchive) writeIndex(index v1.ImageIndex) (*v1.Descriptor, error) {
+	indexManifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	for _, desc := range indexManifest.Manifests {
+		switch {
+		case desc.MediaType.IsIndex():
+			child, err := index.ImageIndex(desc.Digest)
+			if err != nil {
+				return nil, err
+			}
+			_, err = a.writeIndex(child)
+			if err != nil {
+				return nil, err
+			}
+		case desc.MediaType.IsImage():
+			child, err := index.Image(desc.Digest)
+			if err != nil {
+				return nil, err
+			}
+			_, err = a.writeImage(child)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	raw, err := index.RawManifest()
+	if err != nil {
+		return nil, err
+	}
+	return a.writeManifest(index, raw)
+}
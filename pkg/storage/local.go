@@ -2,6 +2,8 @@ package storage
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"log/slog"
@@ -11,8 +13,11 @@ import (
 
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/v1"
+	"go.opentelemetry.io/otel/attribute"
 
 	"github.com/wzshiming/jitdi/pkg/atomic"
+	"github.com/wzshiming/jitdi/pkg/metrics"
+	"github.com/wzshiming/jitdi/pkg/tracing"
 )
 
 func LocalBlobPath(cacheBlobs, hash string) string {
@@ -58,7 +63,7 @@ func (l *localPusher) PushImage(ctx context.Context, ref name.Reference, image v
 	}
 
 	for _, layer := range layers {
-		err = saveLayer(layer, l.cacheBlobs)
+		err = saveLayer(ctx, layer, l.cacheBlobs)
 		if err != nil {
 			return err
 		}
@@ -94,7 +99,7 @@ func (l *localPusher) PushImageWithIndex(ctx context.Context, repo name.Reposito
 	}
 
 	for _, layer := range layers {
-		err = saveLayer(layer, l.cacheBlobs)
+		err = saveLayer(ctx, layer, l.cacheBlobs)
 		if err != nil {
 			return err
 		}
@@ -123,7 +128,12 @@ func (l *localPusher) PushImageWithIndex(ctx context.Context, repo name.Reposito
 	return saveManifest(manifestBlob, l.cacheBlobs, l.cacheManifest, repo.RepositoryStr(), "")
 }
 
-func saveLayer(layer v1.Layer, cacheBlobs string) (retErr error) {
+func saveLayer(ctx context.Context, layer v1.Layer, cacheBlobs string) (retErr error) {
+	_, span := tracing.Start(ctx, "saveLayer")
+	defer func() {
+		tracing.End(span, retErr)
+	}()
+
 	digest, err := layer.Digest()
 	if err == nil {
 		cachePath := LocalBlobPath(cacheBlobs, digest.Hex)
@@ -134,11 +144,17 @@ func saveLayer(layer v1.Layer, cacheBlobs string) (retErr error) {
 				n := fi.Size()
 				if n == size {
 					slog.Info("hit layer", "path", cachePath, "size", size)
+					metrics.ObserveCache(metrics.CacheBlob, true)
+					span.SetAttributes(
+						attribute.String("jitdi.digest", digest.String()),
+						attribute.Bool("jitdi.cache_hit", true),
+					)
 					return nil
 				}
 			}
 		}
 	}
+	metrics.ObserveCache(metrics.CacheBlob, false)
 
 	dir := LocalBlobPath(cacheBlobs, "")
 
@@ -183,6 +199,10 @@ func saveLayer(layer v1.Layer, cacheBlobs string) (retErr error) {
 	}
 
 	slog.Info("save layer", "path", cachePath, "size", size)
+	span.SetAttributes(
+		attribute.String("jitdi.digest", digest.String()),
+		attribute.Int64("jitdi.size", size),
+	)
 
 	return nil
 }
@@ -225,3 +245,160 @@ func saveManifest(manifestBlob []byte, cacheBlobs, cacheManifest, name, tag stri
 	}
 	return nil
 }
+
+type localStorage struct {
+	*localPusher
+}
+
+// NewLocalStorage returns a storage using the jitdi cache layout.
+func NewLocalStorage(cacheBlobs, cacheManifest string) Storage {
+	return &localStorage{
+		localPusher: &localPusher{
+			cacheBlobs:    cacheBlobs,
+			cacheManifest: cacheManifest,
+		},
+	}
+}
+
+func (l *localStorage) StatBlob(ctx context.Context, repo string, digest v1.Hash) (*v1.Descriptor, error) {
+	return statBlobFile(LocalBlobPath(l.cacheBlobs, digest.String()), digest)
+}
+
+func (l *localStorage) GetBlob(ctx context.Context, repo string, digest v1.Hash) (io.ReadCloser, *v1.Descriptor, error) {
+	return openBlobFile(LocalBlobPath(l.cacheBlobs, digest.String()), digest)
+}
+
+func (l *localStorage) PutBlob(ctx context.Context, repo string, r io.Reader) (*v1.Descriptor, error) {
+	return putBlobFile(LocalBlobPath(l.cacheBlobs, ""), r, func(digest v1.Hash) string {
+		return LocalBlobPath(l.cacheBlobs, digest.String())
+	})
+}
+
+func (l *localStorage) GetManifest(ctx context.Context, repo, reference string) (*Manifest, error) {
+	p := LocalManifestPath(l.cacheManifest, repo, reference)
+	if IsDigest(reference) {
+		p = LocalBlobPath(l.cacheBlobs, reference)
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return NewManifest(data)
+}
+
+func (l *localStorage) PutManifest(ctx context.Context, repo, reference string, manifest *Manifest) error {
+	return saveManifest(manifest.Data, l.cacheBlobs, l.cacheManifest, repo, reference)
+}
+
+func (l *localStorage) List(ctx context.Context, repo string) ([]string, error) {
+	entries, err := os.ReadDir(path.Join(l.cacheManifest, repo))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	tags := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		_, err := os.Stat(LocalManifestPath(l.cacheManifest, repo, entry.Name()))
+		if err != nil {
+			continue
+		}
+		tags = append(tags, entry.Name())
+	}
+	return tags, nil
+}
+
+func (l *localStorage) Delete(ctx context.Context, repo, reference string) error {
+	p := path.Dir(LocalManifestPath(l.cacheManifest, repo, reference))
+	if IsDigest(reference) {
+		p = LocalBlobPath(l.cacheBlobs, reference)
+	}
+	_, err := os.Stat(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return os.RemoveAll(p)
+}
+
+func statBlobFile(blobPath string, digest v1.Hash) (*v1.Descriptor, error) {
+	fi, err := os.Stat(blobPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &v1.Descriptor{
+		Digest: digest,
+		Size:   fi.Size(),
+	}, nil
+}
+
+func openBlobFile(blobPath string, digest v1.Hash) (io.ReadCloser, *v1.Descriptor, error) {
+	f, err := os.Open(blobPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, err
+	}
+	return f, &v1.Descriptor{
+		Digest: digest,
+		Size:   fi.Size(),
+	}, nil
+}
+
+func putBlobFile(dir string, r io.Reader, blobPath func(digest v1.Hash) string) (*v1.Descriptor, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("mkdir: %w", err)
+	}
+
+	file, err := os.CreateTemp(dir, "tmp-")
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+	err = writeFileWithReader(file, io.NopCloser(io.TeeReader(r, hash)))
+	if err != nil {
+		return nil, fmt.Errorf("write file: %w", err)
+	}
+
+	fi, err := os.Stat(file.Name())
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return nil, err
+	}
+
+	digest := v1.Hash{
+		Algorithm: "sha256",
+		Hex:       hex.EncodeToString(hash.Sum(nil)),
+	}
+	err = os.Rename(file.Name(), blobPath(digest))
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return nil, fmt.Errorf("rename: %w", err)
+	}
+
+	return &v1.Descriptor{
+		Digest: digest,
+		Size:   fi.Size(),
+	}, nil
+}
@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+
+	"github.com/wzshiming/jitdi/pkg/atomic"
+)
+
+const (
+	ociLayoutFile    = "oci-layout"
+	ociIndexFile     = "index.json"
+	ociLayoutVersion = `{"imageLayoutVersion":"1.0.0"}`
+
+	// AnnotationRefName is the annotation of the reference name in the index.json.
+	AnnotationRefName = "org.opencontainers.image.ref.name"
+	// AnnotationContainerdImageName is the annotation of the image name used by `ctr import`.
+	AnnotationContainerdImageName = "io.containerd.image.name"
+)
+
+type ociLayoutStorage struct {
+	root string
+
+	mut sync.Mutex
+}
+
+// NewOCILayoutStorage returns a storage using an OCI image-layout directory.
+// Each tag is an entry of the index.json annotated with "<repo>:<tag>",
+// so the directory can be read by crane, skopeo, oras and containerd.
+func NewOCILayoutStorage(root string) (Storage, error) {
+	s := &ociLayoutStorage{
+		root: root,
+	}
+
+	_, err := os.Stat(path.Join(root, ociLayoutFile))
+	if err == nil {
+		return s, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	err = atomic.WriteFile(path.Join(root, ociLayoutFile), []byte(ociLayoutVersion), 0644)
+	if err != nil {
+		return nil, fmt.Errorf("write oci-layout: %w", err)
+	}
+
+	err = s.writeIndex(&v1.IndexManifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIImageIndex,
+		Manifests:     []v1.Descriptor{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *ociLayoutStorage) blobPath(digest v1.Hash) string {
+	return path.Join(s.root, "blobs", digest.Algorithm, digest.Hex)
+}
+
+func (s *ociLayoutStorage) StatBlob(ctx context.Context, repo string, digest v1.Hash) (*v1.Descriptor, error) {
+	return statBlobFile(s.blobPath(digest), digest)
+}
+
+func (s *ociLayoutStorage) GetBlob(ctx context.Context, repo string, digest v1.Hash) (io.ReadCloser, *v1.Descriptor, error) {
+	return openBlobFile(s.blobPath(digest), digest)
+}
+
+func (s *ociLayoutStorage) PutBlob(ctx context.Context, repo string, r io.Reader) (*v1.Descriptor, error) {
+	return putBlobFile(path.Join(s.root, "blobs", "sha256"), r, s.blobPath)
+}
+
+func (s *ociLayoutStorage) GetManifest(ctx context.Context, repo, reference string) (*Manifest, error) {
+	var digest v1.Hash
+	if IsDigest(reference) {
+		h, err := v1.NewHash(reference)
+		if err != nil {
+			return nil, err
+		}
+		digest = h
+	} else {
+		s.mut.Lock()
+		index, err := s.readIndex()
+		s.mut.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		i := indexOfRefName(index.Manifests, repo+":"+reference)
+		if i < 0 {
+			return nil, ErrNotFound
+		}
+		digest = index.Manifests[i].Digest
+	}
+
+	data, err := os.ReadFile(s.blobPath(digest))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return NewManifest(data)
+}
+
+func (s *ociLayoutStorage) PutManifest(ctx context.Context, repo, reference string, manifest *Manifest) error {
+	desc := manifest.Descriptor()
+	err := atomic.WriteFile(s.blobPath(desc.Digest), manifest.Data, 0644)
+	if err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+
+	if IsDigest(reference) {
+		return nil
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	index, err := s.readIndex()
+	if err != nil {
+		return err
+	}
+
+	refName := repo + ":" + reference
+	desc.Annotations = map[string]string{
+		AnnotationRefName:             refName,
+		AnnotationContainerdImageName: refName,
+	}
+	i := indexOfRefName(index.Manifests, refName)
+	if i < 0 {
+		index.Manifests = append(index.Manifests, desc)
+	} else {
+		index.Manifests[i] = desc
+	}
+	return s.writeIndex(index)
+}
+
+func (s *ociLayoutStorage) List(ctx context.Context, repo string) ([]string, error) {
+	s.mut.Lock()
+	index, err := s.readIndex()
+	s.mut.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	tags := []string{}
+	for _, desc := range index.Manifests {
+		tag, ok := strings.CutPrefix(desc.Annotations[AnnotationRefName], repo+":")
+		if !ok {
+			continue
+		}
+		tags = append(tags, tag)
+	}
+	if len(tags) == 0 {
+		return nil, ErrNotFound
+	}
+	return tags, nil
+}
+
+func (s *ociLayoutStorage) Delete(ctx context.Context, repo, reference string) error {
+	if IsDigest(reference) {
+		digest, err := v1.NewHash(reference)
+		if err != nil {
+			return err
+		}
+		err = os.Remove(s.blobPath(digest))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return ErrNotFound
+			}
+			return err
+		}
+		return nil
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	index, err := s.readIndex()
+	if err != nil {
+		return err
+	}
+	i := indexOfRefName(index.Manifests, repo+":"+reference)
+	if i < 0 {
+		return ErrNotFound
+	}
+	index.Manifests = append(index.Manifests[:i], index.Manifests[i+1:]...)
+	return s.writeIndex(index)
+}
+
+func (s *ociLayoutStorage) readIndex() (*v1.IndexManifest, error) {
+	data, err := os.ReadFile(path.Join(s.root, ociIndexFile))
+	if err != nil {
+		return nil, fmt.Errorf("read index.json: %w", err)
+	}
+	return v1.ParseIndexManifest(bytes.NewReader(data))
+}
+
+func (s *ociLayoutStorage) writeIndex(index *v1.IndexManifest) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	err = atomic.WriteFile(path.Join(s.root, ociIndexFile), data, 0644)
+	if err != nil {
+		return fmt.Errorf("write index.json: %w", err)
+	}
+	return nil
+}
+
+func indexOfRefName(descs []v1.Descriptor, refName string) int {
+	for i, desc := range descs {
+		if desc.Annotations[AnnotationRefName] == refName {
+			return i
+		}
+	}
+	return -1
+}
@@ -20,6 +20,13 @@ func WithAuth(auth authn.Authenticator) func(po *options) error {
 	}
 }
 
+func WithKeychain(keychain authn.Keychain) func(po *options) error {
+	return func(o *options) error {
+		o.opts = append(o.opts, remote.WithAuthFromKeychain(keychain))
+		return nil
+	}
+}
+
 func WithTransport(t http.RoundTripper) func(po *options) error {
 	return func(o *options) error {
 		o.opts = append(o.opts, remote.WithTransport(t))
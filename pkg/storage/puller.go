@@ -6,6 +6,9 @@ import (
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/wzshiming/jitdi/pkg/tracing"
 )
 
 type Puller struct {
@@ -37,10 +40,18 @@ func (p *Puller) Head(ctx context.Context, ref name.Reference) (*v1.Descriptor,
 	return p.puller.Head(ctx, ref)
 }
 
-func (p *Puller) Get(ctx context.Context, ref name.Reference) (*remote.Descriptor, error) {
+func (p *Puller) Get(ctx context.Context, ref name.Reference) (desc *remote.Descriptor, err error) {
+	ctx, span := tracing.Start(ctx, "Puller.Get", attribute.String("jitdi.reference", ref.String()))
+	defer func() {
+		tracing.End(span, err)
+	}()
 	return p.puller.Get(ctx, ref)
 }
 
 func (p *Puller) Layer(ctx context.Context, ref name.Digest) (v1.Layer, error) {
 	return p.puller.Layer(ctx, ref)
 }
+
+func (p *Puller) List(ctx context.Context, repo name.Repository) ([]string, error) {
+	return p.puller.List(ctx, repo)
+}
@@ -6,6 +6,9 @@ import (
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/wzshiming/jitdi/pkg/tracing"
 )
 
 type Pusher interface {
@@ -89,3 +92,32 @@ func (p *pusher) PushImageIndex(ctx context.Context, ref name.Reference, imageIn
 	}
 	return nil
 }
+
+// tracePusher traces the pushes of the pusher.
+type tracePusher struct {
+	pusher Pusher
+}
+
+func (p *tracePusher) PushImage(ctx context.Context, ref name.Reference, image v1.Image) (err error) {
+	ctx, span := tracing.Start(ctx, "Pusher.PushImage", attribute.String("jitdi.reference", ref.String()))
+	defer func() {
+		tracing.End(span, err)
+	}()
+	return p.pusher.PushImage(ctx, ref, image)
+}
+
+func (p *tracePusher) PushImageWithIndex(ctx context.Context, repo name.Repository, image v1.Image) (err error) {
+	ctx, span := tracing.Start(ctx, "Pusher.PushImageWithIndex", attribute.String("jitdi.repository", repo.String()))
+	defer func() {
+		tracing.End(span, err)
+	}()
+	return p.pusher.PushImageWithIndex(ctx, repo, image)
+}
+
+func (p *tracePusher) PushImageIndex(ctx context.Context, ref name.Reference, imageIndex v1.ImageIndex) (err error) {
+	ctx, span := tracing.Start(ctx, "Pusher.PushImageIndex", attribute.String("jitdi.reference", ref.String()))
+	defer func() {
+		tracing.End(span, err)
+	}()
+	return p.pusher.PushImageIndex(ctx, ref, imageIndex)
+}
@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// Image returns the image of the manifest, the blobs are read lazily from the storage.
+func Image(ctx context.Context, s Storage, repo string, manifest *Manifest) (v1.Image, error) {
+	if !manifest.MediaType.IsImage() {
+		return nil, fmt.Errorf("unexpected media type %q for image", manifest.MediaType)
+	}
+	return partial.CompressedToImage(&storageImage{
+		ctx:      ctx,
+		storage:  s,
+		repo:     repo,
+		manifest: manifest,
+	})
+}
+
+// ImageIndex returns the image index of the manifest, the manifests are read lazily from the storage.
+func ImageIndex(ctx context.Context, s Storage, repo string, manifest *Manifest) (v1.ImageIndex, error) {
+	if !manifest.MediaType.IsIndex() {
+		return nil, fmt.Errorf("unexpected media type %q for image index", manifest.MediaType)
+	}
+	return &storageIndex{
+		ctx:      ctx,
+		storage:  s,
+		repo:     repo,
+		manifest: manifest,
+	}, nil
+}
+
+func readBlob(ctx context.Context, s Storage, repo string, digest v1.Hash) ([]byte, error) {
+	rc, _, err := s.GetBlob(ctx, repo, digest)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+type storageImage struct {
+	ctx      context.Context
+	storage  Storage
+	repo     string
+	manifest *Manifest
+}
+
+func (i *storageImage) RawManifest() ([]byte, error) {
+	return i.manifest.Data, nil
+}
+
+func (i *storageImage) MediaType() (types.MediaType, error) {
+	return i.manifest.MediaType, nil
+}
+
+func (i *storageImage) RawConfigFile() ([]byte, error) {
+	m, err := v1.ParseManifest(bytes.NewReader(i.manifest.Data))
+	if err != nil {
+		return nil, err
+	}
+	return readBlob(i.ctx, i.storage, i.repo, m.Config.Digest)
+}
+
+func (i *storageImage) LayerByDigest(digest v1.Hash) (partial.CompressedLayer, error) {
+	m, err := v1.ParseManifest(bytes.NewReader(i.manifest.Data))
+	if err != nil {
+		return nil, err
+	}
+	if m.Config.Digest == digest {
+		return &storageBlob{
+			ctx:     i.ctx,
+			storage: i.storage,
+			repo:    i.repo,
+			desc:    m.Config,
+		}, nil
+	}
+	for _, layer := range m.Layers {
+		if layer.Digest == digest {
+			return &storageBlob{
+				ctx:     i.ctx,
+				storage: i.storage,
+				repo:    i.repo,
+				desc:    layer,
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("blob %v not found in manifest", digest)
+}
+
+type storageBlob struct {
+	ctx     context.Context
+	storage Storage
+	repo    string
+	desc    v1.Descriptor
+}
+
+func (b *storageBlob) Digest() (v1.Hash, error) {
+	return b.desc.Digest, nil
+}
+
+func (b *storageBlob) Size() (int64, error) {
+	return b.desc.Size, nil
+}
+
+func (b *storageBlob) MediaType() (types.MediaType, error) {
+	return b.desc.MediaType, nil
+}
+
+func (b *storageBlob) Compressed() (io.ReadCloser, error) {
+	rc, _, err := b.storage.GetBlob(b.ctx, b.repo, b.desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	return rc, nil
+}
+
+type storageIndex struct {
+	ctx      context.Context
+	storage  Storage
+	repo     string
+	manifest *Manifest
+}
+
+func (i *storageIndex) MediaType() (types.MediaType, error) {
+	return i.manifest.MediaType, nil
+}
+
+func (i *storageIndex) Digest() (v1.Hash, error) {
+	return i.manifest.Digest(), nil
+}
+
+func (i *storageIndex) Size() (int64, error) {
+	return int64(len(i.manifest.Data)), nil
+}
+
+func (i *storageIndex) IndexManifest() (*v1.IndexManifest, error) {
+	return v1.ParseIndexManifest(bytes.NewReader(i.manifest.Data))
+}
+
+func (i *storageIndex) RawManifest() ([]byte, error) {
+	return i.manifest.Data, nil
+}
+
+func (i *storageIndex) Image(digest v1.Hash) (v1.Image, error) {
+	manifest, err := i.storage.GetManifest(i.ctx, i.repo, digest.String())
+	if err != nil {
+		return nil, err
+	}
+	return Image(i.ctx, i.storage, i.repo, manifest)
+}
+
+func (i *storageIndex) ImageIndex(digest v1.Hash) (v1.ImageIndex, error) {
+	manifest, err := i.storage.GetManifest(i.ctx, i.repo, digest.String())
+	if err != nil {
+		return nil, err
+	}
+	return ImageIndex(i.ctx, i.storage, i.repo, manifest)
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// referrersMut serializes the updates of the referrers indexes.
+var referrersMut sync.Mutex
+
+// ReferrersTag returns the tag of the referrers index of the digest,
+// as the referrers tag schema of the registries without the referrers API.
+func ReferrersTag(digest v1.Hash) string {
+	return digest.Algorithm + "-" + digest.Hex
+}
+
+// IsReferrersTag reports whether the tag is the referrers tag of a digest.
+func IsReferrersTag(tag string) bool {
+	algorithm, hex, ok := strings.Cut(tag, "-")
+	if !ok {
+		return false
+	}
+	_, err := v1.NewHash(algorithm + ":" + hex)
+	return err == nil
+}
+
+// Referrers returns the index of the manifests referring to the digest, it is empty if there is none.
+func Referrers(ctx context.Context, s Storage, repo string, digest v1.Hash) (*v1.IndexManifest, error) {
+	index := &v1.IndexManifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIImageIndex,
+		Manifests:     []v1.Descriptor{},
+	}
+
+	m, err := s.GetManifest(ctx, repo, ReferrersTag(digest))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return index, nil
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal(m.Data, index)
+	if err != nil {
+		return nil, fmt.Errorf("decode referrers of %s: %w", digest, err)
+	}
+	return index, nil
+}
+
+// AddReferrer adds the manifest to the referrers index of its subject,
+// it reports whether the manifest has a subject.
+func AddReferrer(ctx context.Context, s Storage, repo string, m *Manifest) (bool, error) {
+	subject, desc, err := parseReferrer(m)
+	if err != nil {
+		return false, err
+	}
+	if subject == nil {
+		return false, nil
+	}
+
+	referrersMut.Lock()
+	defer referrersMut.Unlock()
+
+	index, err := Referrers(ctx, s, repo, subject.Digest)
+	if err != nil {
+		return false, err
+	}
+	if slices.ContainsFunc(index.Manifests, func(d v1.Descriptor) bool {
+		return d.Digest == desc.Digest
+	}) {
+		return true, nil
+	}
+	index.Manifests = append(index.Manifests, desc)
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		return false, err
+	}
+	err = s.PutManifest(ctx, repo, ReferrersTag(subject.Digest), &Manifest{
+		MediaType: types.OCIImageIndex,
+		Data:      data,
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// parseReferrer returns the subject of the manifest and its descriptor in the referrers index.
+func parseReferrer(m *Manifest) (*v1.Descriptor, v1.Descriptor, error) {
+	referrer := struct {
+		ArtifactType types.MediaType   `json:"artifactType,omitempty"`
+		Config       *v1.Descriptor    `json:"config,omitempty"`
+		Subject      *v1.Descriptor    `json:"subject,omitempty"`
+		Annotations  map[string]string `json:"annotations,omitempty"`
+	}{}
+	err := json.Unmarshal(m.Data, &referrer)
+	if err != nil {
+		return nil, v1.Descriptor{}, fmt.Errorf("decode manifest: %w", err)
+	}
+
+	desc := m.Descriptor()
+	desc.ArtifactType = string(referrer.ArtifactType)
+	if desc.ArtifactType == "" && referrer.Config != nil {
+		desc.ArtifactType = string(referrer.Config.MediaType)
+	}
+	desc.Annotations = referrer.Annotations
+	return referrer.Subject, desc, nil
+}
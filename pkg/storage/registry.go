@@ -0,0 +1,266 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+type registryStorage struct {
+	base     string
+	nameOpts []name.Option
+
+	puller *Puller
+	pusher *pusher
+}
+
+// NewRegistryStorage returns a storage using a remote registry,
+// the repositories are stored under the base, e.g. registry.local:5000/prefix.
+func NewRegistryStorage(base string, insecure bool, opts ...option) (Storage, error) {
+	puller, err := NewPuller(opts...)
+	if err != nil {
+		return nil, err
+	}
+	p, err := NewPusher(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	var nameOpts []name.Option
+	if insecure {
+		nameOpts = append(nameOpts, name.Insecure)
+	}
+
+	return &registryStorage{
+		base:     base,
+		nameOpts: nameOpts,
+		puller:   puller,
+		pusher:   p.(*pusher),
+	}, nil
+}
+
+func (s *registryStorage) repository(repo string) (name.Repository, error) {
+	return name.NewRepository(s.base+"/"+repo, s.nameOpts...)
+}
+
+func (s *registryStorage) reference(repo, reference string) (name.Reference, error) {
+	if IsDigest(reference) {
+		return name.NewDigest(s.base+"/"+repo+"@"+reference, s.nameOpts...)
+	}
+	return name.NewTag(s.base+"/"+repo+":"+reference, s.nameOpts...)
+}
+
+func (s *registryStorage) rebase(ref name.Reference) (name.Reference, error) {
+	return s.reference(ref.Context().RepositoryStr(), ref.Identifier())
+}
+
+func (s *registryStorage) StatBlob(ctx context.Context, repo string, digest v1.Hash) (*v1.Descriptor, error) {
+	layer, err := s.layer(ctx, repo, digest)
+	if err != nil {
+		return nil, err
+	}
+	size, err := layer.Size()
+	if err != nil {
+		return nil, toNotFound(err)
+	}
+	return &v1.Descriptor{
+		Digest: digest,
+		Size:   size,
+	}, nil
+}
+
+func (s *registryStorage) GetBlob(ctx context.Context, repo string, digest v1.Hash) (io.ReadCloser, *v1.Descriptor, error) {
+	layer, err := s.layer(ctx, repo, digest)
+	if err != nil {
+		return nil, nil, err
+	}
+	size, err := layer.Size()
+	if err != nil {
+		return nil, nil, toNotFound(err)
+	}
+	r, err := layer.Compressed()
+	if err != nil {
+		return nil, nil, toNotFound(err)
+	}
+	return r, &v1.Descriptor{
+		Digest: digest,
+		Size:   size,
+	}, nil
+}
+
+func (s *registryStorage) layer(ctx context.Context, repo string, digest v1.Hash) (v1.Layer, error) {
+	ref, err := s.reference(repo, digest.String())
+	if err != nil {
+		return nil, err
+	}
+	layer, err := s.puller.Layer(ctx, ref.(name.Digest))
+	if err != nil {
+		return nil, toNotFound(err)
+	}
+	return layer, nil
+}
+
+func (s *registryStorage) PutBlob(ctx context.Context, repo string, r io.Reader) (*v1.Descriptor, error) {
+	// The digest must be known before the upload is committed, so spool it to disk first.
+	dir, err := os.MkdirTemp("", "jitdi-blob-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	desc, err := putBlobFile(dir, r, func(digest v1.Hash) string {
+		return path.Join(dir, digest.Hex)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	layer, err := partial.CompressedToLayer(&fileBlob{
+		path: path.Join(dir, desc.Digest.Hex),
+		desc: desc,
+	})
+	if err != nil {
+		return nil, err
+	}
+	repository, err := s.repository(repo)
+	if err != nil {
+		return nil, err
+	}
+	err = s.pusher.pusher.Upload(ctx, repository, layer)
+	if err != nil {
+		return nil, err
+	}
+	return desc, nil
+}
+
+func (s *registryStorage) GetManifest(ctx context.Context, repo, reference string) (*Manifest, error) {
+	ref, err := s.reference(repo, reference)
+	if err != nil {
+		return nil, err
+	}
+	desc, err := s.puller.Get(ctx, ref)
+	if err != nil {
+		return nil, toNotFound(err)
+	}
+	return &Manifest{
+		MediaType: desc.MediaType,
+		Data:      desc.Manifest,
+	}, nil
+}
+
+func (s *registryStorage) PutManifest(ctx context.Context, repo, reference string, manifest *Manifest) error {
+	ref, err := s.reference(repo, reference)
+	if err != nil {
+		return err
+	}
+	return s.pusher.pusher.Push(ctx, ref, rawManifest{manifest: manifest})
+}
+
+// Check returns an error if the registry is not reachable,
+// a manifest not found is the response of the reachable registry.
+func (s *registryStorage) Check(ctx context.Context) error {
+	_, err := s.GetManifest(ctx, "jitdi/readyz", "latest")
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+func (s *registryStorage) List(ctx context.Context, repo string) ([]string, error) {
+	repository, err := s.repository(repo)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := s.puller.List(ctx, repository)
+	if err != nil {
+		return nil, toNotFound(err)
+	}
+	return tags, nil
+}
+
+func (s *registryStorage) Delete(ctx context.Context, repo, reference string) error {
+	ref, err := s.reference(repo, reference)
+	if err != nil {
+		return err
+	}
+	err = s.pusher.pusher.Delete(ctx, ref)
+	if err != nil {
+		return toNotFound(err)
+	}
+	return nil
+}
+
+func (s *registryStorage) PushImage(ctx context.Context, ref name.Reference, image v1.Image) error {
+	ref, err := s.rebase(ref)
+	if err != nil {
+		return err
+	}
+	return s.pusher.PushImage(ctx, ref, image)
+}
+
+func (s *registryStorage) PushImageWithIndex(ctx context.Context, repo name.Repository, image v1.Image) error {
+	repository, err := s.repository(repo.RepositoryStr())
+	if err != nil {
+		return err
+	}
+	return s.pusher.PushImageWithIndex(ctx, repository, image)
+}
+
+func (s *registryStorage) PushImageIndex(ctx context.Context, ref name.Reference, imageIndex v1.ImageIndex) error {
+	ref, err := s.rebase(ref)
+	if err != nil {
+		return err
+	}
+	return s.pusher.PushImageIndex(ctx, ref, imageIndex)
+}
+
+type rawManifest struct {
+	manifest *Manifest
+}
+
+func (r rawManifest) RawManifest() ([]byte, error) {
+	return r.manifest.Data, nil
+}
+
+func (r rawManifest) MediaType() (types.MediaType, error) {
+	return r.manifest.MediaType, nil
+}
+
+type fileBlob struct {
+	path string
+	desc *v1.Descriptor
+}
+
+func (f *fileBlob) Digest() (v1.Hash, error) {
+	return f.desc.Digest, nil
+}
+
+func (f *fileBlob) Size() (int64, error) {
+	return f.desc.Size, nil
+}
+
+func (f *fileBlob) Compressed() (io.ReadCloser, error) {
+	return os.Open(f.path)
+}
+
+func (f *fileBlob) MediaType() (types.MediaType, error) {
+	return types.OCILayer, nil
+}
+
+func toNotFound(err error) error {
+	var terr *transport.Error
+	if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	return err
+}
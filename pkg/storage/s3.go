@@ -0,0 +1,263 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// s3PartSize is the part size of multipart uploads with unknown size.
+const s3PartSize = 64 << 20
+
+type s3Storage struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3Storage returns a storage using an S3-compatible object store.
+//
+//	s3://[access-key:secret-key@]bucket/prefix?endpoint=minio:9000&region=us-east-1&insecure=true
+//
+// Without the credentials in the URL, they are read from the environment.
+func NewS3Storage(u *url.URL) (Storage, error) {
+	query := u.Query()
+
+	endpoint := query.Get("endpoint")
+	if endpoint == "" {
+		endpoint = "s3.amazonaws.com"
+	}
+
+	var creds *credentials.Credentials
+	if u.User != nil {
+		secret, _ := u.User.Password()
+		creds = credentials.NewStaticV4(u.User.Username(), secret, "")
+	} else {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{},
+		})
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: query.Get("insecure") != "true",
+		Region: query.Get("region"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("new s3 client: %w", err)
+	}
+
+	return &s3Storage{
+		client: client,
+		bucket: u.Host,
+		prefix: strings.Trim(u.Path, "/"),
+	}, nil
+}
+
+func (s *s3Storage) blobKey(digest v1.Hash) string {
+	return path.Join(s.prefix, "blobs", digest.Algorithm, digest.Hex)
+}
+
+func (s *s3Storage) tagsKey(repo string) string {
+	return path.Join(s.prefix, "repositories", repo, "tags") + "/"
+}
+
+func (s *s3Storage) tagKey(repo, tag string) string {
+	return s.tagsKey(repo) + tag
+}
+
+func (s *s3Storage) StatBlob(ctx context.Context, repo string, digest v1.Hash) (*v1.Descriptor, error) {
+	info, err := s.client.StatObject(ctx, s.bucket, s.blobKey(digest), minio.StatObjectOptions{})
+	if err != nil {
+		return nil, s3NotFound(err)
+	}
+	return &v1.Descriptor{
+		MediaType: types.MediaType(info.ContentType),
+		Digest:    digest,
+		Size:      info.Size,
+	}, nil
+}
+
+func (s *s3Storage) GetBlob(ctx context.Context, repo string, digest v1.Hash) (io.ReadCloser, *v1.Descriptor, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, s.blobKey(digest), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, nil, s3NotFound(err)
+	}
+	info, err := obj.Stat()
+	if err != nil {
+		_ = obj.Close()
+		return nil, nil, s3NotFound(err)
+	}
+	return obj, &v1.Descriptor{
+		MediaType: types.MediaType(info.ContentType),
+		Digest:    digest,
+		Size:      info.Size,
+	}, nil
+}
+
+func (s *s3Storage) PutBlob(ctx context.Context, repo string, r io.Reader) (*v1.Descriptor, error) {
+	// The key is only known after the content is hashed, so upload it to a temporary key first.
+	var id [16]byte
+	_, err := rand.Read(id[:])
+	if err != nil {
+		return nil, err
+	}
+	uploadKey := path.Join(s.prefix, "uploads", hex.EncodeToString(id[:]))
+
+	hash := sha256.New()
+	info, err := s.client.PutObject(ctx, s.bucket, uploadKey, io.TeeReader(r, hash), -1, minio.PutObjectOptions{
+		PartSize: s3PartSize,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("put object %q: %w", uploadKey, err)
+	}
+	defer func() {
+		_ = s.client.RemoveObject(context.Background(), s.bucket, uploadKey, minio.RemoveObjectOptions{})
+	}()
+
+	digest := v1.Hash{
+		Algorithm: "sha256",
+		Hex:       hex.EncodeToString(hash.Sum(nil)),
+	}
+	desc := &v1.Descriptor{
+		Digest: digest,
+		Size:   info.Size,
+	}
+
+	_, err = s.StatBlob(ctx, repo, digest)
+	if err == nil {
+		return desc, nil
+	}
+
+	_, err = s.client.ComposeObject(ctx,
+		minio.CopyDestOptions{
+			Bucket: s.bucket,
+			Object: s.blobKey(digest),
+		},
+		minio.CopySrcOptions{
+			Bucket: s.bucket,
+			Object: uploadKey,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("compose object %q: %w", s.blobKey(digest), err)
+	}
+	return desc, nil
+}
+
+func (s *s3Storage) GetManifest(ctx context.Context, repo, reference string) (*Manifest, error) {
+	digest, err := s.resolve(ctx, repo, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := s.client.GetObject(ctx, s.bucket, s.blobKey(digest), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, s3NotFound(err)
+	}
+	defer obj.Close()
+
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		return nil, s3NotFound(err)
+	}
+	return NewManifest(data)
+}
+
+func (s *s3Storage) resolve(ctx context.Context, repo, reference string) (v1.Hash, error) {
+	if IsDigest(reference) {
+		return v1.NewHash(reference)
+	}
+
+	obj, err := s.client.GetObject(ctx, s.bucket, s.tagKey(repo, reference), minio.GetObjectOptions{})
+	if err != nil {
+		return v1.Hash{}, s3NotFound(err)
+	}
+	defer obj.Close()
+
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		return v1.Hash{}, s3NotFound(err)
+	}
+	return v1.NewHash(strings.TrimSpace(string(data)))
+}
+
+func (s *s3Storage) PutManifest(ctx context.Context, repo, reference string, manifest *Manifest) error {
+	digest := manifest.Digest()
+	_, err := s.client.PutObject(ctx, s.bucket, s.blobKey(digest), bytes.NewReader(manifest.Data), int64(len(manifest.Data)), minio.PutObjectOptions{
+		ContentType: string(manifest.MediaType),
+	})
+	if err != nil {
+		return fmt.Errorf("put manifest: %w", err)
+	}
+
+	if IsDigest(reference) {
+		return nil
+	}
+
+	tag := []byte(digest.String())
+	_, err = s.client.PutObject(ctx, s.bucket, s.tagKey(repo, reference), bytes.NewReader(tag), int64(len(tag)), minio.PutObjectOptions{
+		ContentType: "text/plain",
+	})
+	if err != nil {
+		return fmt.Errorf("put tag: %w", err)
+	}
+	return nil
+}
+
+func (s *s3Storage) List(ctx context.Context, repo string) ([]string, error) {
+	prefix := s.tagsKey(repo)
+	tags := []string{}
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix: prefix,
+	}) {
+		if obj.Err != nil {
+			return nil, s3NotFound(obj.Err)
+		}
+		tags = append(tags, strings.TrimPrefix(obj.Key, prefix))
+	}
+	if len(tags) == 0 {
+		return nil, ErrNotFound
+	}
+	return tags, nil
+}
+
+func (s *s3Storage) Delete(ctx context.Context, repo, reference string) error {
+	key := s.tagKey(repo, reference)
+	if IsDigest(reference) {
+		digest, err := v1.NewHash(reference)
+		if err != nil {
+			return err
+		}
+		key = s.blobKey(digest)
+	}
+
+	_, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return s3NotFound(err)
+	}
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+func s3NotFound(err error) error {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NoSuchBucket":
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	return err
+}
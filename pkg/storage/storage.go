@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// ErrNotFound is returned when a blob, manifest or tag does not exist in the storage.
+var ErrNotFound = errors.New("not found")
+
+// Storage is a read/write backend for blobs and manifests.
+type Storage interface {
+	// StatBlob returns the descriptor of the blob.
+	StatBlob(ctx context.Context, repo string, digest v1.Hash) (*v1.Descriptor, error)
+	// GetBlob returns the content of the blob.
+	// If the returned reader implements io.Seeker it can be used to serve range requests.
+	GetBlob(ctx context.Context, repo string, digest v1.Hash) (io.ReadCloser, *v1.Descriptor, error)
+	// PutBlob stores the content read from r and returns its descriptor.
+	PutBlob(ctx context.Context, repo string, r io.Reader) (*v1.Descriptor, error)
+
+	// GetManifest returns the manifest by tag or digest.
+	GetManifest(ctx context.Context, repo, reference string) (*Manifest, error)
+	// PutManifest stores the manifest, and tags it if the reference is a tag.
+	PutManifest(ctx context.Context, repo, reference string, manifest *Manifest) error
+
+	// List returns the tags of the repository.
+	List(ctx context.Context, repo string) ([]string, error)
+	// Delete deletes the tag, or the blob or manifest if the reference is a digest.
+	Delete(ctx context.Context, repo, reference string) error
+}
+
+// Checker is implemented by the storages on the remote services, to check they are reachable.
+type Checker interface {
+	// Check returns an error if the storage is not reachable.
+	Check(ctx context.Context) error
+}
+
+// Manifest is a raw manifest with its media type.
+type Manifest struct {
+	MediaType types.MediaType
+	Data      []byte
+}
+
+// Digest returns the digest of the manifest.
+func (m *Manifest) Digest() v1.Hash {
+	h, _, _ := v1.SHA256(bytes.NewReader(m.Data))
+	return h
+}
+
+// Descriptor returns the descriptor of the manifest.
+func (m *Manifest) Descriptor() v1.Descriptor {
+	return v1.Descriptor{
+		MediaType: m.MediaType,
+		Digest:    m.Digest(),
+		Size:      int64(len(m.Data)),
+	}
+}
+
+// NewManifest returns a manifest and detects its media type from the content.
+func NewManifest(data []byte) (*Manifest, error) {
+	mediaType := struct {
+		MediaType types.MediaType `json:"mediaType,omitempty"`
+	}{}
+	err := json.Unmarshal(data, &mediaType)
+	if err != nil {
+		return nil, fmt.Errorf("decode manifest: %w", err)
+	}
+	return &Manifest{
+		MediaType: mediaType.MediaType,
+		Data:      data,
+	}, nil
+}
+
+// IsDigest returns true if the reference is a digest rather than a tag.
+func IsDigest(reference string) bool {
+	return strings.HasPrefix(reference, "sha256:")
+}
+
+// NewStorage returns the storage for the URL.
+//
+//	file:///var/cache/jitdi                     the jitdi cache layout
+//	oci:///var/lib/jitdi                        an OCI image-layout directory
+//	s3://bucket/prefix?endpoint=minio:9000      an S3-compatible object store
+//	registry://registry.local:5000/prefix       a remote registry
+func NewStorage(rawURL string, opts ...option) (Storage, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse storage url %q: %w", rawURL, err)
+	}
+
+	switch u.Scheme {
+	case "", "file":
+		return NewLocalStorage(path.Join(u.Path, "blobs"), path.Join(u.Path, "manifests")), nil
+	case "oci":
+		return NewOCILayoutStorage(u.Path)
+	case "s3":
+		return NewS3Storage(u)
+	case "registry":
+		insecure := u.Query().Get("insecure") == "true"
+		return NewRegistryStorage(u.Host+u.Path, insecure, opts...)
+	default:
+		return nil, fmt.Errorf("unsupported storage scheme %q", u.Scheme)
+	}
+}
+
+// NewStoragePusher returns a pusher that writes into the storage, the pushes are traced.
+func NewStoragePusher(s Storage) Pusher {
+	if p, ok := s.(Pusher); ok {
+		return &tracePusher{pusher: p}
+	}
+	return &tracePusher{
+		pusher: &storagePusher{
+			storage: s,
+		},
+	}
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/wzshiming/jitdi/pkg/metrics"
+	"github.com/wzshiming/jitdi/pkg/tracing"
+)
+
+// Write writes the image or image index and everything it references into the storage.
+func Write(ctx context.Context, s Storage, repo, reference string, t partial.Describable) error {
+	p := &storagePusher{storage: s}
+	switch t := t.(type) {
+	case v1.ImageIndex:
+		return p.writeIndex(ctx, repo, reference, t)
+	case v1.Image:
+		return p.writeImage(ctx, repo, reference, t)
+	default:
+		return fmt.Errorf("unsupported type %T", t)
+	}
+}
+
+type storagePusher struct {
+	storage Storage
+}
+
+func (p *storagePusher) PushImage(ctx context.Context, ref name.Reference, image v1.Image) error {
+	return p.writeImage(ctx, ref.Context().RepositoryStr(), ref.Identifier(), image)
+}
+
+func (p *storagePusher) PushImageWithIndex(ctx context.Context, repo name.Repository, image v1.Image) error {
+	err := p.pushImageBlobs(ctx, repo.RepositoryStr(), image)
+	if err != nil {
+		return err
+	}
+
+	// The digest of the streamed layers is known after they are pushed.
+	digest, err := image.Digest()
+	if err != nil {
+		return fmt.Errorf("getting digest: %w", err)
+	}
+	return p.putManifest(ctx, repo.RepositoryStr(), digest.String(), image)
+}
+
+func (p *storagePusher) PushImageIndex(ctx context.Context, ref name.Reference, imageIndex v1.ImageIndex) error {
+	return p.putManifest(ctx, ref.Context().RepositoryStr(), ref.Identifier(), imageIndex)
+}
+
+func (p *storagePusher) writeImage(ctx context.Context, repo, reference string, image v1.Image) error {
+	err := p.pushImageBlobs(ctx, repo, image)
+	if err != nil {
+		return err
+	}
+	return p.putManifest(ctx, repo, reference, image)
+}
+
+func (p *storagePusher) writeIndex(ctx context.Context, repo, reference string, index v1.ImageIndex) error {
+	indexManifest, err := index.IndexManifest()
+	if err != nil {
+		return err
+	}
+	for _, desc := range indexManifest.Manifests {
+		switch {
+		case desc.MediaType.IsIndex():
+			child, err := index.ImageIndex(desc.Digest)
+			if err != nil {
+				return err
+			}
+			err = p.writeIndex(ctx, repo, desc.Digest.String(), child)
+			if err != nil {
+				return err
+			}
+		case desc.MediaType.IsImage():
+			child, err := index.Image(desc.Digest)
+			if err != nil {
+				return err
+			}
+			err = p.writeImage(ctx, repo, desc.Digest.String(), child)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return p.putManifest(ctx, repo, reference, index)
+}
+
+type rawManifester interface {
+	partial.WithRawManifest
+	MediaType() (types.MediaType, error)
+}
+
+func (p *storagePusher) putManifest(ctx context.Context, repo, reference string, t rawManifester) error {
+	manifestBlob, err := t.RawManifest()
+	if err != nil {
+		return fmt.Errorf("getting raw manifest: %w", err)
+	}
+	mediaType, err := t.MediaType()
+	if err != nil {
+		return fmt.Errorf("getting media type: %w", err)
+	}
+
+	return p.storage.PutManifest(ctx, repo, reference, &Manifest{
+		MediaType: mediaType,
+		Data:      manifestBlob,
+	})
+}
+
+func (p *storagePusher) pushImageBlobs(ctx context.Context, repo string, image v1.Image) error {
+	layers, err := image.Layers()
+	if err != nil {
+		return err
+	}
+
+	for _, layer := range layers {
+		err = p.pushLayer(ctx, repo, layer)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Write the config.
+	configBlob, err := image.RawConfigFile()
+	if err != nil {
+		return fmt.Errorf("getting raw config file: %w", err)
+	}
+
+	_, err = p.storage.PutBlob(ctx, repo, bytes.NewReader(configBlob))
+	if err != nil {
+		return fmt.Errorf("write config: %w", err)
+	}
+	return nil
+}
+
+func (p *storagePusher) pushLayer(ctx context.Context, repo string, layer v1.Layer) (retErr error) {
+	ctx, span := tracing.Start(ctx, "pushLayer")
+	defer func() {
+		tracing.End(span, retErr)
+	}()
+
+	digest, err := layer.Digest()
+	if err == nil {
+		desc, err := p.storage.StatBlob(ctx, repo, digest)
+		if err == nil {
+			size, err := layer.Size()
+			if err == nil && desc.Size == size {
+				slog.Info("hit layer", "digest", digest, "size", size)
+				metrics.ObserveCache(metrics.CacheBlob, true)
+				span.SetAttributes(
+					attribute.String("jitdi.digest", digest.String()),
+					attribute.Bool("jitdi.cache_hit", true),
+				)
+				return nil
+			}
+		}
+	}
+	metrics.ObserveCache(metrics.CacheBlob, false)
+
+	r, err := layer.Compressed()
+	if err != nil {
+		return fmt.Errorf("getting compressed: %w", err)
+	}
+	defer r.Close()
+
+	desc, err := p.storage.PutBlob(ctx, repo, r)
+	if err != nil {
+		return fmt.Errorf("write layer: %w", err)
+	}
+
+	slog.Info("save layer", "digest", desc.Digest, "size", desc.Size)
+	span.SetAttributes(
+		attribute.String("jitdi.digest", desc.Digest.String()),
+		attribute.Int64("jitdi.size", desc.Size),
+	)
+	return nil
+}
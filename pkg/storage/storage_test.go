@@ -0,0 +1,411 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/go-containerregistry/pkg/v1/validate"
+	"github.com/minio/minio-go/v7"
+
+	"github.com/wzshiming/jitdi/pkg/atomic"
+)
+
+func TestStorage(t *testing.T) {
+	dir := t.TempDir()
+	oci, err := NewOCILayoutStorage(path.Join(dir, "oci"))
+	if err != nil {
+		t.Fatalf("NewOCILayoutStorage() error = %v", err)
+	}
+
+	reg := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer reg.Close()
+	remote, err := NewStorage("registry://" + strings.TrimPrefix(reg.URL, "http://") + "/prefix?insecure=true")
+	if err != nil {
+		t.Fatalf("NewStorage() error = %v", err)
+	}
+
+	type test struct {
+		name    string
+		storage Storage
+	}
+	tests := []test{
+		{
+			name:    "local",
+			storage: NewLocalStorage(path.Join(dir, "local", "blobs"), path.Join(dir, "local", "manifests")),
+		},
+		{
+			name:    "oci",
+			storage: oci,
+		},
+		{
+			name:    "registry",
+			storage: remote,
+		},
+	}
+
+	// The S3 storage is tested against a MinIO or S3 bucket of the URL, e.g.
+	//
+	//	docker run -d -p 9000:9000 minio/minio server /data
+	//	JITDI_TEST_S3_URL="s3://minioadmin:minioadmin@jitdi-test?endpoint=localhost:9000&insecure=true" go test ./pkg/storage
+	if rawURL := os.Getenv("JITDI_TEST_S3_URL"); rawURL != "" {
+		tests = append(tests, test{
+			name:    "s3",
+			storage: newTestS3Storage(t, rawURL),
+		})
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := tt.storage
+
+			desc, err := s.PutBlob(ctx, "foo/bar", strings.NewReader("hello"))
+			if err != nil {
+				t.Fatalf("PutBlob() error = %v", err)
+			}
+			if desc.Digest.Hex != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" || desc.Size != 5 {
+				t.Fatalf("PutBlob() got = %v", desc)
+			}
+
+			rc, _, err := s.GetBlob(ctx, "foo/bar", desc.Digest)
+			if err != nil {
+				t.Fatalf("GetBlob() error = %v", err)
+			}
+			data, _ := io.ReadAll(rc)
+			_ = rc.Close()
+			if string(data) != "hello" {
+				t.Fatalf("GetBlob() got = %q", data)
+			}
+
+			manifest := &Manifest{
+				MediaType: types.OCIManifestSchema1,
+				Data:      []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`),
+			}
+			err = s.PutManifest(ctx, "foo/bar", "v1", manifest)
+			if err != nil {
+				t.Fatalf("PutManifest() error = %v", err)
+			}
+
+			for _, ref := range []string{"v1", manifest.Digest().String()} {
+				got, err := s.GetManifest(ctx, "foo/bar", ref)
+				if err != nil {
+					t.Fatalf("GetManifest(%q) error = %v", ref, err)
+				}
+				if !reflect.DeepEqual(got, manifest) {
+					t.Fatalf("GetManifest(%q) got = %v, want %v", ref, got, manifest)
+				}
+			}
+
+			tags, err := s.List(ctx, "foo/bar")
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if !reflect.DeepEqual(tags, []string{"v1"}) {
+				t.Fatalf("List() got = %v", tags)
+			}
+
+			err = s.Delete(ctx, "foo/bar", "v1")
+			if err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			_, err = s.GetManifest(ctx, "foo/bar", "v1")
+			if !errors.Is(err, ErrNotFound) {
+				t.Fatalf("GetManifest() after Delete() error = %v", err)
+			}
+		})
+	}
+}
+
+// newTestS3Storage returns the S3 storage of the URL under a new prefix, the bucket is created if it does not exist.
+func newTestS3Storage(t *testing.T, rawURL string) Storage {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("url.Parse() error = %v", err)
+	}
+	u.Path = path.Join(u.Path, strconv.FormatInt(time.Now().UnixNano(), 10))
+	s, err := NewS3Storage(u)
+	if err != nil {
+		t.Fatalf("NewS3Storage() error = %v", err)
+	}
+
+	ctx := context.Background()
+	client := s.(*s3Storage).client
+	exists, err := client.BucketExists(ctx, u.Host)
+	if err != nil {
+		t.Fatalf("BucketExists() error = %v", err)
+	}
+	if !exists {
+		err = client.MakeBucket(ctx, u.Host, minio.MakeBucketOptions{})
+		if err != nil {
+			t.Fatalf("MakeBucket() error = %v", err)
+		}
+	}
+	return s
+}
+
+func TestOCILayoutStorageCompatible(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewOCILayoutStorage(dir)
+	if err != nil {
+		t.Fatalf("NewOCILayoutStorage() error = %v", err)
+	}
+
+	img, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatalf("random.Image() error = %v", err)
+	}
+	ref, err := name.ParseReference("jitdi.local/foo/bar:v1")
+	if err != nil {
+		t.Fatalf("name.ParseReference() error = %v", err)
+	}
+	err = NewStoragePusher(s).PushImage(context.Background(), ref, img)
+	if err != nil {
+		t.Fatalf("PushImage() error = %v", err)
+	}
+
+	index, err := layout.ImageIndexFromPath(dir)
+	if err != nil {
+		t.Fatalf("layout.ImageIndexFromPath() error = %v", err)
+	}
+	err = validate.Index(index)
+	if err != nil {
+		t.Fatalf("validate.Index() error = %v", err)
+	}
+
+	indexManifest, err := index.IndexManifest()
+	if err != nil {
+		t.Fatalf("IndexManifest() error = %v", err)
+	}
+	if len(indexManifest.Manifests) != 1 {
+		t.Fatalf("IndexManifest() got %d manifests", len(indexManifest.Manifests))
+	}
+	if got := indexManifest.Manifests[0].Annotations[AnnotationRefName]; got != "foo/bar:v1" {
+		t.Fatalf("ref name got = %q", got)
+	}
+
+	want, _ := img.Digest()
+	if got := indexManifest.Manifests[0].Digest; got != want {
+		t.Fatalf("digest got = %v, want %v", got, want)
+	}
+}
+
+func TestWriteOCIArchive(t *testing.T) {
+	index, err := random.Index(1024, 2, 2)
+	if err != nil {
+		t.Fatalf("random.Index() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	err = WriteOCIArchive(&buf, "foo/bar:v1", index)
+	if err != nil {
+		t.Fatalf("WriteOCIArchive() error = %v", err)
+	}
+
+	dir := t.TempDir()
+	tr := tar.NewReader(&buf)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			t.Fatalf("tar.Reader.Next() error = %v", err)
+		}
+		data, _ := io.ReadAll(tr)
+		err = atomic.WriteFile(path.Join(dir, header.Name), data, 0644)
+		if err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+
+	got, err := layout.ImageIndexFromPath(dir)
+	if err != nil {
+		t.Fatalf("layout.ImageIndexFromPath() error = %v", err)
+	}
+	indexManifest, err := got.IndexManifest()
+	if err != nil {
+		t.Fatalf("IndexManifest() error = %v", err)
+	}
+	if len(indexManifest.Manifests) != 1 {
+		t.Fatalf("IndexManifest() got %d manifests", len(indexManifest.Manifests))
+	}
+
+	child, err := got.ImageIndex(indexManifest.Manifests[0].Digest)
+	if err != nil {
+		t.Fatalf("ImageIndex() error = %v", err)
+	}
+	err = validate.Index(child)
+	if err != nil {
+		t.Fatalf("validate.Index() error = %v", err)
+	}
+}
+
+func TestVerifyBlob(t *testing.T) {
+	dir := t.TempDir()
+	oci, err := NewOCILayoutStorage(path.Join(dir, "oci"))
+	if err != nil {
+		t.Fatalf("NewOCILayoutStorage() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		storage  Storage
+		blobPath func(digest v1.Hash) string
+	}{
+		{
+			name:    "local",
+			storage: NewLocalStorage(path.Join(dir, "local", "blobs"), path.Join(dir, "local", "manifests")),
+			blobPath: func(digest v1.Hash) string {
+				return LocalBlobPath(path.Join(dir, "local", "blobs"), digest.String())
+			},
+		},
+		{
+			name:    "oci",
+			storage: oci,
+			blobPath: func(digest v1.Hash) string {
+				return path.Join(dir, "oci", "blobs", digest.Algorithm, digest.Hex)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			v := tt.storage.(Verifiable)
+
+			good, err := tt.storage.PutBlob(ctx, "foo/bar", strings.NewReader("good"))
+			if err != nil {
+				t.Fatalf("PutBlob() error = %v", err)
+			}
+			bad, err := tt.storage.PutBlob(ctx, "foo/bar", strings.NewReader("bad"))
+			if err != nil {
+				t.Fatalf("PutBlob() error = %v", err)
+			}
+			err = os.WriteFile(tt.blobPath(bad.Digest), []byte("ba"), 0644)
+			if err != nil {
+				t.Fatalf("WriteFile() error = %v", err)
+			}
+
+			var digests []v1.Hash
+			err = v.WalkBlobs(ctx, func(digest v1.Hash) error {
+				digests = append(digests, digest)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("WalkBlobs() error = %v", err)
+			}
+			if len(digests) != 2 {
+				t.Fatalf("WalkBlobs() got = %v", digests)
+			}
+
+			err = v.VerifyBlob(ctx, good.Digest)
+			if err != nil {
+				t.Fatalf("VerifyBlob(good) error = %v", err)
+			}
+			err = v.VerifyBlob(ctx, bad.Digest)
+			if !errors.Is(err, ErrBlobCorrupted) {
+				t.Fatalf("VerifyBlob(bad) error = %v", err)
+			}
+			_, err = tt.storage.StatBlob(ctx, "foo/bar", bad.Digest)
+			if !errors.Is(err, ErrNotFound) {
+				t.Fatalf("StatBlob(bad) after VerifyBlob() error = %v", err)
+			}
+
+			manifest := &Manifest{
+				MediaType: types.OCIManifestSchema1,
+				Data:      []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`),
+			}
+			err = tt.storage.PutManifest(ctx, "foo/bar", "v1", manifest)
+			if err != nil {
+				t.Fatalf("PutManifest() error = %v", err)
+			}
+			var refs []string
+			err = v.WalkManifests(ctx, func(repo, tag string, m *Manifest) error {
+				if !reflect.DeepEqual(m, manifest) {
+					t.Fatalf("WalkManifests() got = %v, want %v", m, manifest)
+				}
+				refs = append(refs, repo+":"+tag)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("WalkManifests() error = %v", err)
+			}
+			if !reflect.DeepEqual(refs, []string{"foo/bar:v1"}) {
+				t.Fatalf("WalkManifests() got = %v", refs)
+			}
+		})
+	}
+}
+
+func TestReferrers(t *testing.T) {
+	ctx := context.Background()
+	s := NewLocalStorage(path.Join(t.TempDir(), "blobs"), path.Join(t.TempDir(), "manifests"))
+
+	subject, err := v1.NewHash("sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := Referrers(ctx, s, "foo/bar", subject)
+	if err != nil {
+		t.Fatalf("Referrers() error = %v", err)
+	}
+	if len(index.Manifests) != 0 {
+		t.Fatalf("Referrers() got = %v, want empty", index.Manifests)
+	}
+
+	plain := &Manifest{
+		MediaType: types.OCIManifestSchema1,
+		Data:      []byte(`{"schemaVersion":2,"config":{"mediaType":"application/vnd.oci.image.config.v1+json"}}`),
+	}
+	ok, err := AddReferrer(ctx, s, "foo/bar", plain)
+	if err != nil || ok {
+		t.Fatalf("AddReferrer() = %v, %v, want false", ok, err)
+	}
+
+	for _, data := range []string{
+		`{"schemaVersion":2,"artifactType":"application/vnd.in-toto+json","subject":{"digest":"` + subject.String() + `"}}`,
+		`{"schemaVersion":2,"config":{"mediaType":"application/vnd.dev.cosign.artifact.sig.v1+json"},"subject":{"digest":"` + subject.String() + `"},"annotations":{"k":"v"}}`,
+		`{"schemaVersion":2,"artifactType":"application/vnd.in-toto+json","subject":{"digest":"` + subject.String() + `"}}`,
+	} {
+		ok, err := AddReferrer(ctx, s, "foo/bar", &Manifest{MediaType: types.OCIManifestSchema1, Data: []byte(data)})
+		if err != nil || !ok {
+			t.Fatalf("AddReferrer() = %v, %v, want true", ok, err)
+		}
+	}
+
+	index, err = Referrers(ctx, s, "foo/bar", subject)
+	if err != nil {
+		t.Fatalf("Referrers() error = %v", err)
+	}
+	got := []string{}
+	for _, desc := range index.Manifests {
+		got = append(got, desc.ArtifactType)
+	}
+	want := []string{"application/vnd.in-toto+json", "application/vnd.dev.cosign.artifact.sig.v1+json"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Referrers() artifact types = %v, want %v", got, want)
+	}
+	if index.Manifests[1].Annotations["k"] != "v" {
+		t.Errorf("Referrers() annotations = %v", index.Manifests[1].Annotations)
+	}
+}
@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+)
+
+// ErrBlobCorrupted is returned when the content of a blob does not match its digest.
+var ErrBlobCorrupted = errors.New("blob corrupted")
+
+// Verifiable is implemented by the storages that can verify the integrity of their blobs,
+// the blobs on the local filesystem may be truncated or corrupted by a node reboot.
+type Verifiable interface {
+	// WalkBlobs calls fn with the digest of each blob.
+	WalkBlobs(ctx context.Context, fn func(digest v1.Hash) error) error
+	// WalkManifests calls fn with each tagged manifest, the manifest is nil if it is missing or unreadable.
+	WalkManifests(ctx context.Context, fn func(repo, tag string, manifest *Manifest) error) error
+	// VerifyBlob re-hashes the blob, a mismatched blob is moved to quarantine and ErrBlobCorrupted is returned.
+	VerifyBlob(ctx context.Context, digest v1.Hash) error
+}
+
+// verifyBlobFile re-hashes the blob file, and moves it to the quarantine directory on mismatch.
+func verifyBlobFile(blobPath, quarantinePath string, digest v1.Hash) error {
+	if digest.Algorithm != "sha256" {
+		return fmt.Errorf("unsupported digest algorithm %q", digest.Algorithm)
+	}
+
+	f, err := os.Open(blobPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		return err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(hash, f)
+	_ = f.Close()
+	if err != nil {
+		return err
+	}
+
+	got := hex.EncodeToString(hash.Sum(nil))
+	if got == digest.Hex {
+		return nil
+	}
+
+	err = os.MkdirAll(quarantinePath, 0755)
+	if err != nil {
+		return fmt.Errorf("mkdir: %w", err)
+	}
+	err = os.Rename(blobPath, path.Join(quarantinePath, digest.Algorithm+"-"+digest.Hex))
+	if err != nil {
+		return fmt.Errorf("quarantine: %w", err)
+	}
+	return fmt.Errorf("%w: %s has digest sha256:%s", ErrBlobCorrupted, digest, got)
+}
+
+func (l *localStorage) quarantinePath() string {
+	return path.Join(path.Dir(l.cacheBlobs), "quarantine")
+}
+
+func (l *localStorage) VerifyBlob(ctx context.Context, digest v1.Hash) error {
+	return verifyBlobFile(LocalBlobPath(l.cacheBlobs, digest.String()), l.quarantinePath(), digest)
+}
+
+func (l *localStorage) WalkBlobs(ctx context.Context, fn func(digest v1.Hash) error) error {
+	entries, err := os.ReadDir(l.cacheBlobs)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		digest, err := v1.NewHash(entry.Name())
+		if err != nil {
+			continue
+		}
+		err = ctx.Err()
+		if err != nil {
+			return err
+		}
+		err = fn(digest)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *localStorage) WalkManifests(ctx context.Context, fn func(repo, tag string, manifest *Manifest) error) error {
+	err := filepath.WalkDir(l.cacheManifest, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || d.Name() != "manifest.json" {
+			return nil
+		}
+		rel, err := filepath.Rel(l.cacheManifest, filepath.Dir(p))
+		if err != nil {
+			return err
+		}
+		repo, tag := path.Split(filepath.ToSlash(rel))
+		repo = strings.TrimSuffix(repo, "/")
+		if repo == "" {
+			return nil
+		}
+
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		manifest, _ := NewManifest(data)
+		err = ctx.Err()
+		if err != nil {
+			return err
+		}
+		return fn(repo, tag, manifest)
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *ociLayoutStorage) VerifyBlob(ctx context.Context, digest v1.Hash) error {
+	return verifyBlobFile(s.blobPath(digest), path.Join(s.root, "quarantine"), digest)
+}
+
+func (s *ociLayoutStorage) WalkBlobs(ctx context.Context, fn func(digest v1.Hash) error) error {
+	algorithms, err := os.ReadDir(path.Join(s.root, "blobs"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, algorithm := range algorithms {
+		if !algorithm.IsDir() {
+			continue
+		}
+		entries, err := os.ReadDir(path.Join(s.root, "blobs", algorithm.Name()))
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			digest, err := v1.NewHash(algorithm.Name() + ":" + entry.Name())
+			if err != nil {
+				continue
+			}
+			err = ctx.Err()
+			if err != nil {
+				return err
+			}
+			err = fn(digest)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *ociLayoutStorage) WalkManifests(ctx context.Context, fn func(repo, tag string, manifest *Manifest) error) error {
+	s.mut.Lock()
+	index, err := s.readIndex()
+	s.mut.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, desc := range index.Manifests {
+		refName := desc.Annotations[AnnotationRefName]
+		i := strings.LastIndex(refName, ":")
+		if i < 0 {
+			continue
+		}
+		repo, tag := refName[:i], refName[i+1:]
+
+		var manifest *Manifest
+		data, err := os.ReadFile(s.blobPath(desc.Digest))
+		if err == nil {
+			manifest, _ = NewManifest(data)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		err = ctx.Err()
+		if err != nil {
+			return err
+		}
+		err = fn(repo, tag, manifest)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
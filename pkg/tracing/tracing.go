@@ -0,0 +1,142 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/wzshiming/jitdi"
+
+func init() {
+	// The trace context is propagated even if the spans are not exported.
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+}
+
+// Setup exports the spans by OTLP over HTTP to the endpoint, such as http://localhost:4318,
+// without the endpoint it is taken from the OTEL_EXPORTER_OTLP_ENDPOINT and the OTEL_EXPORTER_OTLP_TRACES_ENDPOINT,
+// if none of them is set the spans are not exported. The returned func flushes and stops the exporting.
+func Setup(ctx context.Context, endpoint string) (func(context.Context) error, error) {
+	var opts []otlptracehttp.Option
+	if endpoint != "" {
+		opts = append(opts, otlptracehttp.WithEndpointURL(endpoint))
+	} else if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	provider, err := setup(ctx, exporter)
+	if err != nil {
+		return nil, err
+	}
+	return provider.Shutdown, nil
+}
+
+func setup(ctx context.Context, exporter sdktrace.SpanExporter) (*sdktrace.TracerProvider, error) {
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName("jitdi")),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider, nil
+}
+
+// Start starts a span with the attributes.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records the error if any and ends the span.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Handler traces the requests of the handler with the spans named the operation and the route of the requests,
+// the trace context of the requests is the parent of the spans.
+func Handler(operation string, route func(r *http.Request) string, next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, operation,
+		otelhttp.WithSpanNameFormatter(func(operation string, r *http.Request) string {
+			return operation + " " + route(r)
+		}),
+	)
+}
+
+// Transport traces the requests of the transport and propagates the trace context in their headers.
+func Transport(rt http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(rt)
+}
+
+// Reader returns the reader ending the span when it is closed, with the bytes read.
+func Reader(span trace.Span, rc io.ReadCloser) io.ReadCloser {
+	return &reader{
+		ReadCloser: rc,
+		span:       span,
+	}
+}
+
+type reader struct {
+	io.ReadCloser
+	span trace.Span
+	n    int64
+	err  error
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+func (r *reader) Close() error {
+	err := r.ReadCloser.Close()
+	r.span.SetAttributes(attribute.Int64("jitdi.bytes_read", r.n))
+	if r.err != nil {
+		End(r.span, r.err)
+	} else {
+		End(r.span, err)
+	}
+	return err
+}
+
+// WithParent returns the context with the span of the parent if the context has no span,
+// so the requests made without the context of the build are still traced under it.
+func WithParent(ctx, parent context.Context) context.Context {
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+	return trace.ContextWithSpan(ctx, trace.SpanFromContext(parent))
+}
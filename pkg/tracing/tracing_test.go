@@ -0,0 +1,112 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// setupTest exports the spans into the memory, the returned func flushes and returns them by name.
+func setupTest(t *testing.T) func() map[string]tracetest.SpanStub {
+	exporter := tracetest.NewInMemoryExporter()
+	provider, err := setup(context.Background(), exporter)
+	if err != nil {
+		t.Fatalf("setup() error = %v", err)
+	}
+	t.Cleanup(func() {
+		_ = provider.Shutdown(context.Background())
+	})
+	return func() map[string]tracetest.SpanStub {
+		err := provider.ForceFlush(context.Background())
+		if err != nil {
+			t.Fatalf("ForceFlush() error = %v", err)
+		}
+		spans := map[string]tracetest.SpanStub{}
+		for _, s := range exporter.GetSpans() {
+			spans[s.Name] = s
+		}
+		return spans
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	flush := setupTest(t)
+
+	server := httptest.NewServer(Handler("ServeHTTP", func(r *http.Request) string {
+		return "/test/{name}"
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Traceparent") == "" {
+			t.Errorf("request without the traceparent header")
+		}
+	})))
+	t.Cleanup(server.Close)
+
+	ctx, span := Start(context.Background(), "build")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/test/foo", nil)
+	if err != nil {
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+	resp, err := (&http.Client{Transport: Transport(http.DefaultTransport)}).Do(req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	End(span, nil)
+
+	spans := flush()
+	build, ok := spans["build"]
+	if !ok {
+		t.Fatalf("no span of the build in %v", spans)
+	}
+	serve, ok := spans["ServeHTTP /test/{name}"]
+	if !ok {
+		t.Fatalf("no span of the server in %v", spans)
+	}
+	if serve.SpanContext.TraceID() != build.SpanContext.TraceID() {
+		t.Fatalf("span of the server in trace %s, want %s", serve.SpanContext.TraceID(), build.SpanContext.TraceID())
+	}
+	if !serve.Parent.IsRemote() {
+		t.Fatalf("span of the server is not the child of the remote client span")
+	}
+}
+
+func TestReader(t *testing.T) {
+	flush := setupTest(t)
+
+	_, span := Start(context.Background(), "read")
+	rc := Reader(span, io.NopCloser(strings.NewReader("hello")))
+	_, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	_ = rc.Close()
+
+	_, span = Start(context.Background(), "failed")
+	End(span, errors.New("failed"))
+
+	spans := flush()
+	read, ok := spans["read"]
+	if !ok {
+		t.Fatalf("no span of the read in %v", spans)
+	}
+	var n int64
+	for _, attr := range read.Attributes {
+		if attr.Key == "jitdi.bytes_read" {
+			n = attr.Value.AsInt64()
+		}
+	}
+	if n != 5 {
+		t.Fatalf("bytes read got %d, want 5", n)
+	}
+	if got := spans["failed"].Status.Code; got != codes.Error {
+		t.Fatalf("status of the failed span got %v, want %v", got, codes.Error)
+	}
+}
@@ -0,0 +1,148 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+
+	"github.com/wzshiming/jitdi/pkg/apis/v1alpha1"
+	"github.com/wzshiming/jitdi/pkg/pattern"
+	"github.com/wzshiming/jitdi/pkg/signer"
+)
+
+// Image returns the errors of the spec joined, the warnings are the valid but likely mistaken fields.
+func Image(spec *v1alpha1.ImageSpec) (warnings []string, err error) {
+	var errs []error
+	if spec.Match == "" {
+		errs = append(errs, fmt.Errorf("match is required"))
+	}
+	if spec.BaseImage == "" {
+		errs = append(errs, fmt.Errorf("baseImage is required"))
+	}
+
+	rule, err := pattern.NewRule(spec)
+	if err != nil {
+		errs = append(errs, err)
+	} else {
+		errs = append(errs, rule.CheckParams())
+	}
+
+	for i, m := range spec.Mutates {
+		switch {
+		case m.File != nil && m.Ollama != nil:
+			errs = append(errs, fmt.Errorf("mutates[%d]: only one of file and ollama is allowed", i))
+		case m.File != nil:
+			if m.File.Source == "" || m.File.Destination == "" {
+				errs = append(errs, fmt.Errorf("mutates[%d]: file source and destination are required", i))
+			}
+			warning, err := checkMode(m.File.Mode)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("mutates[%d]: %w", i, err))
+			}
+			if warning != "" {
+				warnings = append(warnings, fmt.Sprintf("mutates[%d]: %s", i, warning))
+			}
+		case m.Ollama != nil:
+			if m.Ollama.Model == "" {
+				errs = append(errs, fmt.Errorf("mutates[%d]: ollama model is required", i))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("mutates[%d]: unknown type, expected file or ollama", i))
+		}
+	}
+
+	for i, p := range spec.Platforms {
+		if p.OS == "" || p.Architecture == "" {
+			errs = append(errs, fmt.Errorf("platforms[%d]: os and architecture are required", i))
+		}
+	}
+
+	if spec.Verification != nil {
+		errs = append(errs, checkVerification(spec.Verification))
+	}
+	return warnings, errors.Join(errs...)
+}
+
+// Registry returns the errors of the Registry of the name joined.
+func Registry(registry string, spec *v1alpha1.RegistrySpec) error {
+	var errs []error
+	_, err := name.NewRegistry(registry, name.StrictValidation)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("name is not a registry: %w", err))
+	}
+	if spec.Endpoint != "" {
+		u, err := url.Parse(spec.Endpoint)
+		if err != nil || u.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid endpoint %q", spec.Endpoint))
+		}
+	}
+	if spec.Authentication != nil && spec.Authentication.BaseAuth != nil && spec.Authentication.BaseAuth.Username == "" {
+		errs = append(errs, fmt.Errorf("authentication username is required"))
+	}
+	if spec.Verification != nil {
+		errs = append(errs, checkVerification(spec.Verification))
+	}
+	return errors.Join(errs...)
+}
+
+// Ambiguities returns the warnings of the Image whose match is ambiguous with the ones of the others,
+// the others with the same name are skipped, so the Image can be in the others.
+func Ambiguities(image *v1alpha1.Image, others []*v1alpha1.Image) []string {
+	rule, err := pattern.NewRule(&image.Spec)
+	if err != nil {
+		return nil
+	}
+	var warnings []string
+	for _, other := range others {
+		if other.Name == image.Name {
+			continue
+		}
+		r, err := pattern.NewRule(&other.Spec)
+		if err != nil {
+			continue
+		}
+		if rule.Ambiguous(r) {
+			warnings = append(warnings, fmt.Sprintf("match %q is ambiguous with %q of the Image %q, which one is used is undefined",
+				image.Spec.Match, other.Spec.Match, other.Name))
+		}
+	}
+	return warnings
+}
+
+// checkMode returns an error if the mode is not an integer of the permission bits as it is parsed by the build,
+// the templated mode is checked by the build.
+func checkMode(mode string) (warning string, err error) {
+	if mode == "" || strings.Contains(mode, "{") {
+		return "", nil
+	}
+	m, err := strconv.ParseInt(mode, 0, 0)
+	if err != nil || m < 0 || m > 07777 {
+		return "", fmt.Errorf("invalid mode %q, expected an octal such as 0755", mode)
+	}
+	if m > 7 && !strings.HasPrefix(mode, "0") {
+		return fmt.Sprintf("mode %q is decimal, it is %#o", mode, m), nil
+	}
+	return "", nil
+}
+
+func checkVerification(v *v1alpha1.Verification) error {
+	var errs []error
+	for i, key := range v.PublicKeys {
+		_, err := signer.NewVerifier([]byte(key))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("verification publicKeys[%d]: %w", i, err))
+		}
+	}
+	for _, digest := range v.Digests {
+		_, err := v1.NewHash(digest)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("verification digest %q: %w", digest, err))
+		}
+	}
+	return errors.Join(errs...)
+}
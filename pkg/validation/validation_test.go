@@ -0,0 +1,108 @@
+package validation
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/wzshiming/jitdi/pkg/apis/v1alpha1"
+)
+
+func TestImage(t *testing.T) {
+	tests := []struct {
+		name        string
+		spec        v1alpha1.ImageSpec
+		wantErr     bool
+		wantWarning bool
+	}{
+		{
+			name: "valid",
+			spec: v1alpha1.ImageSpec{
+				Match:     "k8s/{base}/{file}:{tag}",
+				BaseImage: "docker.io/library/{base}:latest",
+				Mutates: []v1alpha1.Mutate{
+					{File: &v1alpha1.File{Source: "https://dl.k8s.io/{tag}/bin/{GOOS}/{GOARCH}/{file}", Destination: "/usr/local/bin/{file}", Mode: "0755"}},
+				},
+			},
+		},
+		{
+			name: "invalid pattern",
+			spec: v1alpha1.ImageSpec{
+				Match:     "k8s/{file:[0-9}:{tag}",
+				BaseImage: "docker.io/library/alpine",
+			},
+			wantErr: true,
+		},
+		{
+			name: "undefined param",
+			spec: v1alpha1.ImageSpec{
+				Match:     "k8s/{file}:{tag}",
+				BaseImage: "docker.io/library/{base}",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid mode",
+			spec: v1alpha1.ImageSpec{
+				Match:     "k8s/{file}:{tag}",
+				BaseImage: "docker.io/library/alpine",
+				Mutates: []v1alpha1.Mutate{
+					{File: &v1alpha1.File{Source: "https://dl.k8s.io/{file}", Destination: "/{file}", Mode: "rwxr-xr-x"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "decimal mode",
+			spec: v1alpha1.ImageSpec{
+				Match:     "k8s/{file}:{tag}",
+				BaseImage: "docker.io/library/alpine",
+				Mutates: []v1alpha1.Mutate{
+					{File: &v1alpha1.File{Source: "https://dl.k8s.io/{file}", Destination: "/{file}", Mode: "755"}},
+				},
+			},
+			wantWarning: true,
+		},
+		{
+			name: "unknown mutate",
+			spec: v1alpha1.ImageSpec{
+				Match:     "k8s/{file}:{tag}",
+				BaseImage: "docker.io/library/alpine",
+				Mutates:   []v1alpha1.Mutate{{}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warnings, err := Image(&tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Image() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (len(warnings) != 0) != tt.wantWarning {
+				t.Errorf("Image() warnings = %v, wantWarning %v", warnings, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestAmbiguities(t *testing.T) {
+	newImage := func(name, match string) *v1alpha1.Image {
+		return &v1alpha1.Image{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       v1alpha1.ImageSpec{Match: match},
+		}
+	}
+	images := []*v1alpha1.Image{
+		newImage("a", "k8s/{image}:{tag}"),
+		newImage("b", "k8s/{name}:{version}"),
+		newImage("c", "ollama/{model}:{tag}"),
+	}
+
+	if got := Ambiguities(images[0], images); len(got) != 1 {
+		t.Errorf("Ambiguities() = %v, want 1 warning", got)
+	}
+	if got := Ambiguities(images[2], images); len(got) != 0 {
+		t.Errorf("Ambiguities() = %v, want no warning", got)
+	}
+}